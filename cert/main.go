@@ -18,8 +18,18 @@ import (
 
 const (
 	keysPairDir = "./"
-	certName    = "cert.pem"
-	keyName     = "key.pem"
+
+	// Корневой сертификат для подписи сертификатов сервера и агентов
+	caCertName = "ca.pem"
+	caKeyName  = "ca-key.pem"
+
+	// Сертификат сервера, ключи также используются для шифрования тела запросов
+	certName = "cert.pem"
+	keyName  = "key.pem"
+
+	// Сертификат агента для mTLS
+	clientCertName = "client-cert.pem"
+	clientKeyName  = "client-key.pem"
 )
 
 func main() {
@@ -27,39 +37,90 @@ func main() {
 		log.Printf("Check Certificate Error: %s", err.Error())
 		log.Printf("Creating new keys pair")
 
-		if err = generateCert(); err != nil {
+		if err = generateCerts(); err != nil {
 			log.Fatalf("Generate Certificate Error: %s", err.Error())
 		}
 	}
 }
 
-// Генерация сертификата
-func generateCert() error {
-	// Шаблон сертификата
-	cert := &x509.Certificate{
-		SerialNumber: big.NewInt(time.Now().Unix()),
-		Subject: pkix.Name{
-			Organization: []string{"Ya Praktikum"},
-			Country:      []string{"RU"},
-		},
-		IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
-		NotBefore:   time.Now(),
-		NotAfter:    time.Now().AddDate(0, 0, 1),
+// Генерация корневого сертификата, сертификатов сервера и агента
+func generateCerts() error {
+	subject := pkix.Name{
+		Organization: []string{"Ya Praktikum"},
+		Country:      []string{"RU"},
+	}
+
+	// Шаблон корневого сертификата
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               subject,
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().AddDate(10, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	ca.Subject.CommonName = "metrics CA"
+
+	caKey, err := generateCert(ca, nil, nil, caCertName, caKeyName)
+	if err != nil {
+		return fmt.Errorf("failed generate CA: %w", err)
+	}
+
+	// Шаблон сертификата сервера
+	server := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano() + 1),
+		Subject:      subject,
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	server.Subject.CommonName = "metrics server"
+
+	if _, err = generateCert(server, ca, caKey, certName, keyName); err != nil {
+		return fmt.Errorf("failed generate server certificate: %w", err)
+	}
+
+	// Шаблон сертификата агента
+	client := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano() + 2),
+		Subject:      subject,
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().AddDate(1, 0, 0),
+
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+	client.Subject.CommonName = "metrics agent"
 
-		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
-		KeyUsage:    x509.KeyUsageDigitalSignature & x509.KeyUsageKeyEncipherment,
+	if _, err = generateCert(client, ca, caKey, clientCertName, clientKeyName); err != nil {
+		return fmt.Errorf("failed generate client certificate: %w", err)
 	}
 
+	return nil
+}
+
+// Генерация сертификата и закрытого ключа.
+// Без родительского сертификата создается самоподписанный
+func generateCert(cert *x509.Certificate, parent *x509.Certificate, parentKey *rsa.PrivateKey, certFile string, keyFile string) (*rsa.PrivateKey, error) {
 	// Генерация приватного ключа
 	privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
 	if err != nil {
-		return fmt.Errorf("failed generate private key: %w", err)
+		return nil, fmt.Errorf("failed generate private key: %w", err)
+	}
+
+	if parent == nil {
+		parent, parentKey = cert, privateKey
 	}
 
 	// Создание сертификата
-	certBytes, err := x509.CreateCertificate(rand.Reader, cert, cert, &privateKey.PublicKey, privateKey)
+	certBytes, err := x509.CreateCertificate(rand.Reader, cert, parent, &privateKey.PublicKey, parentKey)
 	if err != nil {
-		return fmt.Errorf("failed create certificate: %w", err)
+		return nil, fmt.Errorf("failed create certificate: %w", err)
 	}
 
 	// Шифрование блока сертификата
@@ -68,16 +129,16 @@ func generateCert() error {
 		Type:  "CERTIFICATE",
 		Bytes: certBytes,
 	}); err != nil {
-		return fmt.Errorf("failed pem encode certificate: %w", err)
+		return nil, fmt.Errorf("failed pem encode certificate: %w", err)
 	}
 
 	// Шифрование блока закрытого ключа
 	var keyPEM bytes.Buffer
 	if err = pem.Encode(&keyPEM, &pem.Block{
-		Type:  "PRIVATE KEY",
+		Type:  "RSA PRIVATE KEY",
 		Bytes: x509.MarshalPKCS1PrivateKey(privateKey),
 	}); err != nil {
-		return fmt.Errorf("failed pem encode private key: %w", err)
+		return nil, fmt.Errorf("failed pem encode private key: %w", err)
 	}
 
 	// Парсинг директории ключей и создание
@@ -85,35 +146,30 @@ func generateCert() error {
 	if len(keyPath) > 1 {
 		container := strings.Join(keyPath[:len(keyPath)-1], "/")
 		if err = os.MkdirAll(container, 0755); err != nil {
-			return fmt.Errorf("failed create container directory: %w", err)
+			return nil, fmt.Errorf("failed create container directory: %w", err)
 		}
 	}
 
 	// Запись приватного ключа
-	if err = os.WriteFile(keysPairDir+keyName, keyPEM.Bytes(), 0644); err != nil {
-		return fmt.Errorf("failed write tls private key: %w", err)
+	if err = os.WriteFile(keysPairDir+keyFile, keyPEM.Bytes(), 0600); err != nil {
+		return nil, fmt.Errorf("failed write tls private key: %w", err)
 	}
 
 	// Запись серификата
-	if err = os.WriteFile(keysPairDir+certName, certPEM.Bytes(), 0644); err != nil {
-		return fmt.Errorf("failed write tls public key: %w", err)
+	if err = os.WriteFile(keysPairDir+certFile, certPEM.Bytes(), 0644); err != nil {
+		return nil, fmt.Errorf("failed write tls public key: %w", err)
 	}
 
-	return nil
+	return privateKey, nil
 }
 
-// Проверяет наличие ключей и сертификата
-// Пересоздает пару, в случае отсутствия любого из ключей
+// Проверяет наличие ключей и сертификатов
+// Пересоздает все пары, в случае отсутствия любого из файлов
 func checkCert() error {
-	_, errKey := os.ReadFile(keysPairDir + keyName)
-	if errKey != nil {
-		return fmt.Errorf("error reading tls private key: %w", errKey)
-
-	}
-
-	_, errCert := os.ReadFile(keysPairDir + certName)
-	if errCert != nil {
-		return fmt.Errorf("error reading tls public key: %w", errCert)
+	for _, name := range []string{caKeyName, caCertName, keyName, certName, clientKeyName, clientCertName} {
+		if _, err := os.ReadFile(keysPairDir + name); err != nil {
+			return fmt.Errorf("error reading %s: %w", name, err)
+		}
 	}
 
 	return nil
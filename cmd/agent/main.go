@@ -4,14 +4,13 @@ import (
 	"context"
 	"fmt"
 	"log"
-	"net/http"
-	_ "net/http/pprof"
 	"os/signal"
 	"sync"
 	"syscall"
 
 	"metrics/internal/agent"
 	"metrics/internal/agent/config"
+	"metrics/pkg/admin"
 )
 
 var (
@@ -31,8 +30,10 @@ func main() {
 		log.Fatal("Build Agent Config Error:", err)
 	}
 
-	// Инициализация инстанса агента
-	agentInstance := agent.NewAgent(cfg)
+	// Профилировщик без токена раскрывает память процесса, поэтому служебный слушатель не запускается
+	if cfg.Admin.Address != "" && cfg.Admin.Token == "" {
+		log.Fatalf("admin listener on %s requires admin token", cfg.Admin.Address)
+	}
 
 	// Создание контекста с сигналами
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGKILL)
@@ -40,6 +41,23 @@ func main() {
 
 	// Создание группы ожидания
 	wg := &sync.WaitGroup{}
+
+	// Регистрация агента на сервере и продление сертификата
+	if cfg.Enroll.Dir != "" {
+		renew, err := agent.Enroll(ctx, cfg)
+		if err != nil {
+			log.Fatal("Agent Enroll Error:", err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			renew(ctx)
+		}()
+	}
+
+	// Инициализация инстанса агента
+	agentInstance := agent.NewAgent(cfg)
 	wg.Add(1)
 
 	// Запуск агента
@@ -48,20 +66,16 @@ func main() {
 		agentInstance.Run(ctx)
 	}()
 
-	// Запуск сервера профилирования
-	srv := http.Server{Addr: ":30012"}
-	go func() {
-		if err = srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatal("HTTP Server Error:", err)
-		}
-	}()
-
-	// Ожидание сигнала
-	<-ctx.Done()
-
-	// Остановка сервера
-	if err = srv.Shutdown(ctx); err != nil && err != context.Canceled {
-		log.Fatal("HTTP Server Shutdown Failed:", err)
+	// Запуск служебного слушателя с профилировщиком, по умолчанию отключен
+	if cfg.Admin.Address != "" {
+		adminSRV := admin.New(cfg.Admin.Address, admin.StaticToken(cfg.Admin.Token))
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := adminSRV.Run(ctx); err != nil {
+				log.Println(err)
+			}
+		}()
 	}
 
 	// Ожидание завершения горутин
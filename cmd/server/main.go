@@ -10,7 +10,9 @@ import (
 	"metrics/internal/storage"
 
 	"metrics/internal/server"
+	"metrics/internal/server/agents"
 	"metrics/internal/server/config"
+	"metrics/internal/server/tokens"
 	"metrics/pkg/logger"
 )
 
@@ -43,10 +45,24 @@ func main() {
 	storageInstance := storage.NewStorage(cfg.DB.Address, cfg.FileStorage.FileStoragePath)
 	defer storageInstance.Closer()
 
+	// Инициализация хранилища ключей агентов
+	agentKeys, err := agents.New(cfg.AgentKeys.File, cfg.AgentKeys.FromDB, storageInstance.AgentKeys)
+	if err != nil {
+		log.Fatal("Build Agent Keys Error:", err)
+	}
+
+	// Инициализация хранилища токенов API
+	apiTokens, err := tokens.New(cfg.Tokens.File, cfg.Tokens.FromDB, storageInstance.Tokens)
+	if err != nil {
+		log.Fatal("Build API Tokens Error:", err)
+	}
+
 	serverInstance := server.New(
 		storageInstance.APIStorageCommands,
 		storageInstance.GRPCStorageCommands,
 		storageInstance.MetricsFileStorage,
+		agentKeys,
+		apiTokens,
 		loggerInstance,
 		cfg,
 	)
@@ -3,34 +3,42 @@ package agent
 
 import (
 	"context"
-	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 
 	"metrics/internal/agent/collector"
 	"metrics/internal/agent/config"
+	"metrics/internal/agent/enroll"
 	grpcClient "metrics/internal/agent/grpc"
 	httpClient "metrics/internal/agent/http"
 	"metrics/internal/models"
 	pb "metrics/internal/server/proto"
 	"metrics/pkg"
+	"metrics/pkg/envelope"
+	"metrics/pkg/tlsconfig"
 )
 
 const (
-	protocol = "http://"
-	attempts = 3
-	interval = 2 * time.Second
+	protocol    = "http://"
+	protocolTLS = "https://"
+	attempts    = 3
+	interval    = 2 * time.Second
 )
 
 // UpdatesPoster интерфейс отправки метрик для HTTP и gRPC клиентов
@@ -38,6 +46,16 @@ type UpdatesPoster interface {
 	PostUpdates(context.Context, []byte) error
 }
 
+// MetricsPoster интерфейс отправки типизированных метрик, реализуется gRPC клиентом
+type MetricsPoster interface {
+	PostMetrics(context.Context, []*models.Data) error
+}
+
+// streamer интерфейс клиента, передающего метрики через долгоживущий стрим
+type streamer interface {
+	Streaming() bool
+}
+
 // Agent - структура агента
 type Agent struct {
 	client         UpdatesPoster
@@ -47,22 +65,101 @@ type Agent struct {
 	statsBuf       collector.StatsBuf
 	rateLimit      int
 	certFile       string
+	instanceID     string
+}
+
+// Enroll регистрирует агента на сервере по HTTPS или читает сохраненную регистрацию.
+// Подставляет в конфигурацию выданные сертификат, HMAC ключ и идентификатор агента
+// и возвращает функцию продления сертификата
+func Enroll(ctx context.Context, cfg *config.AgentConfig) (func(context.Context), error) {
+	enroller := enroll.New(cfg.Enroll.Dir)
+
+	// Соединение без сертификата клиента, сервер проверяется по CA из конфигурации или по сохраненному CA
+	caFile := cfg.TLS.CAFile
+	if caFile == "" {
+		if stored := filepath.Join(cfg.Enroll.Dir, "ca.pem"); fileExists(stored) {
+			caFile = stored
+		}
+	}
+	tlsConfig, err := tlsconfig.NewClient(caFile, "", "")
+	if err != nil {
+		return nil, err
+	}
+
+	restyClient := resty.New().SetTLSClientConfig(tlsConfig)
+	baseURL := protocolTLS + cfg.Host.String()
+
+	identity, err := enroller.Ensure(ctx, httpClient.New(restyClient, baseURL, "", attempts, interval), cfg.Enroll.Token)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg.InstanceID = identity.AgentID
+	cfg.Key = identity.Key
+	cfg.TLS.CertFile = identity.CertFile
+	cfg.TLS.KeyFile = identity.KeyFile
+	if cfg.TLS.CAFile == "" {
+		cfg.TLS.CAFile = identity.CAFile
+	}
+
+	// Продление подписывается ключом агента и передается с текущим сертификатом агента,
+	// который перечитывается после каждого продления
+	if caFile == "" {
+		caFile = identity.CAFile
+	}
+	renewTLSConfig, err := tlsconfig.NewClient(caFile, identity.CertFile, identity.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	renewClient := httpClient.New(resty.New().SetTLSClientConfig(renewTLSConfig), baseURL, identity.Key, attempts, interval)
+
+	return func(ctx context.Context) {
+		enroller.Run(ctx, renewClient, identity)
+	}, nil
+}
+
+// fileExists сообщает, существует ли файл
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
 }
 
 // NewAgent - конструктор агента
 func NewAgent(cfg *config.AgentConfig) *Agent {
 	var client UpdatesPoster
 
+	// Конфигурация TLS соединения с сервером
+	var tlsConfig *tls.Config
+	if cfg.TLS != nil && cfg.TLS.Enabled() {
+		var err error
+		if tlsConfig, err = tlsconfig.NewClient(cfg.TLS.CAFile, cfg.TLS.CertFile, cfg.TLS.KeyFile); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	if cfg.Host.GRPCPort != "" {
-		interceptors := grpcClient.NewInterceptors(cfg.Key)
-		conn, err := grpc.NewClient(":"+cfg.Host.GRPCPort, interceptors, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		creds := insecure.NewCredentials()
+		if tlsConfig != nil {
+			creds = credentials.NewTLS(tlsConfig)
+		}
+
+		opts := append(grpcClient.NewInterceptors(cfg.Key, cfg.Token), grpc.WithTransportCredentials(creds))
+		conn, err := grpc.NewClient(net.JoinHostPort(cfg.Host.Address, cfg.Host.GRPCPort), opts...)
 		if err != nil {
 			log.Fatal(err)
 		}
-		client = grpcClient.New(pb.NewHandlersClient(conn), attempts, interval)
+		client = grpcClient.New(pb.NewHandlersClient(conn), attempts, interval, cfg.GRPCStream)
 	} else {
 		baseURL := protocol + cfg.Host.String()
-		client = httpClient.New(resty.New(), baseURL, cfg.Key, attempts, interval)
+		restyClient := resty.New()
+		if tlsConfig != nil {
+			baseURL = protocolTLS + cfg.Host.String()
+			restyClient.SetTLSClientConfig(tlsConfig)
+		}
+		if cfg.Token != "" {
+			restyClient.SetAuthToken(cfg.Token)
+		}
+		client = httpClient.New(restyClient, baseURL, cfg.Key, attempts, interval)
 	}
 
 	return &Agent{
@@ -74,8 +171,9 @@ func NewAgent(cfg *config.AgentConfig) *Agent {
 				Data: make(map[string]interface{}),
 			},
 		),
-		rateLimit: cfg.RateLimit,
-		certFile:  cfg.CryptoKey,
+		rateLimit:  cfg.RateLimit,
+		certFile:   cfg.CryptoKey,
+		instanceID: cfg.InstanceID,
 	}
 }
 
@@ -148,6 +246,10 @@ func (a *Agent) Run(ctx context.Context) {
 							log.Printf("Worker: %d, Failed sending metric: %s", r.worker, r.err.Error())
 							continue
 						}
+						if r.queued {
+							log.Printf("Worker: %d Metric queued to stream", r.worker)
+							continue
+						}
 						log.Printf("Worker: %d Metric sent", r.worker)
 					}
 				}(res)
@@ -155,6 +257,13 @@ func (a *Agent) Run(ctx context.Context) {
 		}
 	}()
 	wg.Wait()
+
+	// Закрытие долгоживущего соединения клиента
+	if closer, ok := a.client.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			log.Println("Close client err:", err)
+		}
+	}
 }
 
 // Метод отправки запроса
@@ -172,18 +281,41 @@ func (a *Agent) postWorker(i int, jobs <-chan *metricJob, res chan<- *jobRespons
 		result := &jobResponse{
 			worker: i,
 		}
+		// Передача id раннера и инстанса агента в запрос
+		ctx := context.WithValue(context.Background(), pkg.ContextKey{}, i)
+		ctx = context.WithValue(ctx, pkg.InstanceKey{}, data.instance)
+
+		// Отправка типизированных метрик, если клиент поддерживает и шифрование не требуется
+		if poster, ok := a.client.(MetricsPoster); ok && a.certFile == "" {
+			// Стрим передает только типизированные метрики, зашифрованные отправляются отдельными запросами
+			if s, ok := a.client.(streamer); ok {
+				result.queued = s.Streaming()
+			}
+			if err = poster.PostMetrics(ctx, data.metrics); err != nil {
+				log.Printf("Worker %d: PostMetrics failed: %s", i, err)
+				result.err = fmt.Errorf("post metrics failed: %w", err)
+			}
+
+			res <- result
+			continue
+		}
 
 		// Обработка тела запроса
 		var body []byte
-		body, err = a.encryptRequest(*data.data)
+		var keyID string
+		body, keyID, err = a.encryptRequest(*data.data)
 		if err != nil {
 			result.err = fmt.Errorf("encrypt failed: %w", err)
 			res <- result
 			continue
 		}
 
-		// Передача id раннера в запрос
-		ctx := context.WithValue(context.Background(), pkg.ContextKey{}, i)
+		// Передача версии схемы шифрования и идентификатора ключа в запрос
+		if a.certFile != "" {
+			ctx = context.WithValue(ctx, pkg.EncryptionKey{}, envelope.Version)
+			ctx = context.WithValue(ctx, pkg.KeyIDKey{}, keyID)
+		}
+
 		if err = a.client.PostUpdates(ctx, body); err != nil {
 			log.Printf("Worker %d: PostUpdates failed: %s", i, err)
 			result.err = fmt.Errorf("post updates failed: %w", err)
@@ -203,59 +335,57 @@ func (a *Agent) sendMetricsBatch(jobs chan<- *metricJob) error {
 		return fmt.Errorf("marshal metrics error: %w", err)
 	}
 
-	// Запись метрик в канал с заданиями
-
-	jobs <- &metricJob{data: &data}
+	// Запись метрик в канал с заданиями с отметкой инстанса агента
+	jobs <- &metricJob{data: &data, metrics: a.metrics, instance: a.instanceID}
 
 	return nil
 }
 
-// Шифрует тело запроса при наличии флага сертификата
-func (a *Agent) encryptRequest(body []byte) ([]byte, error) {
+// Шифрует тело запроса при наличии флага сертификата,
+// возвращает идентификатор ключа сертификата для выбора ключа на сервере
+func (a *Agent) encryptRequest(body []byte) ([]byte, string, error) {
 	// Пропуск обработки, если флаг не задан
 	if a.certFile == "" {
-		return body, nil
+		return body, "", nil
 	}
 
 	// Чтение pem файла
 	certPEM, err := os.ReadFile(a.certFile)
 	if err != nil {
-		return nil, fmt.Errorf("error reading tls public key: %w", err)
+		return nil, "", fmt.Errorf("error reading tls public key: %w", err)
 	}
 	// Поиск блока сертификата
 	pubKeyBlock, _ := pem.Decode(certPEM)
+	if pubKeyBlock == nil {
+		return nil, "", fmt.Errorf("no pem block found in tls public key")
+	}
 	// Парсинг сертификата
 	parsedCert, err := x509.ParseCertificate(pubKeyBlock.Bytes)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing tls public key: %w", err)
+		return nil, "", fmt.Errorf("error parsing tls public key: %w", err)
 	}
 	// Присвоение публичного ключа
-	pubKey := parsedCert.PublicKey
+	pubKey, ok := parsedCert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, "", fmt.Errorf("tls public key is not RSA")
+	}
 
 	// Проверка срока истечения сертификата
 	if parsedCert.NotAfter.Before(time.Now()) {
-		return nil, fmt.Errorf("tls public key expired")
+		return nil, "", fmt.Errorf("tls public key expired")
 	}
 
-	// Установка длины частей шифрования
-	blockLen := pubKey.(*rsa.PublicKey).Size() - 11
-
-	// Шифрование тела запроса частями
-	var encryptedBytes []byte
-	for start := 0; start < len(body); start += blockLen {
-		end := start + blockLen
-		if start+blockLen > len(body) {
-			end = len(body)
-		}
-
-		var encryptedChunk []byte
-		encryptedChunk, err = rsa.EncryptPKCS1v15(rand.Reader, pubKey.(*rsa.PublicKey), body[start:end])
-		if err != nil {
-			return nil, fmt.Errorf("error encrypting random text: %w", err)
-		}
+	// Идентификатор ключа
+	keyID, err := envelope.KeyID(pubKey)
+	if err != nil {
+		return nil, "", fmt.Errorf("error building key id: %w", err)
+	}
 
-		encryptedBytes = append(encryptedBytes, encryptedChunk...)
+	// Шифрование тела запроса конвертом
+	encryptedBytes, err := envelope.Seal(pubKey, body)
+	if err != nil {
+		return nil, "", fmt.Errorf("error encrypting request body: %w", err)
 	}
 
-	return encryptedBytes, nil
+	return encryptedBytes, keyID, nil
 }
@@ -13,12 +13,21 @@ import (
 	"metrics/internal/models"
 )
 
+const (
+	// cpuMetric - метрика загрузки ЦПУ, номер ЦПУ передается меткой cpuLabel
+	cpuMetric = "CPUutilization"
+	cpuLabel  = "cpu"
+)
+
 // StatsBuf - тип функция-замыкание для сбора метрик и хранения счетчика
 type StatsBuf func() *Stats
 
 // Stats - структура метрик
 type Stats struct {
 	Data map[string]interface{}
+
+	// Загрузка каждого ЦПУ по его номеру
+	CPU []float64
 }
 
 // CollectMetrics собирает метрики и ведет счетчик
@@ -80,11 +89,8 @@ func CollectMetrics(statsBuf *Stats) StatsBuf {
 		(statsBuf.Data)["TotalMemory"] = float64(vmStats.Total)
 		(statsBuf.Data)["FreeMemory"] = float64(vmStats.Free)
 
-		// Генератор статистики по каждому ЦПУ
-		for i, cpuStat := range cpuStats {
-			recordString := "CPUutilization" + strconv.Itoa(i+1)
-			(statsBuf.Data)[recordString] = cpuStat
-		}
+		// Статистика по каждому ЦПУ
+		statsBuf.CPU = cpuStats
 
 		// Увеличение счетчика
 		counter++
@@ -109,5 +115,16 @@ func (s *Stats) BuildMetrics() []*models.Data {
 		res = append(res, metric)
 	}
 
+	// Загрузка ЦПУ - одна метрика с номером ЦПУ в метке
+	for i, utilization := range s.CPU {
+		value := utilization
+		res = append(res, &models.Data{
+			Name:   cpuMetric,
+			Type:   "gauge",
+			Value:  &value,
+			Labels: map[string]string{cpuLabel: strconv.Itoa(i)},
+		})
+	}
+
 	return res
 }
@@ -2,23 +2,41 @@ package collector
 
 import (
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
+func TestStats_BuildMetricsCPULabels(t *testing.T) {
+	stats := &Stats{
+		Data: map[string]interface{}{"Alloc": float64(1), "PollCount": int64(2)},
+		CPU:  []float64{10, 20},
+	}
+
+	cpu := make(map[string]float64)
+	for _, metric := range stats.BuildMetrics() {
+		if metric.Name != cpuMetric {
+			continue
+		}
+		assert.Equal(t, "gauge", metric.Type)
+		cpu[metric.Labels[cpuLabel]] = *metric.Value
+	}
+
+	// Загрузка каждого ЦПУ передается одной метрикой с номером ЦПУ в метке
+	assert.Equal(t, map[string]float64{"0": 10, "1": 20}, cpu)
+}
+
 func BenchmarkCollectMetrics(b *testing.B) {
-	buf := CollectMetrics
-	stats := &Stats{}
+	buf := CollectMetrics(&Stats{Data: make(map[string]interface{})})
 
 	b.Run("collect metrics", func(b *testing.B) {
 		for n := 0; n < b.N; n++ {
-			buf(stats)
+			buf()
 		}
 	})
 }
 
 func BenchmarkBuildMetrics(b *testing.B) {
-	buf := CollectMetrics
-	stats := &Stats{}
-	buf(stats)
+	stats := CollectMetrics(&Stats{Data: make(map[string]interface{})})()
 
 	b.Run("build metrics", func(b *testing.B) {
 		for n := 0; n < b.N; n++ {
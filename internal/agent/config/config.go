@@ -20,6 +20,12 @@ type AgentConfig struct {
 	RateLimit      int
 	Key            string
 	CryptoKey      string
+	InstanceID     string
+	GRPCStream     bool
+	Token          string
+	TLS            *TLS
+	Admin          *Admin
+	Enroll         *Enroll
 }
 type Host struct {
 	Address  string
@@ -27,10 +33,34 @@ type Host struct {
 	GRPCPort string
 }
 
+// Admin - структура конфигурации служебного слушателя с профилировщиком
+type Admin struct {
+	Address string
+	Token   string
+}
+
+// Enroll - структура конфигурации регистрации агента на сервере
+type Enroll struct {
+	Token string
+	Dir   string
+}
+
+// TLS - структура конфигурации TLS соединения с сервером
+type TLS struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// Enabled сообщает, включен ли TLS
+func (t *TLS) Enabled() bool {
+	return t.CAFile != "" || t.CertFile != "" || t.KeyFile != ""
+}
+
 // New - конструктор конфигурации агента
 func New() (*AgentConfig, error) {
 	var err error
-	config := &AgentConfig{Host: &Host{}}
+	config := &AgentConfig{Host: &Host{}, TLS: &TLS{}, Admin: &Admin{}, Enroll: &Enroll{}}
 
 	// Парсинг флагов
 	config.parseFlags()
@@ -47,6 +77,13 @@ func New() (*AgentConfig, error) {
 		return nil, fmt.Errorf("error parsing environment variables: %w", err)
 	}
 
+	// Идентификатор инстанса по умолчанию - имя хоста
+	if config.InstanceID == "" {
+		if config.InstanceID, err = os.Hostname(); err != nil {
+			return nil, fmt.Errorf("error getting hostname for instance id: %w", err)
+		}
+	}
+
 	return config, nil
 }
 
@@ -73,6 +110,28 @@ func (a *AgentConfig) parseFlags() {
 	// Флаг файла конфигурации
 	flag.StringVar(&a.ConfigFile, "config", "", "Config file")
 
+	// Флаг идентификатора инстанса
+	flag.StringVar(&a.InstanceID, "instance", "", "Instance ID attached to every metrics batch. Default: hostname")
+
+	// Флаг передачи метрик через gRPC стрим
+	flag.BoolVar(&a.GRPCStream, "grpc-stream", false, "Send unencrypted metrics through one long-lived gRPC stream")
+
+	// Флаг токена API
+	flag.StringVar(&a.Token, "token", "", "Bearer token with write scope sent to the server")
+
+	// Флаги служебного слушателя
+	flag.StringVar(&a.Admin.Address, "admin-address", "", "Address of admin listener with pprof. Example: \"localhost:30012\". Default: disabled")
+	flag.StringVar(&a.Admin.Token, "admin-token", "", "Bearer token for admin listener, required with admin-address")
+
+	// Флаги регистрации агента
+	flag.StringVar(&a.Enroll.Dir, "enroll-dir", "", "Directory to store enrolled certificate and agent key, enables enrollment over HTTPS")
+	flag.StringVar(&a.Enroll.Token, "enroll-token", "", "One-time bootstrap token for the first enrollment")
+
+	// Флаги TLS
+	flag.StringVar(&a.TLS.CAFile, "tls-ca", "", "Path to CA file to verify server certificate")
+	flag.StringVar(&a.TLS.CertFile, "tls-cert", "", "Path to TLS client certificate file")
+	flag.StringVar(&a.TLS.KeyFile, "tls-key", "", "Path to TLS client private key file")
+
 	_ = flag.Value(a.Host)
 	flag.Var(a.Host, "a", "Host and port on which to listen. Example: \"localhost:8081\" or \":8081\"")
 
@@ -128,6 +187,50 @@ func (a *AgentConfig) parseEnv() error {
 		a.Host.GRPCPort = grpcPort
 	}
 
+	if instanceID := os.Getenv("INSTANCE_ID"); instanceID != "" {
+		a.InstanceID = instanceID
+	}
+
+	if grpcStream := os.Getenv("GRPC_STREAM"); grpcStream != "" {
+		stream, err := strconv.ParseBool(grpcStream)
+		if err != nil {
+			return fmt.Errorf("invalid GRPC_STREAM to bool conversion: %w", err)
+		}
+		a.GRPCStream = stream
+	}
+
+	if token := os.Getenv("TOKEN"); token != "" {
+		a.Token = token
+	}
+
+	if adminAddress := os.Getenv("ADMIN_ADDRESS"); adminAddress != "" {
+		a.Admin.Address = adminAddress
+	}
+
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		a.Admin.Token = adminToken
+	}
+
+	if enrollDir := os.Getenv("ENROLL_DIR"); enrollDir != "" {
+		a.Enroll.Dir = enrollDir
+	}
+
+	if enrollToken := os.Getenv("ENROLL_TOKEN"); enrollToken != "" {
+		a.Enroll.Token = enrollToken
+	}
+
+	if tlsCA := os.Getenv("TLS_CA"); tlsCA != "" {
+		a.TLS.CAFile = tlsCA
+	}
+
+	if tlsCert := os.Getenv("TLS_CERT"); tlsCert != "" {
+		a.TLS.CertFile = tlsCert
+	}
+
+	if tlsKey := os.Getenv("TLS_KEY"); tlsKey != "" {
+		a.TLS.KeyFile = tlsKey
+	}
+
 	return nil
 }
 
@@ -154,6 +257,16 @@ func (a *AgentConfig) UnmarshalJSON(b []byte) error {
 		ReportInterval string `json:"report_interval"`
 		PollInterval   string `json:"poll_interval"`
 		CryptoKey      string `json:"crypto_key"`
+		InstanceID     string `json:"instance_id"`
+		GRPCStream     bool   `json:"grpc_stream"`
+		Token          string `json:"token"`
+		AdminAddress   string `json:"admin_address"`
+		AdminToken     string `json:"admin_token"`
+		EnrollDir      string `json:"enroll_dir"`
+		EnrollToken    string `json:"enroll_token"`
+		TLSCA          string `json:"tls_ca"`
+		TLSCert        string `json:"tls_cert"`
+		TLSKey         string `json:"tls_key"`
 	}
 
 	if err = json.Unmarshal(b, &cfg); err != nil {
@@ -186,6 +299,46 @@ func (a *AgentConfig) UnmarshalJSON(b []byte) error {
 		a.CryptoKey = cfg.CryptoKey
 	}
 
+	if a.InstanceID == "" && cfg.InstanceID != "" {
+		a.InstanceID = cfg.InstanceID
+	}
+
+	if !a.GRPCStream && cfg.GRPCStream {
+		a.GRPCStream = cfg.GRPCStream
+	}
+
+	if a.Token == "" && cfg.Token != "" {
+		a.Token = cfg.Token
+	}
+
+	if a.Admin.Address == "" && cfg.AdminAddress != "" {
+		a.Admin.Address = cfg.AdminAddress
+	}
+
+	if a.Admin.Token == "" && cfg.AdminToken != "" {
+		a.Admin.Token = cfg.AdminToken
+	}
+
+	if a.Enroll.Dir == "" && cfg.EnrollDir != "" {
+		a.Enroll.Dir = cfg.EnrollDir
+	}
+
+	if a.Enroll.Token == "" && cfg.EnrollToken != "" {
+		a.Enroll.Token = cfg.EnrollToken
+	}
+
+	if a.TLS.CAFile == "" && cfg.TLSCA != "" {
+		a.TLS.CAFile = cfg.TLSCA
+	}
+
+	if a.TLS.CertFile == "" && cfg.TLSCert != "" {
+		a.TLS.CertFile = cfg.TLSCert
+	}
+
+	if a.TLS.KeyFile == "" && cfg.TLSKey != "" {
+		a.TLS.KeyFile = cfg.TLSKey
+	}
+
 	return nil
 }
 
@@ -0,0 +1,234 @@
+// Модуль enroll регистрирует агента на сервере по одноразовому токену,
+// хранит выданные сертификат и HMAC ключ агента и продлевает сертификат до окончания срока действия
+package enroll
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"metrics/internal/models"
+	"metrics/pkg"
+)
+
+const (
+	certName     = "client-cert.pem"
+	keyName      = "client-key.pem"
+	caName       = "ca.pem"
+	identityName = "agent.json"
+
+	keyBits = 2048
+
+	// retryInterval - интервал повтора неудачного продления
+	retryInterval = time.Minute
+)
+
+// enrollClient - интерфейс запросов выпуска сертификата
+type enrollClient interface {
+	Enroll(context.Context, *models.EnrollRequest) (*models.EnrollResponse, error)
+	Renew(context.Context, *models.EnrollRequest) (*models.EnrollResponse, error)
+}
+
+// Identity - выданные агенту идентификатор, HMAC ключ и сертификат
+type Identity struct {
+	AgentID  string    `json:"agent_id"`
+	Key      string    `json:"key"`
+	NotAfter time.Time `json:"not_after"`
+
+	// Время выпуска сертификата, от него считается момент продления
+	IssuedAt time.Time `json:"issued_at"`
+
+	CertFile string `json:"-"`
+	KeyFile  string `json:"-"`
+	CAFile   string `json:"-"`
+}
+
+// Enroller - регистрация агента и хранение его сертификата в каталоге dir
+type Enroller struct {
+	dir string
+}
+
+// New - конструктор регистрации агента
+func New(dir string) *Enroller {
+	return &Enroller{dir: dir}
+}
+
+// Ensure возвращает сохраненную регистрацию агента, если ее нет - регистрирует агента по токену.
+// Токен одноразовый, поэтому агент с истекшим сертификатом не регистрируется повторно:
+// оператор выпускает новый токен и удаляет сохраненную регистрацию
+func (e *Enroller) Ensure(ctx context.Context, client enrollClient, token string) (*Identity, error) {
+	identity, err := e.load()
+	if err == nil {
+		if time.Now().After(identity.NotAfter) {
+			return nil, fmt.Errorf("certificate of agent %s expired at %s: issue a new bootstrap token and remove %s to enroll again",
+				identity.AgentID, identity.NotAfter, e.dir)
+		}
+		return identity, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	if token == "" {
+		return nil, fmt.Errorf("agent is not enrolled and no bootstrap token given")
+	}
+
+	if err = os.MkdirAll(e.dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed create enroll dir: %w", err)
+	}
+
+	key, csr, err := newRequest()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := client.Enroll(ctx, &models.EnrollRequest{Token: token, CSR: csr})
+	if err != nil {
+		return nil, fmt.Errorf("failed enroll agent: %w", err)
+	}
+
+	identity = &Identity{AgentID: response.AgentID, Key: response.Key}
+	if err = e.store(identity, key, response); err != nil {
+		return nil, err
+	}
+
+	log.Printf("enroll: agent %s enrolled, certificate valid until %s", identity.AgentID, identity.NotAfter)
+
+	return identity, nil
+}
+
+// Run продлевает сертификат, когда прошло две трети срока его действия, до отмены контекста.
+// Запрос продления подписывается HMAC ключом агента и передается по mTLS с текущим сертификатом,
+// клиент должен быть создан с этим ключом и сертификатом
+func (e *Enroller) Run(ctx context.Context, client enrollClient, identity *Identity) {
+	ctx = context.WithValue(ctx, pkg.InstanceKey{}, identity.AgentID)
+
+	for {
+		renewAt := identity.IssuedAt.Add(identity.NotAfter.Sub(identity.IssuedAt) * 2 / 3)
+
+		timer := time.NewTimer(time.Until(renewAt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		if err := e.renew(ctx, client, identity); err != nil {
+			log.Printf("enroll: failed renew certificate, retry in %s: %s", retryInterval, err.Error())
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryInterval):
+			}
+			continue
+		}
+
+		log.Printf("enroll: certificate renewed, valid until %s", identity.NotAfter)
+	}
+}
+
+// renew выпускает новый сертификат с новым ключом
+func (e *Enroller) renew(ctx context.Context, client enrollClient, identity *Identity) error {
+	key, csr, err := newRequest()
+	if err != nil {
+		return err
+	}
+
+	response, err := client.Renew(ctx, &models.EnrollRequest{CSR: csr})
+	if err != nil {
+		return err
+	}
+
+	return e.store(identity, key, response)
+}
+
+// newRequest генерирует ключ сертификата и запрос на сертификат
+func newRequest() (*rsa.PrivateKey, string, error) {
+	key, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed generate certificate key: %w", err)
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: "metrics agent"},
+	}, key)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed create certificate request: %w", err)
+	}
+
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})), nil
+}
+
+// store сохраняет сертификат, его ключ, CA и регистрацию агента.
+// Ключ записывается раньше сертификата: сертификат перечитывается по изменению своего файла
+func (e *Enroller) store(identity *Identity, key *rsa.PrivateKey, response *models.EnrollResponse) error {
+	identity.NotAfter = response.NotAfter
+	identity.IssuedAt = time.Now()
+	e.setPaths(identity)
+
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	if err := writeFile(identity.KeyFile, keyPEM); err != nil {
+		return err
+	}
+	if err := writeFile(identity.CAFile, []byte(response.CA)); err != nil {
+		return err
+	}
+	if err := writeFile(identity.CertFile, []byte(response.Certificate)); err != nil {
+		return err
+	}
+
+	identityData, err := json.Marshal(identity)
+	if err != nil {
+		return fmt.Errorf("failed marshal agent identity: %w", err)
+	}
+
+	return writeFile(filepath.Join(e.dir, identityName), identityData)
+}
+
+// load читает сохраненную регистрацию агента
+func (e *Enroller) load() (*Identity, error) {
+	identityData, err := os.ReadFile(filepath.Join(e.dir, identityName))
+	if err != nil {
+		return nil, fmt.Errorf("failed read agent identity: %w", err)
+	}
+
+	identity := &Identity{}
+	if err = json.Unmarshal(identityData, identity); err != nil {
+		return nil, fmt.Errorf("failed unmarshal agent identity: %w", err)
+	}
+	e.setPaths(identity)
+
+	return identity, nil
+}
+
+// setPaths заполняет пути файлов сертификата
+func (e *Enroller) setPaths(identity *Identity) {
+	identity.CertFile = filepath.Join(e.dir, certName)
+	identity.KeyFile = filepath.Join(e.dir, keyName)
+	identity.CAFile = filepath.Join(e.dir, caName)
+}
+
+// writeFile записывает файл через временный файл, чтобы читатели не увидели неполный файл
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed write %s: %w", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed replace %s: %w", path, err)
+	}
+
+	return nil
+}
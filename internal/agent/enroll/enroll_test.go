@@ -0,0 +1,64 @@
+package enroll
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"metrics/internal/models"
+)
+
+// testClient - клиент выпуска сертификатов для тестов, считает регистрации
+type testClient struct {
+	enrolled int
+}
+
+func (c *testClient) Enroll(context.Context, *models.EnrollRequest) (*models.EnrollResponse, error) {
+	c.enrolled++
+	return &models.EnrollResponse{AgentID: "host-a", Key: "key-a", NotAfter: time.Now().Add(time.Hour)}, nil
+}
+
+func (c *testClient) Renew(context.Context, *models.EnrollRequest) (*models.EnrollResponse, error) {
+	return &models.EnrollResponse{NotAfter: time.Now().Add(time.Hour)}, nil
+}
+
+func TestEnroller_EnsureExpired(t *testing.T) {
+	dir := t.TempDir()
+	identityData, err := json.Marshal(&Identity{AgentID: "host-a", Key: "key-a", NotAfter: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(filepath.Join(dir, identityName), identityData, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Истекший сертификат не продлевается использованным токеном
+	client := &testClient{}
+	_, err = New(dir).Ensure(context.Background(), client, "bootstrap")
+	assert.ErrorContains(t, err, "issue a new bootstrap token")
+	assert.Zero(t, client.enrolled)
+}
+
+func TestEnroller_Ensure(t *testing.T) {
+	dir := t.TempDir()
+	client := &testClient{}
+
+	identity, err := New(dir).Ensure(context.Background(), client, "bootstrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "host-a", identity.AgentID)
+
+	// Сохраненная регистрация используется без токена
+	identity, err = New(dir).Ensure(context.Background(), client, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "key-a", identity.Key)
+	assert.Equal(t, 1, client.enrolled)
+}
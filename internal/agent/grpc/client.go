@@ -5,9 +5,12 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"net"
+	"strconv"
+	"sync"
 	"time"
 
 	"metrics/pkg"
@@ -17,22 +20,44 @@ import (
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
+	"metrics/internal/models"
 	pb "metrics/internal/server/proto"
+	"metrics/pkg/envelope"
+	"metrics/pkg/replay"
 )
 
+// maxStreamMetrics - количество метрик, после которого стрим закрывается для подтверждения сервером,
+// чтобы неподтвержденные метрики не копились в памяти агента
+const maxStreamMetrics = 10000
+
+// payloader - интерфейс сообщения с подписываемым телом
+type payloader interface {
+	Payload() ([]byte, error)
+}
+
 // GRPCClient - структура gRPC клиента
 type GRPCClient struct {
 	client   pb.HandlersClient
 	attempts int
 	interval time.Duration
+	stream   bool
+
+	// Долгоживущий стрим передачи метрик, открывается при первой отправке
+	mu      sync.Mutex
+	updates pb.Handlers_StreamUpdatesClient
+	// Контекст открытия стрима, в нем повторно отправляются неподтвержденные метрики
+	streamCtx context.Context
+	// Метрики, переданные в текущий стрим без подтверждения сервера
+	pending []*pb.Metric
 }
 
-// New собирает gRPC клиент
-func New(client pb.HandlersClient, attempts int, interval time.Duration) *GRPCClient {
+// New собирает gRPC клиент, при stream типизированные метрики передаются через один долгоживущий стрим
+func New(client pb.HandlersClient, attempts int, interval time.Duration, stream bool) *GRPCClient {
 	return &GRPCClient{
 		client:   client,
 		attempts: attempts,
 		interval: interval,
+		stream:   stream,
 	}
 }
 
@@ -45,46 +70,155 @@ func (g *GRPCClient) PostUpdates(ctx context.Context, requestData []byte) error
 	return nil
 }
 
+// PostMetrics метод реализует интерфейс MetricsPoster для отправки типизированных метрик.
+// Если стрим сломан, метрики без подтверждения сервера повторно отправляются через PostUpdates
+func (g *GRPCClient) PostMetrics(ctx context.Context, metrics []*models.Data) error {
+	batch := make([]*pb.Metric, 0, len(metrics))
+	for _, data := range metrics {
+		metric, err := pb.NewMetric(data)
+		if err != nil {
+			return fmt.Errorf("PostMetrics: build metric %s: %w", data.Name, err)
+		}
+		batch = append(batch, metric)
+	}
+
+	if g.stream {
+		unconfirmed, err := g.send(ctx, batch)
+		if err == nil {
+			return nil
+		}
+		if len(unconfirmed) == 0 {
+			return fmt.Errorf("PostMetrics: %w", err)
+		}
+		log.Printf("gRPC stream failed, resending %d unconfirmed metrics: %s", len(unconfirmed), err.Error())
+		batch = unconfirmed
+	}
+
+	if err := g.doWithRetry(ctx, &pb.PostUpdatesRequest{Batch: batch}); err != nil {
+		return fmt.Errorf("PostMetrics: %w", err)
+	}
+
+	return nil
+}
+
+// Streaming сообщает, что успешная отправка только передает батч в стрим
+func (g *GRPCClient) Streaming() bool {
+	return g.stream
+}
+
+// Close закрывает стрим передачи метрик и проверяет итог сервера.
+// Только после CloseAndRecv известно, что сервер принял переданные метрики, неподтвержденные отправляются повторно
+func (g *GRPCClient) Close() error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.updates == nil {
+		return nil
+	}
+
+	ctx := g.streamCtx
+	unconfirmed, err := g.closeStream()
+	if err == nil {
+		return nil
+	}
+	if len(unconfirmed) == 0 {
+		return fmt.Errorf("close stream: %w", err)
+	}
+
+	log.Printf("gRPC stream close failed, resending %d unconfirmed metrics: %s", len(unconfirmed), err.Error())
+	if err = g.doWithRetry(ctx, &pb.PostUpdatesRequest{Batch: unconfirmed}); err != nil {
+		return fmt.Errorf("resend unconfirmed metrics: %w", err)
+	}
+
+	return nil
+}
+
 // NewInterceptors конструктор перезватчиков клиента gRPC
-func NewInterceptors(key string) grpc.DialOption {
+func NewInterceptors(key string, token string) []grpc.DialOption {
 	interceptors := []grpc.UnaryClientInterceptor{
+		withToken(token),
 		withHash(key),
-		withRealIP(),
+		withInstance(),
+		withEncryption(),
 	}
 
-	return grpc.WithChainUnaryInterceptor(interceptors...)
+	streamInterceptors := []grpc.StreamClientInterceptor{
+		withStreamToken(token),
+		withStreamHash(key),
+		withStreamInstance(),
+	}
+
+	return []grpc.DialOption{
+		grpc.WithChainUnaryInterceptor(interceptors...),
+		grpc.WithChainStreamInterceptor(streamInterceptors...),
+	}
 }
 
-// withHash - перехватчик для вычисления хеша запроса и передача серверу
-func withHash(key string) grpc.UnaryClientInterceptor {
+// withToken - перехватчик для передачи bearer токена
+func withToken(token string) grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req any, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-		if key != "" {
-			h := hmac.New(sha256.New, []byte(key))
-			h.Write(req.(*pb.PostUpdatesRequest).Metrics)
-			hash := hex.EncodeToString(h.Sum(nil))
+		if token != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
 
-			ctx = metadata.AppendToOutgoingContext(ctx, "HashSHA256", hash)
+// withHash - перехватчик для вычисления хеша запроса и передача серверу.
+// Подпись покрывает время отправки и nonce для защиты от повторов
+func withHash(key string) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req any, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if request, ok := req.(*pb.PostUpdatesRequest); ok && key != "" {
+			timestamp, nonce, hash, err := sign(key, request)
+			if err != nil {
+				return err
+			}
 
+			ctx = metadata.AppendToOutgoingContext(ctx,
+				"HashSHA256", hash,
+				replay.TimestampHeader, timestamp,
+				replay.NonceHeader, nonce)
 		}
 		return invoker(ctx, method, req, reply, cc, opts...)
 	}
 }
 
-// withRealIP - перехватчик для передачи ip адреса клиента
-func withRealIP() grpc.UnaryClientInterceptor {
+// sign подписывает тело запроса вместе со временем отправки и nonce
+func sign(key string, request payloader) (timestamp string, nonce string, hash string, err error) {
+	payload, err := request.Payload()
+	if err != nil {
+		return "", "", "", fmt.Errorf("hash request payload: %w", err)
+	}
+
+	if nonce, err = replay.NewNonce(); err != nil {
+		return "", "", "", err
+	}
+	timestamp = replay.Timestamp(time.Now())
+
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(replay.Payload(timestamp, nonce, payload))
+
+	return timestamp, nonce, hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// withInstance - перехватчик для передачи идентификатора инстанса агента
+func withInstance() grpc.UnaryClientInterceptor {
 	return func(ctx context.Context, method string, req any, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
-		interfaces, err := net.InterfaceAddrs()
-		if err != nil {
-			log.Printf("failed to get interface addresses: %s", err.Error())
+		if instance, ok := ctx.Value(pkg.InstanceKey{}).(string); ok && instance != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, models.InstanceHeader, instance)
 		}
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
 
-		for _, v := range interfaces {
-			if ipnet, ok := v.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-				if ipnet.IP.To4() != nil {
-					ctx = metadata.AppendToOutgoingContext(ctx, "X-Real-IP", ipnet.IP.String())
-					break
-				}
-			}
+// withEncryption - перехватчик для передачи версии схемы шифрования тела и идентификатора ключа
+func withEncryption() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req any, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		if version, ok := ctx.Value(pkg.EncryptionKey{}).(string); ok && version != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, envelope.Header, version)
+		}
+		if keyID, ok := ctx.Value(pkg.KeyIDKey{}).(string); ok && keyID != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, envelope.KeyIDHeader, keyID)
 		}
 		return invoker(ctx, method, req, reply, cc, opts...)
 	}
@@ -101,8 +235,7 @@ func (g *GRPCClient) doWithRetry(ctx context.Context, request *pb.PostUpdatesReq
 	}
 
 	for range g.attempts {
-		_, err = g.client.PostUpdates(ctx, request)
-		if err == nil {
+		if _, err = g.client.PostUpdates(ctx, request); err == nil {
 			return nil
 		}
 		if e, ok := status.FromError(err); ok {
@@ -121,3 +254,80 @@ func (g *GRPCClient) doWithRetry(ctx context.Context, request *pb.PostUpdatesReq
 
 	return err
 }
+
+// send отправляет метрики в долгоживущий стрим, открывая его при необходимости.
+// Сломанный стрим закрывается, следующая отправка откроет новый. При ошибке возвращаются метрики,
+// которые сервер не подтвердил: ранее переданные в стрим и еще не отправленные из батча
+func (g *GRPCClient) send(ctx context.Context, batch []*pb.Metric) ([]*pb.Metric, error) {
+	var err error
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Стрим переживает запрос воркера, поэтому отмена его контекста не закрывает стрим
+	if g.updates == nil {
+		streamCtx := context.WithoutCancel(ctx)
+		if g.updates, err = g.client.StreamUpdates(streamCtx); err != nil {
+			g.updates = nil
+			return batch, err
+		}
+		g.streamCtx = streamCtx
+	}
+
+	for i, metric := range batch {
+		if err = g.updates.Send(metric); err != nil {
+			// Статус ошибки сервера возвращается при чтении ответа стрима
+			unconfirmed, closeErr := g.closeStream()
+			if closeErr != nil {
+				err = closeErr
+			} else if errors.Is(err, io.EOF) {
+				err = status.Errorf(codes.Unavailable, "stream closed by server")
+			}
+			return unsigned(append(unconfirmed, batch[i:]...)), err
+		}
+		g.pending = append(g.pending, metric)
+	}
+
+	// Подтверждение накопленных метрик, следующая отправка откроет новый стрим
+	if len(g.pending) >= maxStreamMetrics {
+		return g.closeStream()
+	}
+
+	return nil, nil
+}
+
+// closeStream закрывает стрим и возвращает метрики, которые сервер не подтвердил.
+// При ошибке сервер передает количество записанных метрик в трейлере, без него неподтвержденными считаются все
+func (g *GRPCClient) closeStream() ([]*pb.Metric, error) {
+	pending := g.pending
+	summary, err := g.updates.CloseAndRecv()
+
+	var accepted int64
+	if err == nil {
+		accepted = summary.Metrics
+	} else if values := g.updates.Trailer().Get(pb.AcceptedTrailer); len(values) > 0 {
+		accepted, _ = strconv.ParseInt(values[0], 10, 64)
+	}
+	g.updates, g.streamCtx, g.pending = nil, nil, nil
+
+	if err == nil && accepted != int64(len(pending)) {
+		err = fmt.Errorf("server accepted %d of %d metrics", accepted, len(pending))
+	}
+	if err != nil {
+		accepted = max(0, min(accepted, int64(len(pending))))
+		return unsigned(pending[accepted:]), err
+	}
+	log.Printf("gRPC stream confirmed: metrics %d", summary.Metrics)
+
+	return nil, nil
+}
+
+// unsigned сбрасывает подписи стрима у метрик для повторной отправки в батче,
+// батч PostUpdates подписывается целиком в метаданных запроса
+func unsigned(metrics []*pb.Metric) []*pb.Metric {
+	for _, metric := range metrics {
+		metric.Hash, metric.Timestamp, metric.Nonce = "", "", ""
+	}
+
+	return metrics
+}
@@ -0,0 +1,73 @@
+package grpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"metrics/internal/models"
+	pb "metrics/internal/server/proto"
+	"metrics/pkg"
+)
+
+// wrappedStream - обертка стрима клиента для обработки каждого исходящего сообщения
+type wrappedStream struct {
+	grpc.ClientStream
+	send func(any) error
+}
+
+// SendMsg передает сообщение в обработчик обертки и отправляет его в стрим
+func (w *wrappedStream) SendMsg(m any) error {
+	if err := w.send(m); err != nil {
+		return err
+	}
+
+	return w.ClientStream.SendMsg(m)
+}
+
+// withStreamHash - перехватчик для вычисления хеша каждой метрики стрима.
+// Метаданные передаются один раз на стрим, поэтому подпись, время отправки и nonce передаются в самой метрике
+func withStreamHash(key string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		stream, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil || key == "" {
+			return stream, err
+		}
+
+		return &wrappedStream{
+			ClientStream: stream,
+			send: func(m any) error {
+				metric, ok := m.(*pb.Metric)
+				if !ok {
+					return nil
+				}
+
+				var err error
+				metric.Timestamp, metric.Nonce, metric.Hash, err = sign(key, metric)
+
+				return err
+			},
+		}, nil
+	}
+}
+
+// withStreamToken - перехватчик для передачи bearer токена при открытии стрима
+func withStreamToken(token string) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if token != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
+
+// withStreamInstance - перехватчик для передачи идентификатора инстанса агента при открытии стрима
+func withStreamInstance() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		if instance, ok := ctx.Value(pkg.InstanceKey{}).(string); ok && instance != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, models.InstanceHeader, instance)
+		}
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+}
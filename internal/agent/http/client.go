@@ -5,20 +5,28 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/go-resty/resty/v2"
+
+	"metrics/internal/models"
+	"metrics/pkg"
+	"metrics/pkg/envelope"
+	"metrics/pkg/replay"
 )
 
 const (
 	singleHandlerPath = "/update"
 	batchHandlerPath  = "/updates"
+	enrollPath        = "/enroll/"
+	renewPath         = "/enroll/renew"
 )
 
 // HTTPClient - структура HTTP клиента
@@ -53,7 +61,8 @@ func (h *HTTPClient) PostUpdates(ctx context.Context, body []byte) error {
 		SetHeader("Accept-Encoding", "gzip").
 		SetBody(body)}
 	response, err := request.
-		withRealIP().
+		withInstance(ctx).
+		withEncryption(ctx).
 		withHash(h.key).
 		doWithRetry(h.attempts, h.baseURL+batchHandlerPath, h.interval)
 	if err != nil {
@@ -67,11 +76,65 @@ func (h *HTTPClient) PostUpdates(ctx context.Context, body []byte) error {
 	return nil
 }
 
-// withHash - middleware для вычисления хеша запроса и передача серверу
+// Enroll регистрирует агента по токену первичной регистрации
+func (h *HTTPClient) Enroll(ctx context.Context, request *models.EnrollRequest) (*models.EnrollResponse, error) {
+	return h.enroll(ctx, enrollPath, request)
+}
+
+// Renew продлевает сертификат агента, запрос подписывается ключом агента
+func (h *HTTPClient) Renew(ctx context.Context, request *models.EnrollRequest) (*models.EnrollResponse, error) {
+	return h.enroll(ctx, renewPath, request)
+}
+
+// enroll отправляет запрос выпуска сертификата и разбирает ответ
+func (h *HTTPClient) enroll(ctx context.Context, path string, request *models.EnrollRequest) (*models.EnrollResponse, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed marshal enroll request: %w", err)
+	}
+
+	req := httpRequest{h.client.R().
+		SetContext(ctx).
+		SetHeader("Content-Type", "application/json").
+		SetBody(body)}
+	response, err := req.
+		withInstance(ctx).
+		withHash(h.key).
+		doWithRetry(h.attempts, h.baseURL+path, h.interval)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("http status code %d: %s", response.StatusCode(), strings.TrimSpace(response.String()))
+	}
+
+	var enrolled models.EnrollResponse
+	if err = json.Unmarshal(response.Body(), &enrolled); err != nil {
+		return nil, fmt.Errorf("failed unmarshal enroll response: %w", err)
+	}
+
+	return &enrolled, nil
+}
+
+// withHash - middleware для вычисления хеша запроса и передача серверу.
+// Подпись покрывает время отправки и nonce для защиты от повторов
 func (req *httpRequest) withHash(key string) *httpRequest {
 	if key != "" {
+		nonce, err := replay.NewNonce()
+		if err != nil {
+			log.Printf("failed to generate nonce: %s", err.Error())
+		}
+
+		var timestamp string
+		if nonce != "" {
+			timestamp = replay.Timestamp(time.Now())
+			req.Header.Set(replay.TimestampHeader, timestamp)
+			req.Header.Set(replay.NonceHeader, nonce)
+		}
+
 		h := hmac.New(sha256.New, []byte(key))
-		h.Write([]byte(fmt.Sprintf("%s", req.Body)))
+		h.Write(replay.Payload(timestamp, nonce, []byte(fmt.Sprintf("%s", req.Body))))
 		hash := hex.EncodeToString(h.Sum(nil))
 
 		req.Header.Add("HashSHA256", hash)
@@ -80,20 +143,22 @@ func (req *httpRequest) withHash(key string) *httpRequest {
 	return req
 }
 
-// withRealIP - middleware для передачи ip адреса клиента
-func (req *httpRequest) withRealIP() *httpRequest {
-	interfaces, err := net.InterfaceAddrs()
-	if err != nil {
-		log.Printf("failed to get interface addresses: %s", err.Error())
+// withInstance - middleware для передачи идентификатора инстанса агента
+func (req *httpRequest) withInstance(ctx context.Context) *httpRequest {
+	if instance, ok := ctx.Value(pkg.InstanceKey{}).(string); ok && instance != "" {
+		req.Header.Set(models.InstanceHeader, instance)
 	}
 
-	for _, v := range interfaces {
-		if ipnet, ok := v.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
-			if ipnet.IP.To4() != nil {
-				req.Header.Add("X-Real-IP", ipnet.IP.String())
-				break
-			}
-		}
+	return req
+}
+
+// withEncryption - middleware для передачи версии схемы шифрования тела и идентификатора ключа
+func (req *httpRequest) withEncryption(ctx context.Context) *httpRequest {
+	if version, ok := ctx.Value(pkg.EncryptionKey{}).(string); ok && version != "" {
+		req.Header.Set(envelope.Header, version)
+	}
+	if keyID, ok := ctx.Value(pkg.KeyIDKey{}).(string); ok && keyID != "" {
+		req.Header.Set(envelope.KeyIDHeader, keyID)
 	}
 
 	return req
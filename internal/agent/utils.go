@@ -1,12 +1,18 @@
 package agent
 
+import "metrics/internal/models"
+
 // Структура для канала заданий метрик
 type metricJob struct {
-	data *[]byte
+	data     *[]byte
+	metrics  []*models.Data
+	instance string
 }
 
 // Структура для канала ответов заданий метрик
 type jobResponse struct {
 	worker int
 	err    error
+	// Батч передан в стрим, сервер подтверждает прием только при закрытии стрима
+	queued bool
 }
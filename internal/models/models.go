@@ -2,14 +2,53 @@ package models
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// InstanceLabel - метка серии с идентификатором инстанса агента
+	InstanceLabel = "instance"
+	// InstanceHeader - заголовок запроса с идентификатором инстанса агента
+	InstanceHeader = "X-Instance-ID"
 )
 
 // Data - структура данных хранилища
 type Data struct {
-	Type  string   `json:"type"`
-	Name  string   `json:"id"`
-	Value *float64 `json:"value,omitempty"`
-	Delta *int64   `json:"delta,omitempty"`
+	Type   string            `json:"type"`
+	Name   string            `json:"id"`
+	Value  *float64          `json:"value,omitempty"`
+	Delta  *int64            `json:"delta,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Timestamp - время точки для истории, если его передал источник.
+	// Без него точка получает время записи в хранилище
+	Timestamp *time.Time `json:"-"`
+}
+
+// Sample - структура точки временного ряда метрики
+type Sample struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     *float64  `json:"value,omitempty"`
+	Delta     *int64    `json:"delta,omitempty"`
+}
+
+// EnrollRequest - запрос выпуска сертификата агента.
+// Токен первичной регистрации передается только при регистрации, при продлении запрос подписывается ключом агента
+type EnrollRequest struct {
+	Token string `json:"token,omitempty"`
+	CSR   string `json:"csr"`
+}
+
+// EnrollResponse - выпущенный сертификат агента и его HMAC ключ
+type EnrollResponse struct {
+	AgentID     string    `json:"agent_id"`
+	Certificate string    `json:"certificate"`
+	CA          string    `json:"ca"`
+	Key         string    `json:"key,omitempty"`
+	NotAfter    time.Time `json:"not_after"`
 }
 
 // CheckData - метод проверки входящих данных
@@ -23,6 +62,87 @@ func (d *Data) CheckData() error {
 	if d.Delta == nil && d.Type == "counter" {
 		return fmt.Errorf("empty counter delta")
 	}
+	for key := range d.Labels {
+		if key == "" {
+			return fmt.Errorf("empty label name")
+		}
+	}
 
 	return nil
 }
+
+// NewSample создает точку временного ряда из текущего значения метрики.
+// Время точки, переданное источником, имеет приоритет над timestamp
+func (d *Data) NewSample(timestamp time.Time) *Sample {
+	if d.Timestamp != nil {
+		timestamp = *d.Timestamp
+	}
+	sample := &Sample{Timestamp: timestamp}
+
+	// Копирование значений, чтобы точка не менялась вместе с метрикой
+	if d.Value != nil {
+		value := *d.Value
+		sample.Value = &value
+	}
+	if d.Delta != nil {
+		delta := *d.Delta
+		sample.Delta = &delta
+	}
+
+	return sample
+}
+
+// SetDefaultLabel устанавливает метку, если она не задана в самой метрике
+func (d *Data) SetDefaultLabel(key string, value string) {
+	if _, ok := d.Labels[key]; ok {
+		return
+	}
+
+	if d.Labels == nil {
+		d.Labels = make(map[string]string, 1)
+	}
+	d.Labels[key] = value
+}
+
+// SetLabel устанавливает метку, заменяя значение из самой метрики
+func (d *Data) SetLabel(key string, value string) {
+	if d.Labels == nil {
+		d.Labels = make(map[string]string, 1)
+	}
+	d.Labels[key] = value
+}
+
+// SeriesKey возвращает идентификатор серии метрики: имя и набор меток
+func (d *Data) SeriesKey() string {
+	return SeriesKey(d.Name, d.Labels)
+}
+
+// SeriesKey собирает идентификатор серии из имени и меток в виде name{key="value",...}.
+// Для метрики без меток идентификатор совпадает с именем
+func SeriesKey(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	// Сортировка ключей для стабильного идентификатора
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	b.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(labels[key]))
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
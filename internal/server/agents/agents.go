@@ -0,0 +1,188 @@
+// Модуль agents хранит HMAC ключи агентов для проверки подписи запросов
+package agents
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrUnknownAgent - агент не найден в хранилище ключей
+	ErrUnknownAgent = errors.New("unknown agent")
+
+	// ErrRevoked - ключ агента отозван
+	ErrRevoked = errors.New("agent key revoked")
+)
+
+// Keystore - хранилище HMAC ключей агентов.
+// Возвращает ErrUnknownAgent для неизвестного агента и ErrRevoked для отозванного
+type Keystore interface {
+	AgentSecret(agentID string) (string, error)
+}
+
+// Writer - хранилище ключей агентов с записью, используется при регистрации агентов
+type Writer interface {
+	Keystore
+	SetAgentSecret(agentID string, secret string) error
+}
+
+// New выбирает хранилище ключей агентов: файл, если задан путь, иначе таблица БД при fromDB.
+// Возвращает nil, если хранилище не настроено
+func New(file string, fromDB bool, db Keystore) (Keystore, error) {
+	switch {
+	case file != "":
+		return NewFileStore(file)
+	case fromDB:
+		if db == nil {
+			return nil, fmt.Errorf("agent keys from database require database storage")
+		}
+		return db, nil
+	default:
+		return nil, nil
+	}
+}
+
+// agentKey - запись ключа агента в файле
+type agentKey struct {
+	ID      string `json:"id"`
+	Key     string `json:"key"`
+	Revoked bool   `json:"revoked"`
+}
+
+// FileStore - хранилище ключей агентов в JSON файле.
+// Файл перечитывается при изменении, поэтому отзыв ключа не требует перезапуска сервера
+type FileStore struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	keys    map[string]agentKey
+}
+
+// NewFileStore - конструктор файлового хранилища ключей агентов
+func NewFileStore(path string) (*FileStore, error) {
+	store := &FileStore{path: path}
+
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// AgentSecret возвращает HMAC ключ агента
+func (f *FileStore) AgentSecret(agentID string) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Перечитывание файла при изменении, при ошибке остаются прежние ключи
+	if info, err := os.Stat(f.path); err == nil && !info.ModTime().Equal(f.modTime) {
+		if err = f.reload(); err != nil {
+			log.Printf("agent keys: keep previous keys: %s", err.Error())
+		}
+	}
+
+	key, ok := f.keys[agentID]
+	switch {
+	case !ok:
+		return "", fmt.Errorf("%w: %s", ErrUnknownAgent, agentID)
+	case key.Revoked:
+		return "", fmt.Errorf("%w: %s", ErrRevoked, agentID)
+	}
+
+	return key.Key, nil
+}
+
+// SetAgentSecret записывает ключ агента в файл, запись агента заменяется, отзыв снимается
+func (f *FileStore) SetAgentSecret(agentID string, secret string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Актуализация ключей перед записью
+	if info, err := os.Stat(f.path); err == nil && !info.ModTime().Equal(f.modTime) {
+		if err = f.reload(); err != nil {
+			return fmt.Errorf("failed reload agent keys before write: %w", err)
+		}
+	}
+
+	keys := make(map[string]agentKey, len(f.keys)+1)
+	records := make([]agentKey, 0, len(f.keys)+1)
+	for id, key := range f.keys {
+		if id != agentID {
+			keys[id] = key
+			records = append(records, key)
+		}
+	}
+	keys[agentID] = agentKey{ID: agentID, Key: secret}
+	records = append(records, keys[agentID])
+	sort.Slice(records, func(i, j int) bool { return records[i].ID < records[j].ID })
+
+	fileData, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed marshal agent keys: %w", err)
+	}
+
+	// Запись во временный файл и переименование, чтобы читатели не увидели неполный файл
+	tmp := f.path + ".tmp"
+	if err = os.WriteFile(tmp, fileData, 0600); err != nil {
+		return fmt.Errorf("failed write agent keys file: %w", err)
+	}
+	if err = os.Rename(tmp, f.path); err != nil {
+		return fmt.Errorf("failed replace agent keys file: %w", err)
+	}
+
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("failed stat agent keys file: %w", err)
+	}
+
+	f.keys = keys
+	f.modTime = info.ModTime()
+
+	return nil
+}
+
+// load читает файл ключей под блокировкой
+func (f *FileStore) load() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.reload()
+}
+
+// reload читает файл ключей, вызывается под блокировкой
+func (f *FileStore) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("failed stat agent keys file: %w", err)
+	}
+
+	fileData, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("failed read agent keys file: %w", err)
+	}
+
+	var records []agentKey
+	if err = json.Unmarshal(fileData, &records); err != nil {
+		return fmt.Errorf("failed unmarshal agent keys file: %w", err)
+	}
+
+	keys := make(map[string]agentKey, len(records))
+	for _, record := range records {
+		if record.ID == "" || record.Key == "" {
+			return fmt.Errorf("agent keys file: empty id or key")
+		}
+		keys[record.ID] = record
+	}
+
+	f.keys = keys
+	f.modTime = info.ModTime()
+
+	return nil
+}
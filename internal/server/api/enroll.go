@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"metrics/internal/models"
+	"metrics/internal/server/enroll"
+)
+
+// enroller - интерфейс выпуска сертификатов агентов
+type enroller interface {
+	Enroll(token string, csrPEM string) (*models.EnrollResponse, error)
+	Renew(agentID string, csrPEM string) (*models.EnrollResponse, error)
+	CACert() *x509.Certificate
+}
+
+// EnableEnrollment добавляет маршруты регистрации агентов, вызывается до запуска сервера.
+// /enroll защищен одноразовым токеном, /enroll/renew - действующим сертификатом агента и подписью его HMAC ключом.
+// Ответ содержит HMAC ключ агента, поэтому без TLS регистрация не включается.
+// CA сертификатов агентов добавляется к CA клиентов. При обязательном сертификате клиента
+// он становится необязательным на уровне TLS, чтобы агент без сертификата мог зарегистрироваться,
+// и проверяется на остальных маршрутах
+func (s *HTTPServer) EnableEnrollment(e enroller) error {
+	if s.Server.TLSConfig == nil {
+		return fmt.Errorf("agent enrollment requires TLS: issued agent keys can't be sent in plain text")
+	}
+
+	s.Server.TLSConfig = s.Server.TLSConfig.Clone()
+	clientCAs := x509.NewCertPool()
+	if s.Server.TLSConfig.ClientCAs != nil {
+		clientCAs = s.Server.TLSConfig.ClientCAs.Clone()
+	}
+	clientCAs.AddCert(e.CACert())
+	s.Server.TLSConfig.ClientCAs = clientCAs
+
+	switch s.Server.TLSConfig.ClientAuth {
+	case tls.RequireAndVerifyClientCert:
+		s.Server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		s.auth.requireClientCert = true
+	case tls.NoClientCert:
+		s.Server.TLSConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	s.router.Group(func(r chi.Router) {
+		r.Use(s.withTrustedSubnet(s.auth.ingestSubnets))
+
+		r.Route("/enroll", func(r chi.Router) {
+			r.Post("/", func(w http.ResponseWriter, req *http.Request) {
+				s.enroll(w, req, func(request *models.EnrollRequest) (*models.EnrollResponse, error) {
+					return e.Enroll(request.Token, request.CSR)
+				})
+			})
+			r.With(s.withAgentCert).Post("/renew", s.withHash(func(w http.ResponseWriter, req *http.Request) {
+				agentID := req.Header.Get(models.InstanceHeader)
+
+				s.enroll(w, req, func(request *models.EnrollRequest) (*models.EnrollResponse, error) {
+					return e.Renew(agentID, request.CSR)
+				})
+			}))
+		})
+	})
+
+	return nil
+}
+
+// enroll разбирает запрос выпуска сертификата и отправляет ответ
+func (s *HTTPServer) enroll(w http.ResponseWriter, req *http.Request, issue func(*models.EnrollRequest) (*models.EnrollResponse, error)) {
+	var request models.EnrollRequest
+	if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+		http.Error(w, "invalid enroll request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response, err := issue(&request)
+	switch {
+	case errors.Is(err, enroll.ErrInvalidToken):
+		s.logger.Errorln("enroll rejected: ", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	case errors.Is(err, enroll.ErrInvalidCSR):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		s.logger.Errorln("enroll failed: ", err)
+		http.Error(w, "failed issue certificate", http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Infof("issued certificate for agent %s until %s", response.AgentID, response.NotAfter.Format(time.RFC3339))
+
+	w.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(w).Encode(response); err != nil {
+		s.logger.Errorln("failed write enroll response: ", err)
+	}
+}
+
+// withAgentCert - middleware продления сертификата: требует действующий сертификат агента
+// и берет идентификатор агента из его CommonName. Истекший сертификат не продлевается,
+// агент регистрируется заново по новому токену
+func (s *HTTPServer) withAgentCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cert := clientCert(r)
+		if cert == nil {
+			http.Error(w, "valid agent certificate required, enroll again with a new bootstrap token", http.StatusUnauthorized)
+			return
+		}
+
+		agentID := cert.Subject.CommonName
+		if instance := r.Header.Get(models.InstanceHeader); instance != "" && instance != agentID {
+			http.Error(w, models.InstanceHeader+" does not match client certificate", http.StatusForbidden)
+			return
+		}
+		r.Header.Set(models.InstanceHeader, agentID)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withClientCert - middleware требует проверенный сертификат клиента,
+// если TLS сделал его необязательным ради регистрации агентов.
+// Идентификатор инстанса клиента с сертификатом должен совпадать с CommonName сертификата
+func (s *HTTPServer) withClientCert(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cert := clientCert(r)
+		if s.auth.requireClientCert && cert == nil {
+			http.Error(w, "client certificate required", http.StatusUnauthorized)
+			return
+		}
+
+		if instance := r.Header.Get(models.InstanceHeader); cert != nil && instance != "" {
+			if instance != cert.Subject.CommonName {
+				s.logger.Errorf("instance %s does not match client certificate %s", instance, cert.Subject.CommonName)
+				http.Error(w, models.InstanceHeader+" does not match client certificate", http.StatusForbidden)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), agentIDKey{}, instance))
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientCert возвращает проверенный сертификат клиента или nil
+func clientCert(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return r.TLS.VerifiedChains[0][0]
+}
@@ -0,0 +1,162 @@
+package api
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"metrics/internal/models"
+	"metrics/internal/server/enroll"
+	"metrics/internal/server/utils"
+	"metrics/pkg/replay"
+)
+
+// testEnroller - выпуск сертификатов для тестов, принимает один токен и отмечает его использованным
+type testEnroller struct {
+	token string
+	used  bool
+}
+
+func (e *testEnroller) Enroll(token string, csrPEM string) (*models.EnrollResponse, error) {
+	if token != e.token || e.used {
+		return nil, enroll.ErrInvalidToken
+	}
+	if csrPEM == "" {
+		return nil, enroll.ErrInvalidCSR
+	}
+	e.used = true
+
+	return &models.EnrollResponse{AgentID: "host-a", Key: "key-a"}, nil
+}
+
+func (e *testEnroller) Renew(agentID string, csrPEM string) (*models.EnrollResponse, error) {
+	return &models.EnrollResponse{AgentID: agentID}, nil
+}
+
+func (e *testEnroller) CACert() *x509.Certificate {
+	return &x509.Certificate{Raw: []byte("ca"), Subject: pkix.Name{CommonName: "agents CA"}}
+}
+
+// verifiedCert - состояние TLS с проверенным сертификатом клиента
+func verifiedCert(commonName string) *tls.ConnectionState {
+	return &tls.ConnectionState{
+		VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: commonName}}}},
+	}
+}
+
+func TestHTTPServer_EnrollmentRequiresTLS(t *testing.T) {
+	server, _ := newTestServer("", agentKeys{"host-a": "key-a"})
+
+	assert.Error(t, server.EnableEnrollment(&testEnroller{}))
+
+	// Маршрут регистрации не добавлен
+	request := httptest.NewRequest(http.MethodPost, "/enroll/", bytes.NewBufferString(`{"token":"t","csr":"c"}`))
+	w := httptest.NewRecorder()
+	server.router.ServeHTTP(w, request)
+	assert.NotEqual(t, http.StatusOK, w.Code)
+}
+
+func TestHTTPServer_Enroll(t *testing.T) {
+	server, _ := newTestServer("", agentKeys{"host-a": "key-a"})
+	server.Server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if err := server.EnableEnrollment(&testEnroller{token: "bootstrap"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{name: "invalid csr", body: `{"token":"bootstrap"}`, wantCode: http.StatusBadRequest},
+		{name: "enrolled", body: `{"token":"bootstrap","csr":"csr"}`, wantCode: http.StatusOK},
+		{name: "token reuse", body: `{"token":"bootstrap","csr":"csr"}`, wantCode: http.StatusUnauthorized},
+		{name: "malformed request", body: `{`, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/enroll/", bytes.NewBufferString(tt.body))
+			w := httptest.NewRecorder()
+
+			server.router.ServeHTTP(w, request)
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
+func TestHTTPServer_EnrollRenew(t *testing.T) {
+	server, _ := newTestServer("", agentKeys{"host-a": "key-a"})
+	server.Server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if err := server.EnableEnrollment(&testEnroller{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Сертификат агента проверяется TLS, если клиент его предъявил
+	assert.Equal(t, tls.VerifyClientCertIfGiven, server.Server.TLSConfig.ClientAuth)
+	assert.NotNil(t, server.Server.TLSConfig.ClientCAs)
+
+	body := []byte(`{"csr":"csr"}`)
+	renew := func(key string, instance string, state *tls.ConnectionState) int {
+		request := httptest.NewRequest(http.MethodPost, "/enroll/renew", bytes.NewReader(body))
+		request.TLS = state
+		if instance != "" {
+			request.Header.Set(models.InstanceHeader, instance)
+		}
+		if key != "" {
+			request.Header.Set("HashSHA256", hex.EncodeToString(utils.GetHash(key, replay.Payload("", "", body))))
+		}
+		w := httptest.NewRecorder()
+
+		server.router.ServeHTTP(w, request)
+		return w.Code
+	}
+
+	// Продление требует действующего сертификата агента
+	assert.Equal(t, http.StatusUnauthorized, renew("key-a", "host-a", nil))
+	assert.Equal(t, http.StatusForbidden, renew("key-a", "host-b", verifiedCert("host-a")))
+
+	// и подписи ключом агента
+	assert.Equal(t, http.StatusUnauthorized, renew("", "host-a", verifiedCert("host-a")))
+	assert.Equal(t, http.StatusForbidden, renew("other", "host-a", verifiedCert("host-a")))
+	assert.Equal(t, http.StatusOK, renew("key-a", "host-a", verifiedCert("host-a")))
+
+	// Идентификатор агента берется из сертификата
+	assert.Equal(t, http.StatusOK, renew("key-a", "", verifiedCert("host-a")))
+}
+
+func TestHTTPServer_InstanceCertBinding(t *testing.T) {
+	server, storage := newTestServer("", nil)
+
+	update := func(instance string, state *tls.ConnectionState) int {
+		body := `{"id":"temperature","type":"gauge","value":1,"labels":{"instance":"spoofed"}}`
+		request := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewBufferString(body))
+		request.Header.Set("Content-Type", "application/json")
+		request.TLS = state
+		if instance != "" {
+			request.Header.Set(models.InstanceHeader, instance)
+		}
+		w := httptest.NewRecorder()
+
+		server.router.ServeHTTP(w, request)
+		return w.Code
+	}
+
+	// Идентификатор инстанса клиента с сертификатом должен совпадать с CommonName
+	assert.Equal(t, http.StatusForbidden, update("host-b", verifiedCert("host-a")))
+	assert.Equal(t, http.StatusOK, update("host-a", verifiedCert("host-a")))
+	assert.Equal(t, http.StatusOK, update("host-b", nil))
+
+	// Подтвержденный сертификатом идентификатор заменяет метку инстанса из тела запроса
+	data, err := storage.Read("temperature", map[string]string{models.InstanceLabel: "host-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, data)
+}
@@ -7,8 +7,10 @@ import (
 	"log"
 	"net/http"
 	"strconv"
+	"time"
 
 	"metrics/internal/models"
+	"metrics/internal/server/otlp"
 )
 
 //TODO разбить по файлам
@@ -16,6 +18,7 @@ import (
 // Handler - структура HTTP хендлера
 type Handler struct {
 	storageCommands *StorageCommands
+	otlp            *otlp.Converter
 }
 
 // StorageCommands - команды для взаимодействия с хранилищем
@@ -27,8 +30,9 @@ type StorageCommands struct {
 
 // dataReader - интерфейс хендлера для чтения из базы
 type dataReader interface {
-	Read(string) (*models.Data, error)
+	Read(string, map[string]string) (*models.Data, error)
 	ReadAll() ([]*models.Data, error)
+	ReadRange(string, map[string]string, time.Time, time.Time) ([]*models.Sample, error)
 }
 
 // dataUpdater - интерфейс хендлера для записи в базу
@@ -43,9 +47,10 @@ type pinger interface {
 }
 
 // NewHandler - конструктор хендлера
-func NewHandler(apiStorageCommands *StorageCommands) *Handler {
+func NewHandler(apiStorageCommands *StorageCommands, converter *otlp.Converter) *Handler {
 	return &Handler{
 		storageCommands: apiStorageCommands,
+		otlp:            converter,
 	}
 }
 
@@ -98,6 +103,9 @@ func (h *Handler) UpdatePostJSON(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// Отметка инстанса агента
+	markInstance(req, &storageData)
+
 	// Обновление или сохранение новой записи в хранилище
 	if err = h.storageCommands.Update(&storageData); err != nil {
 		log.Println("UpdatePostJSON: update handler error:", err)
@@ -155,6 +163,9 @@ func (h *Handler) UpdatesPostJSON(w http.ResponseWriter, req *http.Request) {
 			w.WriteHeader(http.StatusBadRequest)
 			return
 		}
+
+		// Отметка инстанса агента
+		markInstance(req, data)
 	}
 
 	// Обновление или сохранение новой записи в хранилище
@@ -252,7 +263,7 @@ func (h *Handler) ValueGetJSON(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Получение данных записи
-	metric, err := h.storageCommands.Read(storageData.Name)
+	metric, err := h.storageCommands.Read(storageData.Name, storageData.Labels)
 	if err != nil {
 		log.Println("ValueGetJSON: get handler: read repo:", err)
 		w.WriteHeader(http.StatusBadRequest)
@@ -291,7 +302,7 @@ func (h *Handler) ValueGet(w http.ResponseWriter, req *http.Request) {
 	}
 
 	// Получение данных записи
-	data, err := h.storageCommands.Read(req.PathValue("name"))
+	data, err := h.storageCommands.Read(req.PathValue("name"), nil)
 	if err != nil {
 		log.Println("ValueGet: read repo:", err)
 		w.WriteHeader(http.StatusBadRequest)
@@ -330,6 +341,63 @@ func (h *Handler) ValueGet(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// QueryRangeGet - метод ручки "GET /api/v1/query_range"
+func (h *Handler) QueryRangeGet(w http.ResponseWriter, req *http.Request) {
+	// Разбор параметров запроса
+	query, err := parseRangeQuery(req.URL.Query())
+	if err != nil {
+		log.Println("QueryRangeGet: invalid query:", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Получение данных записи для проверки наличия и типа метрики
+	data, err := h.storageCommands.Read(query.name, query.labels)
+	if err != nil {
+		log.Println("QueryRangeGet: read repo:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Проверка пустой даты
+	if data == nil {
+		log.Println("QueryRangeGet: read repo: not found")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	// Получение истории найденной серии за период
+	samples, err := h.storageCommands.ReadRange(data.Name, data.Labels, query.from, query.to)
+	if err != nil {
+		log.Println("QueryRangeGet: read range repo:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Сериализация данных
+	response, err := json.Marshal(&rangeResponse{
+		Type:   data.Type,
+		Name:   data.Name,
+		Labels: data.Labels,
+		From:   query.from,
+		To:     query.to,
+		Step:   query.step.Seconds(),
+		Agg:    query.aggName,
+		Points: bucketSamples(samples, query),
+	})
+	if err != nil {
+		log.Println("QueryRangeGet: marshal data:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Передача данных в ответ
+	w.Header().Set("Content-Type", "application/json")
+	if _, err = w.Write(response); err != nil {
+		log.Println("get handler error:", err)
+	}
+}
+
 // IndexGet - метод ручки "GET /"
 func (h *Handler) IndexGet(w http.ResponseWriter, req *http.Request) {
 	// Получение всех записей
@@ -360,6 +428,23 @@ func (h *Handler) IndexGet(w http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// MetricsGet - метод ручки "GET /metrics" в текстовом формате Prometheus
+func (h *Handler) MetricsGet(w http.ResponseWriter, req *http.Request) {
+	// Получение всех записей
+	data, err := h.storageCommands.ReadAll()
+	if err != nil {
+		log.Println("MetricsGet: read repo:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Передача данных в ответ
+	w.Header().Set("Content-Type", prometheusContentType)
+	if err = writePrometheus(w, data); err != nil {
+		log.Println("MetricsGet: write metrics:", err)
+	}
+}
+
 // PingGet - метод ручки "GET /ping"
 func (h *Handler) PingGet(w http.ResponseWriter, req *http.Request) {
 	if h.storageCommands.pinger == nil {
@@ -375,3 +460,16 @@ func (h *Handler) PingGet(w http.ResponseWriter, req *http.Request) {
 
 	w.WriteHeader(http.StatusOK)
 }
+
+// markInstance отмечает метрику инстансом агента. Идентификатор, подтвержденный подписью или сертификатом агента,
+// заменяет метку из тела запроса, идентификатор из хедера без подписи агента заполняет только отсутствующую метку
+func markInstance(req *http.Request, data *models.Data) {
+	if agentID, ok := req.Context().Value(agentIDKey{}).(string); ok {
+		data.SetLabel(models.InstanceLabel, agentID)
+		return
+	}
+
+	if instance := req.Header.Get(models.InstanceHeader); instance != "" {
+		data.SetDefaultLabel(models.InstanceLabel, instance)
+	}
+}
@@ -107,7 +107,7 @@ func TestHandler_Update(t *testing.T) {
 
 			w := httptest.NewRecorder()
 
-			handler := NewHandler(commands)
+			handler := NewHandler(commands, nil)
 			handler.UpdatePost(w, request)
 
 			res := w.Result()
@@ -123,6 +123,179 @@ func TestHandler_Update(t *testing.T) {
 	}
 }
 
+func TestHandler_UpdatesPostJSONInstance(t *testing.T) {
+	// Отдельное хранилище, чтобы не влиять на остальные тесты
+	memStorage := memory.NewMemoryStorage()
+	handler := NewHandler(&StorageCommands{
+		dataReader:  memStorage,
+		dataUpdater: memStorage,
+	}, nil)
+
+	// Два агента отправляют одноименные метрики
+	for i, instance := range []string{"host-a", "host-b"} {
+		value := float64(i + 1)
+		body, err := json.Marshal([]*models.Data{{Type: "gauge", Name: "Alloc", Value: &value}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		request := httptest.NewRequest("POST", "http://localhost:8080/updates", bytes.NewBuffer(body))
+		request.Header.Add("Content-Type", "application/json")
+		request.Header.Add(models.InstanceHeader, instance)
+		w := httptest.NewRecorder()
+
+		handler.UpdatesPostJSON(w, request)
+		assert.Equal(t, 200, w.Code, "Codes are not equal")
+	}
+
+	// Метрики хранятся отдельно для каждого инстанса
+	all, err := memStorage.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, all, 2)
+
+	for i, instance := range []string{"host-a", "host-b"} {
+		data, err := memStorage.Read("Alloc", map[string]string{models.InstanceLabel: instance})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if assert.NotNil(t, data) {
+			assert.Equal(t, float64(i+1), *data.Value)
+		}
+	}
+}
+
+func TestHandler_QueryRangeGet(t *testing.T) {
+	// Отдельное хранилище, чтобы не влиять на остальные тесты
+	memStorage := memory.NewMemoryStorage()
+	handler := NewHandler(&StorageCommands{
+		dataReader:  memStorage,
+		dataUpdater: memStorage,
+	}, nil)
+
+	for _, v := range []float64{1, 2, 6} {
+		value := v
+		if err := memStorage.Update(&models.Data{Type: "gauge", Name: "heap", Value: &value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type want struct {
+		code   int
+		points []float64
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		want  want
+	}{
+		{
+			name:  "empty name, error code 400",
+			query: "agg=avg",
+			want:  want{code: 400},
+		},
+		{
+			name:  "unknown aggregation, error code 400",
+			query: "name=heap&agg=median",
+			want:  want{code: 400},
+		},
+		{
+			name:  "invalid step, error code 400",
+			query: "name=heap&step=-1",
+			want:  want{code: 400},
+		},
+		{
+			name:  "NaN step, error code 400",
+			query: "name=heap&step=NaN",
+			want:  want{code: 400},
+		},
+		{
+			name:  "infinite step, error code 400",
+			query: "name=heap&step=Inf",
+			want:  want{code: 400},
+		},
+		{
+			name:  "huge step, error code 400",
+			query: "name=heap&step=1e300",
+			want:  want{code: 400},
+		},
+		{
+			name:  "step below a nanosecond, error code 400",
+			query: "name=heap&step=1e-10",
+			want:  want{code: 400},
+		},
+		{
+			name:  "NaN time, error code 400",
+			query: "name=heap&from=NaN",
+			want:  want{code: 400},
+		},
+		{
+			name:  "infinite time, error code 400",
+			query: "name=heap&to=%2BInf",
+			want:  want{code: 400},
+		},
+		{
+			name:  "huge time, error code 400",
+			query: "name=heap&from=1e300",
+			want:  want{code: 400},
+		},
+		{
+			name:  "unknown metric, error code 404",
+			query: "name=unknown",
+			want:  want{code: 404},
+		},
+		{
+			name:  "avg",
+			query: "name=heap&step=1h&agg=avg",
+			want:  want{code: 200, points: []float64{3}},
+		},
+		{
+			name:  "max",
+			query: "name=heap&step=1h&agg=max",
+			want:  want{code: 200, points: []float64{6}},
+		},
+		{
+			name:  "last",
+			query: "name=heap&step=1h&agg=last",
+			want:  want{code: 200, points: []float64{6}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest("GET", "http://localhost:8080/api/v1/query_range?"+tt.query, nil)
+			w := httptest.NewRecorder()
+
+			handler.QueryRangeGet(w, request)
+
+			res := w.Result()
+
+			defer func() {
+				if err := res.Body.Close(); err != nil {
+					log.Println("error closing response body", err)
+				}
+			}()
+
+			assert.Equal(t, tt.want.code, res.StatusCode, "Codes are not equal")
+			if tt.want.code != 200 {
+				return
+			}
+
+			response := rangeResponse{}
+			if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+				t.Fatal(err)
+			}
+
+			points := make([]float64, 0, len(response.Points))
+			for _, point := range response.Points {
+				points = append(points, point.Value)
+			}
+			assert.Equal(t, tt.want.points, points, "Points are not equal")
+		})
+	}
+}
+
 func ExampleHandler_UpdatePostJSON() {
 	// Конструктор структуры тела запроса
 	val := float64(3251325234)
@@ -149,7 +322,7 @@ func ExampleHandler_UpdatePostJSON() {
 	w := httptest.NewRecorder()
 
 	// Регистрация нового обработчика
-	handler := NewHandler(commands)
+	handler := NewHandler(commands, nil)
 
 	// Выполнение запроса
 	handler.UpdatePostJSON(w, request)
@@ -197,7 +370,7 @@ func ExampleHandler_UpdatesPostJSON() {
 	w := httptest.NewRecorder()
 
 	// Регистрация нового обработчика
-	handler := NewHandler(commands)
+	handler := NewHandler(commands, nil)
 
 	// Выполнение запроса
 	handler.UpdatesPostJSON(w, request)
@@ -232,7 +405,7 @@ func ExampleHandler_UpdatePost() {
 	w := httptest.NewRecorder()
 
 	// Регистрация нового обработчика
-	handler := NewHandler(commands)
+	handler := NewHandler(commands, nil)
 
 	// Выполнение запроса
 	handler.UpdatePost(w, request)
@@ -273,7 +446,7 @@ func ExampleHandler_ValueGetJSON() {
 	w := httptest.NewRecorder()
 
 	// Регистрация нового обработчика
-	handler := NewHandler(commands)
+	handler := NewHandler(commands, nil)
 
 	// Выполнение запроса
 	handler.ValueGetJSON(w, request)
@@ -311,7 +484,7 @@ func ExampleHandler_ValueGet() {
 	w := httptest.NewRecorder()
 
 	// Регистрация нового обработчика
-	handler := NewHandler(commands)
+	handler := NewHandler(commands, nil)
 
 	// Выполнение запроса
 	handler.ValueGet(w, request)
@@ -346,7 +519,7 @@ func ExampleHandler_IndexGet() {
 	w := httptest.NewRecorder()
 
 	// Регистрация нового обработчика
-	handler := NewHandler(commands)
+	handler := NewHandler(commands, nil)
 
 	// Выполнение запроса
 	handler.IndexGet(w, request)
@@ -369,3 +542,113 @@ func ExampleHandler_IndexGet() {
 	// 200
 	// [{"type":"gauge","id":"alloc","value":3251325234}]
 }
+
+func ExampleHandler_MetricsGet() {
+	// Составление запроса
+	request := httptest.NewRequest(
+		"GET",
+		"http://localhost:8080/metrics",
+		nil)
+
+	// Создание интерфейса записи
+	w := httptest.NewRecorder()
+
+	// Регистрация нового обработчика
+	handler := NewHandler(commands, nil)
+
+	// Выполнение запроса
+	handler.MetricsGet(w, request)
+
+	// Получение ответа
+	res := w.Result()
+
+	defer func() {
+		if err := res.Body.Close(); err != nil {
+			log.Println("error closing response body", err)
+		}
+	}()
+
+	resBody, _ := io.ReadAll(res.Body)
+
+	fmt.Println(res.StatusCode)
+	fmt.Println(res.Header.Get("Content-Type"))
+	fmt.Print(string(resBody))
+
+	// Output:
+	// 200
+	// text/plain; version=0.0.4; charset=utf-8
+	// # TYPE alloc gauge
+	// alloc 3.251325234e+09
+}
+
+func TestSanitizeMetricName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "HeapAlloc", want: "HeapAlloc"},
+		{name: "CPUutilization1", want: "CPUutilization1"},
+		{name: "http.requests-total", want: "http_requests_total"},
+		{name: "1min", want: "_1min"},
+		{name: "namespace:metric", want: "namespace:metric"},
+		{name: "", want: "_"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, sanitizeMetricName(tt.name))
+		})
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	gauge := 1.5
+	counter := int64(7)
+	data := []*models.Data{
+		{Type: "counter", Name: "requests", Delta: &counter, Labels: map[string]string{"host": "b"}},
+		{Type: "gauge", Name: "cpu.usage", Value: &gauge, Labels: map[string]string{"cpu": "1", "host": `a"1`}},
+		{Type: "counter", Name: "requests", Delta: &counter, Labels: map[string]string{"host": "a"}},
+	}
+
+	buf := &bytes.Buffer{}
+	if err := writePrometheus(buf, data); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, `# TYPE cpu_usage gauge
+cpu_usage{cpu="1",host="a\"1"} 1.5
+# TYPE requests counter
+requests{host="a"} 7
+requests{host="b"} 7
+`, buf.String())
+}
+
+func TestWritePrometheus_NameCollision(t *testing.T) {
+	gauge, other := 1.5, 2.5
+	counter := int64(7)
+	data := []*models.Data{
+		{Type: "gauge", Name: "http_requests", Value: &gauge},
+		{Type: "counter", Name: "http.requests", Delta: &counter},
+		{Type: "gauge", Name: "cpu", Value: &other, Labels: map[string]string{"host_name": "a"}},
+		{Type: "gauge", Name: "cpu", Value: &gauge, Labels: map[string]string{"host.name": "a"}},
+		{Type: "histogram", Name: "http.requests"},
+	}
+	want := `# TYPE cpu gauge
+cpu{host_name="a"} 1.5
+# TYPE http_requests counter
+http_requests 7
+`
+
+	// Вывод не зависит от порядка метрик: тип и серия выбираются по идентификатору серии
+	for i := range data {
+		rotated := append(append([]*models.Data{}, data[i:]...), data[:i]...)
+
+		buf := &bytes.Buffer{}
+		if err := writePrometheus(buf, rotated); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, want, buf.String())
+	}
+
+	// Совпадение очищенных имен меток одной серии разрешается по исходному имени
+	assert.Equal(t, `{host_name="a"}`, formatLabels(map[string]string{"host_name": "b", "host.name": "a"}))
+}
@@ -3,17 +3,15 @@ package api
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"crypto/hmac"
-	"crypto/rand"
 	"crypto/rsa"
-	"crypto/x509"
+	"crypto/tls"
 	"encoding/hex"
-	"encoding/pem"
+	"fmt"
 	"io"
 	"log"
-	"net"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
@@ -21,41 +19,91 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/sirupsen/logrus"
 
+	"metrics/internal/models"
+	"metrics/internal/server/otlp"
+	"metrics/internal/server/tokens"
 	"metrics/internal/server/utils"
+	"metrics/pkg/envelope"
+	"metrics/pkg/realip"
+	"metrics/pkg/replay"
 )
 
 // HTTPServer - структура инстанса HTTP сервера
 type HTTPServer struct {
 	auth   *auth
 	Server *http.Server
+	router *chi.Mux
 	logger *logrus.Logger
 }
 
+// agentIDKey - ключ контекста запроса с идентификатором агента, подтвержденным его подписью или сертификатом
+type agentIDKey struct{}
+
 type auth struct {
-	cryptoKey     string
+	keys          keyProvider
 	hashKey       string
-	trustedSubnet *net.IPNet
+	agentKeys     agentKeyStore
+	tokens        tokenStore
+	replay        replayGuard
+	ingestSubnets realip.Subnets
+	readSubnets   realip.Subnets
+
+	// Доверенные прокси, которым разрешено передавать адрес клиента в заголовках
+	trustedProxies realip.Proxies
+
+	// Сертификат клиента проверяется на маршрутах, а не при рукопожатии TLS
+	requireClientCert bool
+}
+
+// keyProvider - интерфейс поставщика приватного ключа для дешифровки запросов
+type keyProvider interface {
+	Key(string) (*rsa.PrivateKey, error)
+	AllowLegacy() bool
+}
+
+// agentKeyStore - интерфейс хранилища HMAC ключей агентов
+type agentKeyStore interface {
+	AgentSecret(string) (string, error)
+}
+
+// tokenStore - интерфейс хранилища токенов API
+type tokenStore interface {
+	TokenScope(string) (tokens.Scope, error)
 }
 
-// NewServer создает инстанс HTTP сервера
-func NewServer(address string, cryptoKey string, hashKey string, trustedSubnet *net.IPNet, storageCommands *StorageCommands, logger *logrus.Logger) *HTTPServer {
+// replayGuard - интерфейс проверки повторов подписанных запросов
+type replayGuard interface {
+	Check(string, string) error
+}
+
+// NewServer создает инстанс HTTP сервера, при keys == nil дешифровка запросов отключена,
+// при agentKeys == nil запросы подписываются общим ключом hashKey, при apiTokens == nil токены не проверяются,
+// при replay == nil повторы не проверяются. Преобразование OTLP converter общее с gRPC сервером
+func NewServer(address string, keys keyProvider, hashKey string, agentKeys agentKeyStore, apiTokens tokenStore, replay replayGuard, ingestSubnets realip.Subnets, readSubnets realip.Subnets, trustedProxies realip.Proxies, tlsConfig *tls.Config, storageCommands *StorageCommands, converter *otlp.Converter, logger *logrus.Logger) *HTTPServer {
 	router := chi.NewRouter()
 
 	instance := &HTTPServer{
 		auth: &auth{
-			cryptoKey:     cryptoKey,
-			hashKey:       hashKey,
-			trustedSubnet: trustedSubnet,
+			keys:           keys,
+			hashKey:        hashKey,
+			agentKeys:      agentKeys,
+			tokens:         apiTokens,
+			replay:         replay,
+			ingestSubnets:  ingestSubnets,
+			readSubnets:    readSubnets,
+			trustedProxies: trustedProxies,
 		},
 		Server: &http.Server{
-			Addr:    address,
-			Handler: router,
+			Addr:      address,
+			Handler:   router,
+			TLSConfig: tlsConfig,
 		},
+		router: router,
 		logger: logger,
 	}
 
 	// Назначение соответствий хендлеров
-	instance.addHandlers(router, NewHandler(storageCommands))
+	instance.addHandlers(router, NewHandler(storageCommands, converter))
 
 	return instance
 }
@@ -65,34 +113,65 @@ func (s *HTTPServer) addHandlers(router *chi.Mux, handler *Handler) {
 	router.Use(
 		middleware.RequestID,
 		s.withLogger,
-		s.withTrustedSubnet,
 		s.withGZipEncode,
 	)
 
-	// /debug profiler
-	router.Mount("/debug", middleware.Profiler())
-
-	// /update
-	router.Route("/update", func(r chi.Router) {
-		r.Post("/", s.withHash(s.withDecrypt(handler.UpdatePostJSON)))
-		r.Post("/{type}/{name}/{value}", handler.UpdatePost)
+	// Маршруты записи метрик
+	router.Group(func(r chi.Router) {
+		r.Use(s.withTrustedSubnet(s.auth.ingestSubnets), s.withClientCert, s.withToken(tokens.ScopeWrite))
+
+		// /update
+		r.Route("/update", func(r chi.Router) {
+			r.Post("/", s.withHash(s.withDecrypt(handler.UpdatePostJSON)))
+			r.Post("/{type}/{name}/{value}", s.withHash(handler.UpdatePost))
+		})
+
+		// /updates
+		r.Route("/updates", func(r chi.Router) {
+			r.Post("/", s.withHash(s.withDecrypt(handler.UpdatesPostJSON)))
+		})
+
+		// Приемники сторонних клиентов не умеют подписывать запросы HMAC,
+		// они аутентифицируются токеном с областью write или сертификатом клиента
+		r.Group(func(r chi.Router) {
+			r.Use(s.withIntegrationAuth)
+
+			// /api/v2/write, совместим с InfluxDB line protocol
+			r.Route("/api/v2", func(r chi.Router) {
+				r.Post("/write", s.withGZipDecode(handler.WritePostLineProtocol))
+			})
+
+			// /v1/metrics, приемник OTLP/HTTP
+			r.Post("/v1/metrics", s.withGZipDecode(handler.OTLPMetricsPost))
+
+			// /api/v1/write, приемник Prometheus remote write
+			r.Post("/api/v1/write", handler.RemoteWritePost)
+		})
 	})
 
-	// /updates
-	router.Route("/updates", func(r chi.Router) {
-		r.Post("/", s.withHash(s.withDecrypt(handler.UpdatesPostJSON)))
-	})
+	// Маршруты чтения метрик
+	router.Group(func(r chi.Router) {
+		r.Use(s.withTrustedSubnet(s.auth.readSubnets), s.withClientCert, s.withToken(tokens.ScopeRead))
 
-	// /value
-	router.Route("/value", func(r chi.Router) {
-		r.Post("/", s.withHash(s.withDecrypt(handler.ValueGetJSON)))
-		r.Get("/{type}/{name}", handler.ValueGet)
-	})
+		// /value, чтение защищено токеном с областью read и подсетями чтения, подпись HMAC не требуется
+		r.Route("/value", func(r chi.Router) {
+			r.Post("/", s.withDecrypt(handler.ValueGetJSON))
+			r.Get("/{type}/{name}", handler.ValueGet)
+		})
+
+		// /api/v1
+		r.Route("/api/v1", func(r chi.Router) {
+			r.Get("/query_range", handler.QueryRangeGet)
+		})
 
-	// index
-	router.Get("/", handler.IndexGet)
+		// index
+		r.Get("/", handler.IndexGet)
+
+		// /metrics
+		r.Get("/metrics", handler.MetricsGet)
+	})
 
-	// /ping
+	// /ping не раскрывает метрики и доступен проверкам состояния без аутентификации
 	router.Get("/ping", handler.PingGet)
 }
 
@@ -123,22 +202,77 @@ func (s *HTTPServer) withLogger(next http.Handler) http.Handler {
 	})
 }
 
-// withTrustedSubnet - middleware проверяет подсеть в хедере запроса
-func (s *HTTPServer) withTrustedSubnet(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		if s.auth.trustedSubnet != nil {
-			requestIP := net.ParseIP(r.Header.Get("X-Real-IP"))
-			if requestIP == nil {
-				s.logger.Errorln("error parsing X-Real-IP header")
-				http.Error(w, "error parsing X-Real-IP header", http.StatusForbidden)
-				return
+// withTrustedSubnet - middleware проверяет, что адрес клиента входит в подсети subnets.
+// Адрес берется из соединения, заголовки прокси учитываются только от доверенных прокси
+func (s *HTTPServer) withTrustedSubnet(subnets realip.Subnets) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(subnets) > 0 {
+				requestIP, err := realip.Resolve(r.RemoteAddr, r.Header.Get(realip.RealIPHeader),
+					r.Header.Get(realip.ForwardedForHeader), s.auth.trustedProxies)
+				if err != nil {
+					s.logger.Errorln("error resolving client IP: ", err)
+					http.Error(w, "error resolving client IP: "+err.Error(), http.StatusForbidden)
+					return
+				}
+
+				if !subnets.Contains(requestIP) {
+					s.logger.Errorf("IP address %s is not trusted", requestIP)
+					http.Error(w, "IP address is not trusted", http.StatusForbidden)
+					return
+				}
 			}
 
-			if !s.auth.trustedSubnet.Contains(requestIP) {
-				s.logger.Errorln("IP address is not trusted")
-				http.Error(w, "IP address is not trusted", http.StatusForbidden)
-				return
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// withToken - middleware проверяет bearer токен и его область доступа.
+// Без хранилища токенов аутентификация отключена
+func (s *HTTPServer) withToken(scope tokens.Scope) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if s.auth.tokens != nil {
+				token, ok := tokens.ParseBearer(r.Header.Get("Authorization"))
+				if !ok {
+					s.logger.Errorln("missing bearer token")
+					w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+					http.Error(w, "missing bearer token", http.StatusUnauthorized)
+					return
+				}
+
+				tokenScope, err := s.auth.tokens.TokenScope(tokens.Hash(token))
+				if err != nil {
+					s.logger.Errorln("token rejected: ", err)
+					w.Header().Set("WWW-Authenticate", `Bearer realm="metrics", error="invalid_token"`)
+					http.Error(w, "token rejected: "+err.Error(), http.StatusUnauthorized)
+					return
+				}
+
+				if !tokenScope.Allows(scope) {
+					s.logger.Errorf("token scope %s does not allow %s", tokenScope, scope)
+					http.Error(w, fmt.Sprintf("token scope %s does not allow %s", tokenScope, scope), http.StatusForbidden)
+					return
+				}
 			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// withIntegrationAuth - middleware аутентификации приемников сторонних клиентов.
+// При настроенной подписи запросов, которую такие клиенты не передают, нужен токен, уже проверенный withToken,
+// или проверенный сертификат клиента. Без подписи и токенов приемники открыты, как и остальные маршруты записи
+func (s *HTTPServer) withIntegrationAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		signed := s.auth.hashKey != "" || s.auth.agentKeys != nil
+		if signed && s.auth.tokens == nil && clientCert(r) == nil {
+			s.logger.Errorln("integration request without token or client certificate")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "write token or client certificate required", http.StatusUnauthorized)
+			return
 		}
 
 		next.ServeHTTP(w, r)
@@ -181,88 +315,154 @@ func (s *HTTPServer) withGZipEncode(next http.Handler) http.Handler {
 	})
 }
 
-// withHash - middleware проверяет наличие хеша в метаданных и сверяет с телом запроса
+// withGZipDecode - middleware распаковывает тело запроса с Content-Encoding: gzip
+func (s *HTTPServer) withGZipDecode(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Encoding") != "gzip" {
+			next(w, r)
+			return
+		}
+
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			s.logger.Error("gZip decode error:", err)
+			http.Error(w, "invalid gzip request body", http.StatusBadRequest)
+			return
+		}
+
+		defer func() {
+			if err = gz.Close(); err != nil {
+				log.Println("gZip middleware: failed close gZip reader", err)
+			}
+		}()
+
+		s.logger.Debugln("decompressing request with gzip")
+
+		// Подмена тела запроса
+		r.Body = gz
+		r.Header.Del("Content-Encoding")
+
+		next(w, r)
+	}
+}
+
+// withHash - middleware проверяет подпись запроса.
+// При настроенном ключе подпись обязательна, ответ подписывается тем же ключом
 func (s *HTTPServer) withHash(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		// Выбор ключа подписи агента
+		hashKey, err := s.hashKeyFor(r.Header.Get(models.InstanceHeader))
+		if err != nil {
+			s.logger.Error("error selecting agent hash key: ", err)
+			http.Error(w, "agent key rejected: "+err.Error(), http.StatusForbidden)
+			return
+		}
+
+		// Подпись не требуется без ключа
+		if hashKey == "" {
+			next(w, r)
+			return
+		}
+
 		//Декодирование хедера
-		requestHeader, err := hex.DecodeString(r.Header.Get("HashSHA256"))
+		header := r.Header.Get("HashSHA256")
+		if header == "" {
+			s.logger.Error("missing hash header")
+			http.Error(w, "missing HashSHA256 header: server requires signed requests", http.StatusUnauthorized)
+			return
+		}
+		requestHeader, err := hex.DecodeString(header)
 		if err != nil {
 			s.logger.Error("error decoding hash header:", err)
-			w.WriteHeader(http.StatusBadRequest)
+			http.Error(w, "invalid HashSHA256 header: expected hex encoded HMAC-SHA256", http.StatusBadRequest)
 			return
 		}
 
-		// Создание обертки для ResponseWriter
-		hashWriter := &utils.HashResponseWriter{
-			ResponseWriter: w,
+		// Чтение тела запроса, закрытие и копирование
+		// для передачи далее по пайплайну
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			s.logger.Error(err)
+			http.Error(w, "failed to read request body", http.StatusBadRequest)
+			return
 		}
 
-		// Проверка наличия ключа из флага и в запросе
-		if len(s.auth.hashKey) > 0 && len(requestHeader) > 0 {
-			var body []byte
-			// Чтение тела запроса, закрытие и копирование
-			// для передачи далее по пайплайну
-			body, err = io.ReadAll(r.Body)
-			if err != nil {
-				s.logger.Error(err)
-				w.WriteHeader(http.StatusBadRequest)
-				return
+		defer func() {
+			if err = r.Body.Close(); err != nil {
+				log.Println("hash middleware: failed close request body", err)
 			}
+		}()
 
-			defer func() {
-				if err = r.Body.Close(); err != nil {
-					log.Println("hash middleware: failed close request body", err)
-				}
-			}()
+		r.Body = io.NopCloser(bytes.NewBuffer(body))
 
-			r.Body = io.NopCloser(bytes.NewBuffer(body))
+		// Вычисление и валидация хэша тела, времени отправки и nonce
+		timestamp, nonce := r.Header.Get(replay.TimestampHeader), r.Header.Get(replay.NonceHeader)
+		hash := utils.GetHash(hashKey, replay.Payload(timestamp, nonce, body))
+		if !hmac.Equal(hash, requestHeader) {
+			s.logger.Error("invalid hash")
+			http.Error(w, "hash does not match: request body or signing key differs", http.StatusForbidden)
+			return
+		}
 
-			// Вычисление и валидация хэша
-			hash := utils.GetHash(s.auth.hashKey, body)
-			if !hmac.Equal(hash, requestHeader) {
-				s.logger.Error("invalid hash")
-				w.WriteHeader(http.StatusBadRequest)
+		// Проверка повтора запроса
+		if s.auth.replay != nil {
+			if err = s.auth.replay.Check(timestamp, nonce); err != nil {
+				s.logger.Error("replay check failed: ", err)
+				http.Error(w, "replay check failed: "+err.Error(), http.StatusUnauthorized)
 				return
 			}
+		}
+
+		// Запрос подписан ключом агента: его идентификатор подтвержден
+		if agentID := r.Header.Get(models.InstanceHeader); s.auth.agentKeys != nil && agentID != "" {
+			r = r.WithContext(context.WithValue(r.Context(), agentIDKey{}, agentID))
+		}
 
-			hashWriter.Key = s.auth.hashKey
+		// Создание обертки для ResponseWriter с подписью ответа
+		hashWriter := &utils.HashResponseWriter{
+			ResponseWriter: w,
+			Key:            hashKey,
 		}
 
 		next(hashWriter, r)
 	}
 }
 
+// hashKeyFor возвращает ключ подписи агента из хранилища ключей агентов,
+// для запросов без идентификатора агента - общий ключ
+func (s *HTTPServer) hashKeyFor(agentID string) (string, error) {
+	if s.auth.agentKeys == nil {
+		return s.auth.hashKey, nil
+	}
+
+	// При хранилище ключей агентов запрос без идентификатора принимается только с общим ключом
+	if agentID == "" {
+		if s.auth.hashKey == "" {
+			return "", fmt.Errorf("missing %s header: server requires per agent signatures", models.InstanceHeader)
+		}
+		return s.auth.hashKey, nil
+	}
+
+	hashKey, err := s.auth.agentKeys.AgentSecret(agentID)
+	if err != nil {
+		return "", err
+	}
+
+	// Пустой ключ агента не отключает проверку подписи
+	if hashKey == "" {
+		return "", fmt.Errorf("empty key of agent %s", agentID)
+	}
+
+	return hashKey, nil
+}
+
 // withDecrypt - middleware для дешифровки тела запроса при наличии флага приватного ключа
 func (s *HTTPServer) withDecrypt(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// TODO вынести чтение файла в инит конфига
-		// Проверка флага приватнрго ключа
-		if s.auth.cryptoKey != "" {
-			// Чтение pem файла
-			privatePEM, err := os.ReadFile(s.auth.cryptoKey)
-			if err != nil {
-				s.logger.Error("error reading private key", err)
-				w.WriteHeader(http.StatusBadRequest)
-				return
-			}
-			// Поиск блока приватного ключа
-			privateKeyBlock, _ := pem.Decode(privatePEM)
-			// Парсинг приватного ключа
-			privateKey, err := x509.ParsePKCS1PrivateKey(privateKeyBlock.Bytes)
-			if err != nil {
-				s.logger.Error("error parsing private key", err)
-				w.WriteHeader(http.StatusBadRequest)
-				return
-			}
-			if err = privateKey.Validate(); err != nil {
-				s.logger.Error("error validate private key", err)
-				w.WriteHeader(http.StatusInternalServerError)
-				return
-			}
-
+		// Проверка наличия приватного ключа
+		if s.auth.keys != nil {
 			// Чтение тела запроса
-			var body []byte
-			body, err = io.ReadAll(r.Body)
+			body, err := io.ReadAll(r.Body)
 			if err != nil {
 				s.logger.Error("error reading body", err)
 				w.WriteHeader(http.StatusBadRequest)
@@ -276,26 +476,21 @@ func (s *HTTPServer) withDecrypt(next http.HandlerFunc) http.HandlerFunc {
 				}
 			}()
 
-			// Установка длины частей публичного ключа
-			blockLen := privateKey.PublicKey.Size()
+			// Выбор ключа по идентификатору из заголовка
+			privateKey, err := s.auth.keys.Key(r.Header.Get(envelope.KeyIDHeader))
+			if err != nil {
+				s.logger.Errorf("error selecting private key: %s", err.Error())
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
 
-			// Дешифровка тела запроса частями
+			// Дешифровка тела запроса по версии схемы шифрования
 			var decryptedBytes []byte
-			for start := 0; start < len(body); start += blockLen {
-				end := start + blockLen
-				if start+blockLen > len(body) {
-					end = len(body)
-				}
-
-				var decryptedChunk []byte
-				decryptedChunk, err = rsa.DecryptPKCS1v15(rand.Reader, privateKey, body[start:end])
-				if err != nil {
-					s.logger.Errorf("error decrypting random text: %s", err.Error())
-					w.WriteHeader(http.StatusBadRequest)
-					return
-				}
-
-				decryptedBytes = append(decryptedBytes, decryptedChunk...)
+			decryptedBytes, err = envelope.Decrypt(r.Header.Get(envelope.Header), privateKey, body, s.auth.keys.AllowLegacy())
+			if err != nil {
+				s.logger.Errorf("error decrypting request body: %s", err.Error())
+				w.WriteHeader(http.StatusBadRequest)
+				return
 			}
 
 			// Подмена тела запроса
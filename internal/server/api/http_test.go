@@ -0,0 +1,309 @@
+package api
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"metrics/internal/models"
+	"metrics/internal/server/otlp"
+	"metrics/internal/server/tokens"
+	"metrics/internal/server/utils"
+	"metrics/internal/storage/memory"
+	"metrics/pkg/realip"
+	"metrics/pkg/replay"
+)
+
+// writeRoutes - маршруты записи метрик, которые при настроенном ключе принимают только аутентифицированные запросы
+var writeRoutes = []struct {
+	path        string
+	contentType string
+	body        string
+}{
+	{path: "/update/", contentType: "application/json", body: `{"id":"alloc","type":"gauge","value":1}`},
+	{path: "/update/gauge/alloc/1"},
+	{path: "/updates/", contentType: "application/json", body: `[{"id":"alloc","type":"gauge","value":1}]`},
+	{path: "/api/v2/write", contentType: "text/plain", body: "cpu usage=1"},
+	{path: "/v1/metrics", contentType: "application/json", body: `{"resourceMetrics":[]}`},
+	{path: "/api/v1/write", contentType: "application/x-protobuf"},
+}
+
+// agentKeys - хранилище ключей агентов для тестов
+type agentKeys map[string]string
+
+func (k agentKeys) AgentSecret(agentID string) (string, error) {
+	key, ok := k[agentID]
+	if !ok {
+		return "", errors.New("unknown agent")
+	}
+
+	return key, nil
+}
+
+// newTestServer создает HTTP сервер над отдельным хранилищем в памяти
+func newTestServer(hashKey string, keys agentKeyStore) (*HTTPServer, *memory.MemoryStorage) {
+	memStorage := memory.NewMemoryStorage()
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	return NewServer("", nil, hashKey, keys, nil, nil, nil, nil, realip.Proxies{}, nil,
+		NewStorageService(memStorage, memStorage, nil), otlp.NewConverter(), logger), memStorage
+}
+
+func TestHTTPServer_UnsignedWritesRejected(t *testing.T) {
+	server, memStorage := newTestServer("secret", nil)
+
+	for _, route := range writeRoutes {
+		t.Run(route.path, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, route.path, bytes.NewBufferString(route.body))
+			if route.contentType != "" {
+				request.Header.Set("Content-Type", route.contentType)
+			}
+			w := httptest.NewRecorder()
+
+			server.router.ServeHTTP(w, request)
+			assert.Equal(t, http.StatusUnauthorized, w.Code)
+		})
+	}
+
+	// Ни одна запись не попала в хранилище
+	all, err := memStorage.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, all)
+}
+
+func TestHTTPServer_SignedWriteAccepted(t *testing.T) {
+	server, memStorage := newTestServer("secret", nil)
+
+	request := httptest.NewRequest(http.MethodPost, "/update/gauge/alloc/1", nil)
+	request.Header.Set("HashSHA256", hex.EncodeToString(utils.GetHash("secret", nil)))
+	w := httptest.NewRecorder()
+
+	server.router.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	data, err := memStorage.Read("alloc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, data)
+}
+
+func TestHTTPServer_UnsignedReadAccepted(t *testing.T) {
+	server, memStorage := newTestServer("secret", nil)
+
+	value := 1.5
+	if err := memStorage.Update(&models.Data{Name: "alloc", Type: "gauge", Value: &value}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Чтение не подписывается HMAC ключом
+	request := httptest.NewRequest(http.MethodPost, "/value/", bytes.NewBufferString(`{"id":"alloc","type":"gauge"}`))
+	request.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	server.router.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "1.5")
+}
+
+func TestHTTPServer_EmptyAgentKeyRejected(t *testing.T) {
+	server, _ := newTestServer("", agentKeys{"host-a": ""})
+
+	request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewBufferString(`[{"id":"alloc","type":"gauge","value":1}]`))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(models.InstanceHeader, "host-a")
+	w := httptest.NewRecorder()
+
+	server.router.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
+
+func TestHTTPServer_AgentInstanceOverridesBody(t *testing.T) {
+	server, memStorage := newTestServer("", agentKeys{"host-a": "key-a"})
+
+	body := []byte(`[{"id":"alloc","type":"gauge","value":1,"labels":{"instance":"host-b"}}]`)
+	request := httptest.NewRequest(http.MethodPost, "/updates/", bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(models.InstanceHeader, "host-a")
+	request.Header.Set("HashSHA256", hex.EncodeToString(utils.GetHash("key-a", replay.Payload("", "", body))))
+	w := httptest.NewRecorder()
+
+	server.router.ServeHTTP(w, request)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Метка из тела заменена идентификатором подписавшего агента
+	data, err := memStorage.Read("alloc", map[string]string{models.InstanceLabel: "host-a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NotNil(t, data)
+
+	spoofed, err := memStorage.Read("alloc", map[string]string{models.InstanceLabel: "host-b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, spoofed)
+}
+
+// apiTokens - хранилище токенов API для тестов по хешу токена
+type apiTokens map[string]tokens.Scope
+
+func (a apiTokens) TokenScope(tokenHash string) (tokens.Scope, error) {
+	scope, ok := a[tokenHash]
+	if !ok {
+		return "", tokens.ErrUnknownToken
+	}
+
+	return scope, nil
+}
+
+// newAuthTestServer создает HTTP сервер с токенами API и доверенными подсетями
+func newAuthTestServer(t *testing.T, apiTokens tokenStore, ingest string, read string, proxies string) *HTTPServer {
+	t.Helper()
+
+	subnets := make([]realip.Subnets, 0, 3)
+	for _, list := range []string{ingest, read, proxies} {
+		parsed, err := realip.ParseSubnets(list)
+		if err != nil {
+			t.Fatal(err)
+		}
+		subnets = append(subnets, parsed)
+	}
+
+	memStorage := memory.NewMemoryStorage()
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	return NewServer("", nil, "", nil, apiTokens, nil, subnets[0], subnets[1], realip.Proxies{Subnets: subnets[2]}, nil,
+		NewStorageService(memStorage, memStorage, nil), otlp.NewConverter(), logger)
+}
+
+// integrationRoutes - маршруты сторонних клиентов, которые аутентифицируются токеном вместо подписи
+var integrationRoutes = []struct {
+	path        string
+	contentType string
+	body        string
+}{
+	{path: "/api/v2/write", contentType: "text/plain", body: "cpu usage=1"},
+	{path: "/v1/metrics", contentType: "application/json", body: `{"resourceMetrics":[]}`},
+	{path: "/api/v1/write", contentType: "application/x-protobuf"},
+}
+
+func TestHTTPServer_IntegrationToken(t *testing.T) {
+	memStorage := memory.NewMemoryStorage()
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	// Подпись запросов включена, но сторонние клиенты передают только токен
+	server := NewServer("", nil, "secret", nil, apiTokens{
+		tokens.Hash("write-token"): tokens.ScopeWrite,
+		tokens.Hash("read-token"):  tokens.ScopeRead,
+	}, nil, nil, nil, realip.Proxies{}, nil, NewStorageService(memStorage, memStorage, nil), otlp.NewConverter(), logger)
+
+	for _, route := range integrationRoutes {
+		t.Run(route.path, func(t *testing.T) {
+			post := func(token string) int {
+				request := httptest.NewRequest(http.MethodPost, route.path, bytes.NewBufferString(route.body))
+				request.Header.Set("Content-Type", route.contentType)
+				if token != "" {
+					request.Header.Set("Authorization", "Bearer "+token)
+				}
+				w := httptest.NewRecorder()
+
+				server.router.ServeHTTP(w, request)
+				return w.Code
+			}
+
+			assert.Equal(t, http.StatusUnauthorized, post(""))
+			assert.Equal(t, http.StatusForbidden, post("read-token"))
+			assert.NotContains(t, []int{http.StatusUnauthorized, http.StatusForbidden}, post("write-token"))
+		})
+	}
+}
+
+func TestHTTPServer_TrustedSubnet(t *testing.T) {
+	server := newAuthTestServer(t, nil, "10.0.0.0/8", "192.168.0.0/16", "172.16.0.1/32")
+
+	tests := []struct {
+		name         string
+		method       string
+		path         string
+		remoteAddr   string
+		realIP       string
+		forwardedFor string
+		wantCode     int
+	}{
+		{name: "write from ingest subnet", method: http.MethodPost, path: "/update/gauge/alloc/1", remoteAddr: "10.0.0.5:4000", wantCode: http.StatusOK},
+		{name: "write from read subnet", method: http.MethodPost, path: "/update/gauge/alloc/1", remoteAddr: "192.168.0.5:4000", wantCode: http.StatusForbidden},
+		{name: "spoofed forwarded for from untrusted peer", method: http.MethodPost, path: "/update/gauge/alloc/1", remoteAddr: "8.8.8.8:4000", forwardedFor: "10.0.0.5", wantCode: http.StatusForbidden},
+		{name: "forwarded for from trusted proxy", method: http.MethodPost, path: "/update/gauge/alloc/1", remoteAddr: "172.16.0.1:4000", forwardedFor: "10.0.0.5", wantCode: http.StatusOK},
+		{name: "client set forwarded for through trusted proxy", method: http.MethodPost, path: "/update/gauge/alloc/1", remoteAddr: "172.16.0.1:4000", forwardedFor: "10.0.0.5, 8.8.8.8", wantCode: http.StatusForbidden},
+		{name: "real ip is not trusted by default", method: http.MethodPost, path: "/update/gauge/alloc/1", remoteAddr: "172.16.0.1:4000", realIP: "10.0.0.5", wantCode: http.StatusForbidden},
+		{name: "read from read subnet", method: http.MethodGet, path: "/metrics", remoteAddr: "192.168.0.5:4000", wantCode: http.StatusOK},
+		{name: "read from ingest subnet", method: http.MethodGet, path: "/metrics", remoteAddr: "10.0.0.5:4000", wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.path, nil)
+			request.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				request.Header.Set(realip.RealIPHeader, tt.realIP)
+			}
+			if tt.forwardedFor != "" {
+				request.Header.Set(realip.ForwardedForHeader, tt.forwardedFor)
+			}
+			w := httptest.NewRecorder()
+
+			server.router.ServeHTTP(w, request)
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
+func TestHTTPServer_TokenScope(t *testing.T) {
+	server := newAuthTestServer(t, apiTokens{
+		tokens.Hash("write-token"): tokens.ScopeWrite,
+		tokens.Hash("read-token"):  tokens.ScopeRead,
+		tokens.Hash("admin-token"): tokens.ScopeAdmin,
+	}, "", "", "")
+
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		token    string
+		wantCode int
+	}{
+		{name: "write without token", method: http.MethodPost, path: "/update/gauge/alloc/1", wantCode: http.StatusUnauthorized},
+		{name: "write with unknown token", method: http.MethodPost, path: "/update/gauge/alloc/1", token: "other", wantCode: http.StatusUnauthorized},
+		{name: "write with write token", method: http.MethodPost, path: "/update/gauge/alloc/1", token: "write-token", wantCode: http.StatusOK},
+		{name: "write with read token", method: http.MethodPost, path: "/update/gauge/alloc/1", token: "read-token", wantCode: http.StatusForbidden},
+		{name: "write with admin token", method: http.MethodPost, path: "/update/gauge/alloc/1", token: "admin-token", wantCode: http.StatusOK},
+		{name: "read without token", method: http.MethodGet, path: "/metrics", wantCode: http.StatusUnauthorized},
+		{name: "read with read token", method: http.MethodGet, path: "/metrics", token: "read-token", wantCode: http.StatusOK},
+		{name: "read with write token", method: http.MethodGet, path: "/metrics", token: "write-token", wantCode: http.StatusForbidden},
+		{name: "read with admin token", method: http.MethodGet, path: "/metrics", token: "admin-token", wantCode: http.StatusOK},
+		{name: "ping without token", method: http.MethodGet, path: "/ping", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.token != "" {
+				request.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+
+			server.router.ServeHTTP(w, request)
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
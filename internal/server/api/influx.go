@@ -0,0 +1,262 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"metrics/internal/models"
+)
+
+// lineProtocolPrecisions - единицы времени точек по значению параметра precision, по умолчанию наносекунды
+var lineProtocolPrecisions = map[string]time.Duration{
+	"":   time.Nanosecond,
+	"ns": time.Nanosecond,
+	"us": time.Microsecond,
+	"ms": time.Millisecond,
+	"s":  time.Second,
+}
+
+// lineProtocolUnescaper снимает экранирование имен, тегов и ключей полей line protocol
+var lineProtocolUnescaper = strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=", `\"`, `"`, `\\`, `\`)
+
+// WritePostLineProtocol - метод ручки "POST /api/v2/write с телом в формате InfluxDB line protocol".
+// Теги становятся метками серии, каждое числовое поле - gauge с именем measurement_field.
+// Строковые и логические поля пропускаются. Время точки в единицах параметра precision сохраняется в истории,
+// точки без времени получают время приема
+func (h *Handler) WritePostLineProtocol(w http.ResponseWriter, req *http.Request) {
+	// Проверка единиц времени точек
+	precision, ok := lineProtocolPrecisions[req.URL.Query().Get("precision")]
+	if !ok {
+		http.Error(w, "invalid precision, expected ns, us, ms or s", http.StatusBadRequest)
+		return
+	}
+
+	// Чтение тела запроса
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.Println("WritePostLineProtocol: failed read request body", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	defer func() {
+		if err = req.Body.Close(); err != nil {
+			log.Println("WritePostLineProtocol: failed close request body", err)
+		}
+	}()
+
+	// Разбор точек
+	storageData, err := parseLineProtocol(string(body), precision)
+	if err != nil {
+		log.Println("WritePostLineProtocol: failed parse request body", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Запрос без числовых полей не меняет хранилище
+	if len(storageData) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Проход по метрикам
+	for _, data := range storageData {
+		// Проверка невалидных значений
+		if err = data.CheckData(); err != nil {
+			log.Println("WritePostLineProtocol: failed check request body", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Отметка инстанса агента
+		markInstance(req, data)
+	}
+
+	// Обновление или сохранение новых записей в хранилище
+	if err = h.storageCommands.UpdateBatch(storageData); err != nil {
+		log.Println("WritePostLineProtocol: update handler error:", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Ответ как у InfluxDB при успешной записи
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// parseLineProtocol разбирает строки вида measurement[,tag=value...] field=value[,field=value...] [timestamp],
+// время точки задано в единицах precision. Пустые строки и комментарии пропускаются
+func parseLineProtocol(body string, precision time.Duration) ([]*models.Data, error) {
+	data := make([]*models.Data, 0)
+	for i, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		points, err := parseLineProtocolLine(line, precision)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		data = append(data, points...)
+	}
+
+	return data, nil
+}
+
+// parseLineProtocolLine разбирает одну строку line protocol в gauge метрики числовых полей
+func parseLineProtocolLine(line string, precision time.Duration) ([]*models.Data, error) {
+	// Имя и теги отделены от полей первым неэкранированным пробелом
+	keyEnd := indexUnescaped(line, ' ', false)
+	if keyEnd < 0 {
+		return nil, fmt.Errorf("missing fields")
+	}
+	key, rest := line[:keyEnd], line[keyEnd+1:]
+
+	// Поля отделены от времени точки пробелом вне строковых значений
+	fieldSet, timestamp := rest, ""
+	if fieldsEnd := indexUnescaped(rest, ' ', true); fieldsEnd >= 0 {
+		fieldSet, timestamp = rest[:fieldsEnd], rest[fieldsEnd+1:]
+	}
+	if fieldSet == "" {
+		return nil, fmt.Errorf("missing fields")
+	}
+	var pointTime *time.Time
+	if timestamp != "" {
+		units, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil || units > math.MaxInt64/int64(precision) || units < math.MinInt64/int64(precision) {
+			return nil, fmt.Errorf("invalid timestamp %q", timestamp)
+		}
+
+		parsed := time.Unix(0, units*int64(precision))
+		pointTime = &parsed
+	}
+
+	// Имя измерения и теги
+	keyParts := splitUnescaped(key, ',', false)
+	measurement := lineProtocolUnescaper.Replace(keyParts[0])
+	if measurement == "" {
+		return nil, fmt.Errorf("missing measurement")
+	}
+
+	labels := make(map[string]string, len(keyParts)-1)
+	for _, tag := range keyParts[1:] {
+		tagKey, tagValue, ok := cutUnescaped(tag, '=')
+		if !ok || tagKey == "" || tagValue == "" {
+			return nil, fmt.Errorf("invalid tag %q", tag)
+		}
+		labels[lineProtocolUnescaper.Replace(tagKey)] = lineProtocolUnescaper.Replace(tagValue)
+	}
+
+	// Числовые поля
+	data := make([]*models.Data, 0)
+	for _, field := range splitUnescaped(fieldSet, ',', true) {
+		fieldKey, fieldValue, ok := cutUnescaped(field, '=')
+		if !ok || fieldKey == "" || fieldValue == "" {
+			return nil, fmt.Errorf("invalid field %q", field)
+		}
+
+		value, numeric, err := parseFieldValue(fieldValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid field %q: %w", field, err)
+		}
+		if !numeric {
+			continue
+		}
+
+		// Метки копируются, чтобы записи не разделяли одну карту
+		fieldLabels := make(map[string]string, len(labels))
+		for labelKey, labelValue := range labels {
+			fieldLabels[labelKey] = labelValue
+		}
+
+		data = append(data, &models.Data{
+			Type:      "gauge",
+			Name:      measurement + "_" + lineProtocolUnescaper.Replace(fieldKey),
+			Value:     &value,
+			Labels:    fieldLabels,
+			Timestamp: pointTime,
+		})
+	}
+
+	return data, nil
+}
+
+// parseFieldValue разбирает значение поля: float, целое с суффиксом i или беззнаковое с суффиксом u.
+// Для строк и логических значений возвращает numeric = false
+func parseFieldValue(value string) (float64, bool, error) {
+	switch {
+	case strings.HasPrefix(value, `"`):
+		if len(value) < 2 || !strings.HasSuffix(value, `"`) {
+			return 0, false, fmt.Errorf("unterminated string")
+		}
+		return 0, false, nil
+	case strings.HasSuffix(value, "i"):
+		integer, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+		return float64(integer), err == nil, err
+	case strings.HasSuffix(value, "u"):
+		unsigned, err := strconv.ParseUint(value[:len(value)-1], 10, 64)
+		return float64(unsigned), err == nil, err
+	}
+
+	switch value {
+	case "t", "T", "true", "True", "TRUE", "f", "F", "false", "False", "FALSE":
+		return 0, false, nil
+	}
+
+	float, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, false, err
+	}
+	if math.IsNaN(float) || math.IsInf(float, 0) {
+		return 0, false, fmt.Errorf("unsupported value %s", value)
+	}
+
+	return float, true, nil
+}
+
+// indexUnescaped возвращает позицию первого неэкранированного разделителя sep.
+// При quoted разделители внутри строковых значений в кавычках пропускаются
+func indexUnescaped(s string, sep byte, quoted bool) int {
+	inQuotes := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case quoted && s[i] == '"':
+			inQuotes = !inQuotes
+		case s[i] == sep && !inQuotes:
+			return i
+		}
+	}
+
+	return -1
+}
+
+// splitUnescaped делит строку по неэкранированным разделителям sep
+func splitUnescaped(s string, sep byte, quoted bool) []string {
+	parts := make([]string, 0, 1)
+	for {
+		i := indexUnescaped(s, sep, quoted)
+		if i < 0 {
+			return append(parts, s)
+		}
+		parts = append(parts, s[:i])
+		s = s[i+1:]
+	}
+}
+
+// cutUnescaped делит строку по первому неэкранированному разделителю sep
+func cutUnescaped(s string, sep byte) (string, string, bool) {
+	i := indexUnescaped(s, sep, false)
+	if i < 0 {
+		return s, "", false
+	}
+
+	return s[:i], s[i+1:], true
+}
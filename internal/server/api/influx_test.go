@@ -0,0 +1,121 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLineProtocol(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		precision time.Duration
+		want      []string
+		wantErr   bool
+	}{
+		{
+			name: "tags and numeric fields",
+			body: "cpu,host=a,region=eu usage=1.5,count=3i,free=4u 1700000000000000000\n",
+			want: []string{
+				`cpu_usage{host="a",region="eu"} 1.5 2023-11-14T22:13:20Z`,
+				`cpu_count{host="a",region="eu"} 3 2023-11-14T22:13:20Z`,
+				`cpu_free{host="a",region="eu"} 4 2023-11-14T22:13:20Z`,
+			},
+		},
+		{
+			name: "string and boolean fields are skipped",
+			body: `disk,path=/data\ 1 used=10,label="a b, c=d",ok=true`,
+			want: []string{`disk_used{path="/data 1"} 10 -`},
+		},
+		{
+			name:      "escaped spaces in quoted field before timestamp",
+			body:      `log,app=api\ gw status="a\ b c",code=500i 1700000000`,
+			precision: time.Second,
+			want:      []string{`log_code{app="api gw"} 500 2023-11-14T22:13:20Z`},
+		},
+		{
+			name:      "millisecond precision",
+			body:      "mem value=2 1700000000500",
+			precision: time.Millisecond,
+			want:      []string{`mem_value 2 2023-11-14T22:13:20.5Z`},
+		},
+		{
+			name: "comments and empty lines",
+			body: "# comment\n\nmem value=2\r\n",
+			want: []string{`mem_value 2 -`},
+		},
+		{name: "missing fields", body: "cpu,host=a", wantErr: true},
+		{name: "invalid tag", body: "cpu,host usage=1", wantErr: true},
+		{name: "invalid value", body: "cpu usage=abc", wantErr: true},
+		{name: "invalid timestamp", body: "cpu usage=1 now", wantErr: true},
+		{name: "timestamp overflows precision", body: "cpu usage=1 9223372036854775807", precision: time.Second, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			precision := tt.precision
+			if precision == 0 {
+				precision = time.Nanosecond
+			}
+
+			data, err := parseLineProtocol(tt.body, precision)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			got := make([]string, 0, len(data))
+			for _, metric := range data {
+				assert.Equal(t, "gauge", metric.Type)
+
+				timestamp := "-"
+				if metric.Timestamp != nil {
+					timestamp = metric.Timestamp.UTC().Format(time.RFC3339Nano)
+				}
+				got = append(got, fmt.Sprintf("%s %v %s", metric.SeriesKey(), *metric.Value, timestamp))
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHandler_WritePostLineProtocol(t *testing.T) {
+	tests := []struct {
+		name      string
+		precision string
+		code      int
+	}{
+		{name: "seconds", precision: "s", code: http.StatusNoContent},
+		{name: "unknown precision", precision: "h", code: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, memStorage := newTestServer("", nil)
+
+			request := httptest.NewRequest(http.MethodPost, "/api/v2/write?precision="+tt.precision,
+				bytes.NewBufferString("cpu,host=a usage=1.5 1700000000\n"))
+			w := httptest.NewRecorder()
+
+			server.router.ServeHTTP(w, request)
+			assert.Equal(t, tt.code, w.Code)
+
+			if tt.code != http.StatusNoContent {
+				return
+			}
+
+			samples, err := memStorage.ReadRange("cpu_usage", map[string]string{"host": "a"}, time.Unix(0, 0), time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if assert.Len(t, samples, 1) {
+				assert.True(t, samples[0].Timestamp.Equal(time.Unix(1700000000, 0)))
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"io"
+	"log"
+	"mime"
+	"net/http"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// otlpProtobufContentType - тип контента OTLP/HTTP в бинарном protobuf
+	otlpProtobufContentType = "application/x-protobuf"
+	// otlpJSONContentType - тип контента OTLP/HTTP в JSON кодировке protobuf
+	otlpJSONContentType = "application/json"
+)
+
+// OTLPMetricsPost - метод ручки "POST /v1/metrics с телом OTLP ExportMetricsServiceRequest".
+// Принимает protobuf и JSON, ответ отправляется в кодировке запроса
+func (h *Handler) OTLPMetricsPost(w http.ResponseWriter, req *http.Request) {
+	// Проверка хедера
+	contentType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if err != nil || (contentType != otlpProtobufContentType && contentType != otlpJSONContentType) {
+		http.Error(w, "unsupported content type, expected "+otlpProtobufContentType+" or "+otlpJSONContentType, http.StatusUnsupportedMediaType)
+		return
+	}
+
+	// Чтение тела запроса
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.Println("OTLPMetricsPost: failed read request body", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	defer func() {
+		if err = req.Body.Close(); err != nil {
+			log.Println("OTLPMetricsPost: failed close request body", err)
+		}
+	}()
+
+	// Десериализация тела запроса
+	request := &colmetricspb.ExportMetricsServiceRequest{}
+	if contentType == otlpJSONContentType {
+		err = protojson.Unmarshal(body, request)
+	} else {
+		err = proto.Unmarshal(body, request)
+	}
+	if err != nil {
+		log.Println("OTLPMetricsPost: failed unmarshall request body", err)
+		http.Error(w, "invalid ExportMetricsServiceRequest: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	storageData, partialSuccess, pending := h.otlp.Convert(request)
+
+	// Проход по метрикам
+	for _, data := range storageData {
+		// Проверка невалидных значений
+		if err = data.CheckData(); err != nil {
+			log.Println("OTLPMetricsPost: failed check request body", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// Отметка инстанса агента
+		markInstance(req, data)
+	}
+
+	// Обновление или сохранение новых записей в хранилище
+	if len(storageData) > 0 {
+		if err = h.storageCommands.UpdateBatch(storageData); err != nil {
+			log.Println("OTLPMetricsPost: update handler error:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Накопленные значения сохраняются только после записи, повтор после ошибки даст те же приращения
+	h.otlp.Commit(pending)
+
+	// Сериализация ответа в кодировке запроса
+	response := &colmetricspb.ExportMetricsServiceResponse{PartialSuccess: partialSuccess}
+	var responseBody []byte
+	if contentType == otlpJSONContentType {
+		responseBody, err = protojson.Marshal(response)
+	} else {
+		responseBody, err = proto.Marshal(response)
+	}
+	if err != nil {
+		log.Println("OTLPMetricsPost: failed marshal response", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// Назначение хедера и статуса
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if _, err = w.Write(responseBody); err != nil {
+		log.Println("OTLPMetricsPost: failed write response", err)
+	}
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"metrics/internal/models"
+)
+
+// prometheusContentType - тип контента текстового формата Prometheus 0.0.4
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// writePrometheus записывает метрики в текстовом формате Prometheus 0.0.4
+func writePrometheus(w io.Writer, data []*models.Data) error {
+	// Сортировка по идентификатору серии, чтобы при совпадении очищенных имен
+	// тип семейства и выбранная серия не зависели от порядка чтения из хранилища
+	sorted := make([]*models.Data, 0, len(data))
+	for _, metric := range data {
+		if prometheusType(metric.Type) != "" {
+			sorted = append(sorted, metric)
+		}
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].SeriesKey() < sorted[j].SeriesKey()
+	})
+
+	// Группировка метрик по очищенному имени
+	families := make(map[string][]*models.Data)
+	for _, metric := range sorted {
+		name := sanitizeMetricName(metric.Name)
+		families[name] = append(families[name], metric)
+	}
+
+	// Сортировка имен для стабильного вывода
+	names := make([]string, 0, len(families))
+	for name := range families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	buf := bufio.NewWriter(w)
+	for _, name := range names {
+		metrics := families[name]
+
+		// Тип семейства определяется первой по идентификатору серии метрикой,
+		// метрики другого типа с тем же очищенным именем пропускаются
+		metricType := prometheusType(metrics[0].Type)
+
+		if _, err := fmt.Fprintf(buf, "# TYPE %s %s\n", name, metricType); err != nil {
+			return err
+		}
+
+		// Сортировка серий семейства для стабильного вывода
+		series := make(map[string]*models.Data, len(metrics))
+		keys := make([]string, 0, len(metrics))
+		for _, metric := range metrics {
+			if prometheusType(metric.Type) != metricType {
+				log.Printf("writePrometheus: skip metric %s: type conflicts with %s", metric.Name, metricType)
+				continue
+			}
+
+			key := name + formatLabels(metric.Labels)
+			if _, ok := series[key]; ok {
+				log.Printf("writePrometheus: skip metric %s: duplicated series %s", metric.Name, key)
+				continue
+			}
+			series[key] = metric
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			value, ok := prometheusValue(series[key])
+			if !ok {
+				continue
+			}
+
+			if _, err := fmt.Fprintf(buf, "%s %s\n", key, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return buf.Flush()
+}
+
+// prometheusType сопоставляет тип метрики хранилища с типом Prometheus
+func prometheusType(metricType string) string {
+	switch metricType {
+	case "gauge":
+		return "gauge"
+	case "counter":
+		return "counter"
+	default:
+		return ""
+	}
+}
+
+// prometheusValue форматирует значение метрики
+func prometheusValue(metric *models.Data) (string, bool) {
+	switch {
+	case metric.Type == "counter" && metric.Delta != nil:
+		return strconv.FormatInt(*metric.Delta, 10), true
+	case metric.Type == "gauge" && metric.Value != nil:
+		return formatFloat(*metric.Value), true
+	default:
+		return "", false
+	}
+}
+
+// formatFloat форматирует число с плавающей точкой по правилам формата Prometheus
+func formatFloat(value float64) string {
+	switch {
+	case math.IsNaN(value):
+		return "NaN"
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	}
+}
+
+// formatLabels форматирует метки серии в виде {key="value",...}
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	// Очистка имен меток в порядке исходных имен: при совпадении очищенных имен
+	// остается значение первой по имени метки, а не случайной по порядку обхода карты
+	original := make([]string, 0, len(labels))
+	for key := range labels {
+		original = append(original, key)
+	}
+	sort.Strings(original)
+
+	sanitized := make(map[string]string, len(labels))
+	keys := make([]string, 0, len(labels))
+	for _, key := range original {
+		name := sanitizeLabelName(key)
+		if _, ok := sanitized[name]; ok {
+			continue
+		}
+		sanitized[name] = labels[key]
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(key)
+		b.WriteString(`="`)
+		b.WriteString(labelValueReplacer.Replace(sanitized[key]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+// labelValueReplacer экранирует значения меток
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// sanitizeLabelName приводит имя метки к виду [a-zA-Z_][a-zA-Z0-9_]*
+func sanitizeLabelName(name string) string {
+	return strings.ReplaceAll(sanitizeMetricName(name), ":", "_")
+}
+
+// sanitizeMetricName приводит имя метрики к виду [a-zA-Z_:][a-zA-Z0-9_:]*
+func sanitizeMetricName(name string) string {
+	if name == "" {
+		return "_"
+	}
+
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r == '_', r == ':':
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteRune('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+
+	return b.String()
+}
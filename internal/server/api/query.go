@@ -0,0 +1,243 @@
+package api
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"metrics/internal/models"
+)
+
+const (
+	// Параметры запроса диапазона по умолчанию
+	defaultRangeWindow = time.Hour
+	defaultRangeStep   = time.Minute
+	defaultRangeAgg    = "avg"
+
+	// Максимальное количество интервалов в ответе
+	maxRangePoints = 11000
+
+	// Максимальное время в unix секундах, 9999-12-31T23:59:59Z
+	maxRangeUnix = 253402300799
+)
+
+// rangeQuery - структура параметров запроса диапазона
+type rangeQuery struct {
+	name    string
+	labels  map[string]string
+	from    time.Time
+	to      time.Time
+	step    time.Duration
+	aggName string
+	agg     aggregator
+}
+
+// rangeResponse - структура ответа запроса диапазона
+type rangeResponse struct {
+	Type   string            `json:"type"`
+	Name   string            `json:"id"`
+	Labels map[string]string `json:"labels,omitempty"`
+	From   time.Time         `json:"from"`
+	To     time.Time         `json:"to"`
+	Step   float64           `json:"step"`
+	Agg    string            `json:"agg"`
+	Points []*rangePoint     `json:"points"`
+}
+
+// rangePoint - структура точки агрегированного интервала
+type rangePoint struct {
+	Timestamp time.Time `json:"timestamp"`
+	Value     float64   `json:"value"`
+}
+
+// aggregator - функция агрегации значений интервала
+type aggregator func(values []float64) float64
+
+// aggregators - поддерживаемые функции агрегации
+var aggregators = map[string]aggregator{
+	"avg": func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum / float64(len(values))
+	},
+	"min": func(values []float64) float64 {
+		res := math.Inf(1)
+		for _, v := range values {
+			res = math.Min(res, v)
+		}
+		return res
+	},
+	"max": func(values []float64) float64 {
+		res := math.Inf(-1)
+		for _, v := range values {
+			res = math.Max(res, v)
+		}
+		return res
+	},
+	"sum": func(values []float64) float64 {
+		var sum float64
+		for _, v := range values {
+			sum += v
+		}
+		return sum
+	},
+	"last": func(values []float64) float64 {
+		return values[len(values)-1]
+	},
+}
+
+// parseRangeQuery разбирает и валидирует параметры запроса диапазона
+func parseRangeQuery(params map[string][]string) (*rangeQuery, error) {
+	var err error
+	get := func(key string) string {
+		if v, ok := params[key]; ok && len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+
+	query := &rangeQuery{
+		name: get("name"),
+		to:   time.Now(),
+		step: defaultRangeStep,
+	}
+	if query.name == "" {
+		return nil, fmt.Errorf("empty metric name")
+	}
+
+	// Метки серии передаются параметрами label=key=value
+	for _, label := range params["label"] {
+		key, value, ok := strings.Cut(label, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid label %q, expected key=value", label)
+		}
+		if query.labels == nil {
+			query.labels = make(map[string]string)
+		}
+		query.labels[key] = value
+	}
+
+	if to := get("to"); to != "" {
+		if query.to, err = parseRangeTime(to); err != nil {
+			return nil, fmt.Errorf("invalid to: %w", err)
+		}
+	}
+
+	query.from = query.to.Add(-defaultRangeWindow)
+	if from := get("from"); from != "" {
+		if query.from, err = parseRangeTime(from); err != nil {
+			return nil, fmt.Errorf("invalid from: %w", err)
+		}
+	}
+
+	if !query.from.Before(query.to) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+
+	if step := get("step"); step != "" {
+		if query.step, err = parseRangeStep(step); err != nil {
+			return nil, fmt.Errorf("invalid step: %w", err)
+		}
+	}
+
+	if query.step <= 0 {
+		return nil, fmt.Errorf("step must be positive")
+	}
+
+	if query.to.Sub(query.from)/query.step > maxRangePoints {
+		return nil, fmt.Errorf("exceeded maximum of %d points, increase step", maxRangePoints)
+	}
+
+	query.aggName = get("agg")
+	if query.aggName == "" {
+		query.aggName = defaultRangeAgg
+	}
+	agg, ok := aggregators[query.aggName]
+	if !ok {
+		return nil, fmt.Errorf("unknown aggregation %q", query.aggName)
+	}
+	query.agg = agg
+
+	return query, nil
+}
+
+// parseRangeTime разбирает время в формате unix секунд или RFC3339.
+// Нечисловые значения NaN и Inf и время за пределами [0, 9999 год] отклоняются
+func parseRangeTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if math.IsNaN(seconds) || seconds < 0 || seconds > maxRangeUnix {
+			return time.Time{}, fmt.Errorf("unix time %q out of range", value)
+		}
+		sec, frac := math.Modf(seconds)
+		return time.Unix(int64(sec), int64(frac*float64(time.Second))), nil
+	}
+
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseRangeStep разбирает шаг в формате длительности Go или количества секунд.
+// Шаг должен быть положительным и после приведения к time.Duration
+func parseRangeStep(value string) (time.Duration, error) {
+	var step time.Duration
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		if math.IsNaN(seconds) || seconds <= 0 || seconds*float64(time.Second) >= math.MaxInt64 {
+			return 0, fmt.Errorf("step %q out of range", value)
+		}
+		step = time.Duration(seconds * float64(time.Second))
+	} else if step, err = time.ParseDuration(value); err != nil {
+		return 0, err
+	}
+
+	if step <= 0 {
+		return 0, fmt.Errorf("step %q must be positive", value)
+	}
+
+	return step, nil
+}
+
+// bucketSamples разбивает точки истории на интервалы шага и агрегирует значения.
+// Пустые интервалы в ответ не попадают
+func bucketSamples(samples []*models.Sample, query *rangeQuery) []*rangePoint {
+	buckets := make(map[int64][]float64)
+	order := make([]int64, 0)
+
+	for _, sample := range samples {
+		if sample.Timestamp.Before(query.from) || sample.Timestamp.After(query.to) {
+			continue
+		}
+
+		var value float64
+		switch {
+		case sample.Value != nil:
+			value = *sample.Value
+		case sample.Delta != nil:
+			value = float64(*sample.Delta)
+		default:
+			continue
+		}
+
+		index := int64(sample.Timestamp.Sub(query.from) / query.step)
+		if _, ok := buckets[index]; !ok {
+			order = append(order, index)
+		}
+		buckets[index] = append(buckets[index], value)
+	}
+
+	// Упорядочивание интервалов по времени
+	slices.Sort(order)
+
+	res := make([]*rangePoint, 0, len(order))
+	for _, index := range order {
+		res = append(res, &rangePoint{
+			Timestamp: query.from.Add(time.Duration(index) * query.step),
+			Value:     query.agg(buckets[index]),
+		})
+	}
+
+	return res
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/golang/snappy"
+	"google.golang.org/protobuf/proto"
+
+	"metrics/internal/models"
+	"metrics/internal/server/prompb"
+)
+
+const (
+	// remoteWriteMaxSize - максимальный размер тела remote write до и после распаковки
+	remoteWriteMaxSize = 32 << 20
+
+	// metricNameLabel - метка Prometheus с названием метрики
+	metricNameLabel = "__name__"
+)
+
+// RemoteWritePost - метод ручки "POST /api/v1/write с телом Prometheus remote write".
+// Тело - WriteRequest в protobuf, сжатый snappy. Каждая точка ряда записывается как gauge
+// с метками ряда и временем точки, маркеры устаревания (NaN) пропускаются
+func (h *Handler) RemoteWritePost(w http.ResponseWriter, req *http.Request) {
+	// Чтение тела запроса с ограничением размера
+	req.Body = http.MaxBytesReader(w, req.Body, remoteWriteMaxSize)
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		log.Println("RemoteWritePost: failed read request body", err)
+
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, fmt.Sprintf("body exceeds %d bytes", remoteWriteMaxSize), http.StatusRequestEntityTooLarge)
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	defer func() {
+		if err = req.Body.Close(); err != nil {
+			log.Println("RemoteWritePost: failed close request body", err)
+		}
+	}()
+
+	// Распаковка snappy с ограничением размера
+	decodedLen, err := snappy.DecodedLen(body)
+	if err != nil {
+		log.Println("RemoteWritePost: failed decode snappy body", err)
+		http.Error(w, "invalid snappy body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if decodedLen > remoteWriteMaxSize {
+		http.Error(w, fmt.Sprintf("decoded body exceeds %d bytes", remoteWriteMaxSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	decoded, err := snappy.Decode(nil, body)
+	if err != nil {
+		log.Println("RemoteWritePost: failed decode snappy body", err)
+		http.Error(w, "invalid snappy body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Десериализация тела запроса
+	request := &prompb.WriteRequest{}
+	if err = proto.Unmarshal(decoded, request); err != nil {
+		log.Println("RemoteWritePost: failed unmarshall request body", err)
+		http.Error(w, "invalid WriteRequest: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	storageData, err := remoteWriteToData(request)
+	if err != nil {
+		log.Println("RemoteWritePost: failed check request body", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Отметка инстанса агента
+	for _, data := range storageData {
+		markInstance(req, data)
+	}
+
+	// Обновление или сохранение новых записей в хранилище.
+	// Ошибка хранилища возвращается 5xx, чтобы Prometheus повторил отправку
+	if len(storageData) > 0 {
+		if err = h.storageCommands.UpdateBatch(storageData); err != nil {
+			log.Println("RemoteWritePost: update handler error:", err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Назначение статуса
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// remoteWriteToData преобразует ряды remote write в метрики хранилища
+func remoteWriteToData(request *prompb.WriteRequest) ([]*models.Data, error) {
+	data := make([]*models.Data, 0)
+	for _, series := range request.GetTimeseries() {
+		var name string
+		labels := make(map[string]string, len(series.GetLabels()))
+		for _, label := range series.GetLabels() {
+			if label.GetName() == metricNameLabel {
+				name = label.GetValue()
+				continue
+			}
+			labels[label.GetName()] = label.GetValue()
+		}
+		if name == "" {
+			return nil, fmt.Errorf("time series without %s label", metricNameLabel)
+		}
+
+		for _, sample := range series.GetSamples() {
+			value := sample.GetValue()
+			if math.IsNaN(value) || math.IsInf(value, 0) {
+				continue
+			}
+
+			// Метки копируются, чтобы записи не разделяли одну карту
+			sampleLabels := make(map[string]string, len(labels))
+			for key, labelValue := range labels {
+				sampleLabels[key] = labelValue
+			}
+
+			timestamp := time.UnixMilli(sample.GetTimestamp())
+			metric := &models.Data{
+				Type:      "gauge",
+				Name:      name,
+				Value:     &value,
+				Labels:    sampleLabels,
+				Timestamp: &timestamp,
+			}
+			if err := metric.CheckData(); err != nil {
+				return nil, fmt.Errorf("invalid series %s: %w", name, err)
+			}
+
+			data = append(data, metric)
+		}
+	}
+
+	return data, nil
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+
+	"metrics/internal/server/prompb"
+)
+
+// staleNaN - маркер устаревания ряда Prometheus
+var staleNaN = math.Float64frombits(0x7ff0000000000002)
+
+func TestRemoteWriteToData(t *testing.T) {
+	tests := []struct {
+		name    string
+		request *prompb.WriteRequest
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "labels and timestamps",
+			request: &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{{
+				Labels: []*prompb.Label{
+					{Name: "__name__", Value: "http_requests_total"},
+					{Name: "job", Value: "api"},
+					{Name: "code", Value: "200"},
+				},
+				Samples: []*prompb.Sample{{Value: 10, Timestamp: 1700000000000}, {Value: 12, Timestamp: 1700000015000}},
+			}}},
+			want: []string{
+				`http_requests_total{code="200",job="api"} 10 2023-11-14T22:13:20Z`,
+				`http_requests_total{code="200",job="api"} 12 2023-11-14T22:13:35Z`,
+			},
+		},
+		{
+			name: "series without labels besides name",
+			request: &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{{
+				Labels:  []*prompb.Label{{Name: "__name__", Value: "up"}},
+				Samples: []*prompb.Sample{{Value: 1, Timestamp: 1700000000500}},
+			}}},
+			want: []string{`up 1 2023-11-14T22:13:20.5Z`},
+		},
+		{
+			name: "stale markers are skipped",
+			request: &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{{
+				Labels:  []*prompb.Label{{Name: "__name__", Value: "up"}},
+				Samples: []*prompb.Sample{{Value: staleNaN, Timestamp: 1700000000000}},
+			}}},
+			want: []string{},
+		},
+		{
+			name: "missing name",
+			request: &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{{
+				Labels:  []*prompb.Label{{Name: "job", Value: "api"}},
+				Samples: []*prompb.Sample{{Value: 1, Timestamp: 1700000000000}},
+			}}},
+			wantErr: true,
+		},
+		{
+			name: "empty label name",
+			request: &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{{
+				Labels:  []*prompb.Label{{Name: "__name__", Value: "up"}, {Name: "", Value: "x"}},
+				Samples: []*prompb.Sample{{Value: 1, Timestamp: 1700000000000}},
+			}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := remoteWriteToData(tt.request)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			got := make([]string, 0, len(data))
+			for _, metric := range data {
+				assert.Equal(t, "gauge", metric.Type)
+				got = append(got, metric.SeriesKey()+" "+formatFloat(*metric.Value)+" "+metric.Timestamp.UTC().Format(time.RFC3339Nano))
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestHandler_RemoteWritePost(t *testing.T) {
+	encode := func(request *prompb.WriteRequest) []byte {
+		raw, err := proto.Marshal(request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return snappy.Encode(nil, raw)
+	}
+
+	valid := &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{{
+		Labels:  []*prompb.Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "api"}},
+		Samples: []*prompb.Sample{{Value: 1, Timestamp: 1700000000000}},
+	}}}
+	unnamed := &prompb.WriteRequest{Timeseries: []*prompb.TimeSeries{{
+		Samples: []*prompb.Sample{{Value: 1, Timestamp: 1700000000000}},
+	}}}
+
+	tests := []struct {
+		name string
+		body []byte
+		code int
+	}{
+		{name: "valid", body: encode(valid), code: http.StatusNoContent},
+		{name: "not snappy", body: []byte("garbage"), code: http.StatusBadRequest},
+		{name: "missing name", body: encode(unnamed), code: http.StatusBadRequest},
+		{name: "too large", body: make([]byte, remoteWriteMaxSize+1), code: http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, memStorage := newTestServer("", nil)
+
+			request := httptest.NewRequest(http.MethodPost, "/api/v1/write", bytes.NewReader(tt.body))
+			request.Header.Set("Content-Encoding", "snappy")
+			request.Header.Set("Content-Type", "application/x-protobuf")
+			w := httptest.NewRecorder()
+
+			server.router.ServeHTTP(w, request)
+			assert.Equal(t, tt.code, w.Code)
+
+			if tt.code != http.StatusNoContent {
+				return
+			}
+
+			samples, err := memStorage.ReadRange("up", map[string]string{"job": "api"}, time.Unix(0, 0), time.Now())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if assert.Len(t, samples, 1) {
+				assert.True(t, samples[0].Timestamp.Equal(time.UnixMilli(1700000000000)))
+			}
+		})
+	}
+}
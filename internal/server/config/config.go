@@ -5,23 +5,33 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
-	"net"
 	"os"
 	"strconv"
 	"strings"
 	"time"
+
+	"metrics/pkg/realip"
 )
 
 // ServerConfig - структура конфигурации сервера
 type ServerConfig struct {
-	Host        *Host
-	Logger      *Logger
-	FileStorage *FileStorage
-	DB          *DB
-	Key         string
-	CryptoKey   string
-	ConfigFile  string
-	Net         *Net
+	Host              *Host
+	Logger            *Logger
+	FileStorage       *FileStorage
+	DB                *DB
+	Key               string
+	CryptoKey         string
+	AllowLegacyCrypto bool
+	ConfigFile        string
+	Net               *Net
+	TLS               *TLS
+	AgentKeys         *AgentKeys
+	Tokens            *Tokens
+	Admin             *Admin
+	Enroll            *Enroll
+	StatsD            *StatsD
+	Graphite          *Graphite
+	ReplayWindow      time.Duration
 }
 
 type Host struct {
@@ -47,9 +57,70 @@ type DB struct {
 	Address string
 }
 
+// AgentKeys - структура конфигурации хранилища HMAC ключей агентов
+type AgentKeys struct {
+	File   string
+	FromDB bool
+}
+
+// Tokens - структура конфигурации хранилища токенов API
+type Tokens struct {
+	File   string
+	FromDB bool
+}
+
+// Admin - структура конфигурации служебного слушателя с профилировщиком
+type Admin struct {
+	Address string
+	Token   string
+}
+
+// Enroll - структура конфигурации регистрации агентов и выпуска их сертификатов
+type Enroll struct {
+	CACertFile    string
+	CAKeyFile     string
+	BootstrapFile string
+	CertTTL       time.Duration
+}
+
+// Enabled сообщает, включена ли регистрация агентов
+func (e *Enroll) Enabled() bool {
+	return e.BootstrapFile != ""
+}
+
+// StatsD - структура конфигурации приема метрик StatsD по UDP
+type StatsD struct {
+	Address       string
+	FlushInterval time.Duration
+}
+
+// Graphite - структура конфигурации приема метрик Graphite plaintext по TCP
+type Graphite struct {
+	Address string
+}
+
+// TLS - структура конфигурации TLS слушателей
+type TLS struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+// Enabled сообщает, включен ли TLS. CA клиентов без сертификата сервера тоже включает TLS,
+// чтобы неполная конфигурация завершалась ошибкой при запуске, а не отключала проверку клиентов
+func (t *TLS) Enabled() bool {
+	return t.CertFile != "" || t.KeyFile != "" || t.ClientCAFile != ""
+}
+
+// Net - структура конфигурации доверенных подсетей.
+// Подсети чтения при пустом списке совпадают с подсетями записи
 type Net struct {
-	CIDR          string
-	TrustedSubnet *net.IPNet
+	CIDR           string
+	TrustedSubnets realip.Subnets
+	ReadCIDR       string
+	ReadSubnets    realip.Subnets
+	ProxiesCIDR    string
+	TrustedProxies realip.Proxies
 }
 
 // New - конструктор конфигурации сервера
@@ -61,6 +132,13 @@ func New() (*ServerConfig, error) {
 		FileStorage: &FileStorage{},
 		DB:          &DB{},
 		Net:         &Net{},
+		TLS:         &TLS{},
+		AgentKeys:   &AgentKeys{},
+		Tokens:      &Tokens{},
+		Admin:       &Admin{},
+		Enroll:      &Enroll{},
+		StatsD:      &StatsD{},
+		Graphite:    &Graphite{},
 	}
 
 	// Парсинг флагов
@@ -82,10 +160,8 @@ func New() (*ServerConfig, error) {
 		config.FileStorage.Restore = false
 	}
 
-	if config.Net.CIDR != "" {
-		if err = config.ParseNet(); err != nil {
-			return nil, fmt.Errorf("error parsing CIDR: %w", err)
-		}
+	if err = config.ParseNet(); err != nil {
+		return nil, fmt.Errorf("error parsing CIDR: %w", err)
 	}
 
 	return config, nil
@@ -113,13 +189,52 @@ func (s *ServerConfig) parseFlags() {
 	flag.StringVar(&s.Key, "k", "", "Key")
 
 	// Флаги приватного и публичного ключей
-	flag.StringVar(&s.CryptoKey, "crypto-key", "", "Path to private crypto key file")
+	flag.StringVar(&s.CryptoKey, "crypto-key", "", "Comma separated paths to private crypto key files, the first one decrypts requests without key id")
+	flag.BoolVar(&s.AllowLegacyCrypto, "allow-legacy-crypto", false, "Accept bodies without X-Encryption header encrypted by legacy RSA PKCS#1 v1.5 scheme")
 
 	// Флаг файла конфигурации
 	flag.StringVar(&s.ConfigFile, "config", "", "Config file")
 
 	// Флаг доверенной подсети
-	flag.StringVar(&s.Net.CIDR, "t", "", "Trusted subnet")
+	flag.StringVar(&s.Net.CIDR, "t", "", "Comma separated trusted subnets for ingest routes, IPv4 and IPv6. Example: \"10.0.0.0/8,fd00::/8\"")
+	flag.StringVar(&s.Net.ReadCIDR, "t-read", "", "Comma separated trusted subnets for read routes. Default: same as -t")
+
+	// Флаг доверенных прокси
+	flag.StringVar(&s.Net.ProxiesCIDR, "trusted-proxies", "", "Comma separated CIDRs of proxies allowed to set X-Forwarded-For. Example: \"10.0.0.0/8,::1/128\"")
+	flag.BoolVar(&s.Net.TrustedProxies.RealIP, "trust-real-ip", false, "Accept X-Real-IP from trusted proxies when X-Forwarded-For is absent. Enable only if the proxy overwrites it")
+
+	// Флаги ключей агентов
+	flag.StringVar(&s.AgentKeys.File, "agent-keys", "", "Path to JSON file with per agent HMAC keys")
+	flag.BoolVar(&s.AgentKeys.FromDB, "agent-keys-db", false, "Read per agent HMAC keys from agent_keys database table")
+
+	// Флаги токенов API
+	flag.StringVar(&s.Tokens.File, "api-tokens", "", "Path to JSON file with hashed API bearer tokens and their scopes")
+	flag.BoolVar(&s.Tokens.FromDB, "api-tokens-db", false, "Read hashed API bearer tokens from api_tokens database table")
+
+	// Флаги служебного слушателя
+	flag.StringVar(&s.Admin.Address, "admin-address", "", "Address of admin listener with pprof. Example: \"localhost:30011\". Default: disabled")
+	flag.StringVar(&s.Admin.Token, "admin-token", "", "Bearer token for admin listener, required without API tokens, API tokens with admin scope are accepted too")
+
+	// Флаги регистрации агентов
+	flag.StringVar(&s.Enroll.BootstrapFile, "enroll-tokens", "", "Path to JSON file with one-time agent bootstrap tokens, enables /enroll")
+	flag.StringVar(&s.Enroll.CACertFile, "ca-cert", "", "Path to CA certificate signing agent certificates")
+	flag.StringVar(&s.Enroll.CAKeyFile, "ca-key", "", "Path to CA private key signing agent certificates")
+	flag.DurationVar(&s.Enroll.CertTTL, "cert-ttl", 0, "Validity of issued agent certificates. Default: 720h")
+
+	// Флаги приема StatsD
+	flag.StringVar(&s.StatsD.Address, "statsd-address", "", "UDP address to receive StatsD metrics from trusted subnets -t, which are required when signing keys or tokens are set. Example: \":8125\". Default: disabled")
+	flag.DurationVar(&s.StatsD.FlushInterval, "statsd-flush-interval", 0, "Interval to aggregate StatsD metrics before write. Default: 10s")
+
+	// Флаг приема Graphite
+	flag.StringVar(&s.Graphite.Address, "graphite-address", "", "TCP address to receive Graphite plaintext metrics from trusted subnets -t, which are required when signing keys or tokens are set. Example: \":2003\". Default: disabled")
+
+	// Флаг окна защиты от повторов
+	flag.DurationVar(&s.ReplayWindow, "replay-window", 0, "Accept signed requests within this window and reject reused nonces. Default: 5m when a signing key is set, negative - disabled")
+
+	// Флаги TLS
+	flag.StringVar(&s.TLS.CertFile, "tls-cert", "", "Path to TLS certificate file")
+	flag.StringVar(&s.TLS.KeyFile, "tls-key", "", "Path to TLS private key file")
+	flag.StringVar(&s.TLS.ClientCAFile, "tls-client-ca", "", "Path to CA file to verify agents client certificates")
 
 	_ = flag.Value(s.Host)
 	flag.Var(s.Host, "a", "Host and port on which to listen. Example: \"localhost:8081\" or \":8081\"")
@@ -170,6 +285,14 @@ func (s *ServerConfig) parseEnv() error {
 		s.CryptoKey = privateKey
 	}
 
+	if allowLegacyCrypto := os.Getenv("ALLOW_LEGACY_CRYPTO"); allowLegacyCrypto != "" {
+		allow, err := strconv.ParseBool(allowLegacyCrypto)
+		if err != nil {
+			return fmt.Errorf("invalid ALLOW_LEGACY_CRYPTO to bool conversion: %w", err)
+		}
+		s.AllowLegacyCrypto = allow
+	}
+
 	if config := os.Getenv("CONFIG"); config != "" {
 		s.ConfigFile = config
 	}
@@ -178,10 +301,114 @@ func (s *ServerConfig) parseEnv() error {
 		s.Net.CIDR = trustedSubnet
 	}
 
+	if readSubnet := os.Getenv("TRUSTED_READ_SUBNET"); readSubnet != "" {
+		s.Net.ReadCIDR = readSubnet
+	}
+
+	if trustedProxies := os.Getenv("TRUSTED_PROXIES"); trustedProxies != "" {
+		s.Net.ProxiesCIDR = trustedProxies
+	}
+
+	if trustRealIP := os.Getenv("TRUST_REAL_IP"); trustRealIP != "" {
+		trust, err := strconv.ParseBool(trustRealIP)
+		if err != nil {
+			return fmt.Errorf("invalid TRUST_REAL_IP to bool conversion: %w", err)
+		}
+		s.Net.TrustedProxies.RealIP = trust
+	}
+
 	if grpcPort := os.Getenv("GRPC_PORT"); grpcPort != "" {
 		s.Host.GRPCPort = grpcPort
 	}
 
+	if tlsCert := os.Getenv("TLS_CERT"); tlsCert != "" {
+		s.TLS.CertFile = tlsCert
+	}
+
+	if tlsKey := os.Getenv("TLS_KEY"); tlsKey != "" {
+		s.TLS.KeyFile = tlsKey
+	}
+
+	if tlsClientCA := os.Getenv("TLS_CLIENT_CA"); tlsClientCA != "" {
+		s.TLS.ClientCAFile = tlsClientCA
+	}
+
+	if agentKeys := os.Getenv("AGENT_KEYS"); agentKeys != "" {
+		s.AgentKeys.File = agentKeys
+	}
+
+	if agentKeysDB := os.Getenv("AGENT_KEYS_DB"); agentKeysDB != "" {
+		fromDB, err := strconv.ParseBool(agentKeysDB)
+		if err != nil {
+			return fmt.Errorf("invalid AGENT_KEYS_DB to bool conversion: %w", err)
+		}
+		s.AgentKeys.FromDB = fromDB
+	}
+
+	if apiTokens := os.Getenv("API_TOKENS"); apiTokens != "" {
+		s.Tokens.File = apiTokens
+	}
+
+	if apiTokensDB := os.Getenv("API_TOKENS_DB"); apiTokensDB != "" {
+		fromDB, err := strconv.ParseBool(apiTokensDB)
+		if err != nil {
+			return fmt.Errorf("invalid API_TOKENS_DB to bool conversion: %w", err)
+		}
+		s.Tokens.FromDB = fromDB
+	}
+
+	if adminAddress := os.Getenv("ADMIN_ADDRESS"); adminAddress != "" {
+		s.Admin.Address = adminAddress
+	}
+
+	if adminToken := os.Getenv("ADMIN_TOKEN"); adminToken != "" {
+		s.Admin.Token = adminToken
+	}
+
+	if enrollTokens := os.Getenv("ENROLL_TOKENS"); enrollTokens != "" {
+		s.Enroll.BootstrapFile = enrollTokens
+	}
+
+	if caCert := os.Getenv("CA_CERT"); caCert != "" {
+		s.Enroll.CACertFile = caCert
+	}
+
+	if caKey := os.Getenv("CA_KEY"); caKey != "" {
+		s.Enroll.CAKeyFile = caKey
+	}
+
+	if certTTL := os.Getenv("CERT_TTL"); certTTL != "" {
+		ttl, err := time.ParseDuration(certTTL)
+		if err != nil {
+			return fmt.Errorf("invalid CERT_TTL duration: %w", err)
+		}
+		s.Enroll.CertTTL = ttl
+	}
+
+	if statsdAddress := os.Getenv("STATSD_ADDRESS"); statsdAddress != "" {
+		s.StatsD.Address = statsdAddress
+	}
+
+	if statsdFlush := os.Getenv("STATSD_FLUSH_INTERVAL"); statsdFlush != "" {
+		flushInterval, err := time.ParseDuration(statsdFlush)
+		if err != nil {
+			return fmt.Errorf("invalid STATSD_FLUSH_INTERVAL duration: %w", err)
+		}
+		s.StatsD.FlushInterval = flushInterval
+	}
+
+	if graphiteAddress := os.Getenv("GRAPHITE_ADDRESS"); graphiteAddress != "" {
+		s.Graphite.Address = graphiteAddress
+	}
+
+	if replayWindow := os.Getenv("REPLAY_WINDOW"); replayWindow != "" {
+		window, err := time.ParseDuration(replayWindow)
+		if err != nil {
+			return fmt.Errorf("invalid REPLAY_WINDOW duration: %w", err)
+		}
+		s.ReplayWindow = window
+	}
+
 	return nil
 }
 
@@ -204,13 +431,34 @@ func (s *ServerConfig) initConfigFile() error {
 func (s *ServerConfig) UnmarshalJSON(b []byte) error {
 	var err error
 	var cfg struct {
-		GRPCPort      string `json:"grpc_port"`
-		Restore       bool   `json:"restore"`
-		StoreInterval string `json:"store_interval"`
-		StoreFile     string `json:"store_file"`
-		DatabaseDSN   string `json:"database_dsn"`
-		CryptoKey     string `json:"crypto_key"`
-		TrustedSubnet string `json:"trusted_subnet"`
+		GRPCPort          string `json:"grpc_port"`
+		Restore           bool   `json:"restore"`
+		StoreInterval     string `json:"store_interval"`
+		StoreFile         string `json:"store_file"`
+		DatabaseDSN       string `json:"database_dsn"`
+		CryptoKey         string `json:"crypto_key"`
+		AllowLegacyCrypto bool   `json:"allow_legacy_crypto"`
+		TrustedSubnet     string `json:"trusted_subnet"`
+		ReadSubnet        string `json:"trusted_read_subnet"`
+		TrustedProxies    string `json:"trusted_proxies"`
+		TrustRealIP       bool   `json:"trust_real_ip"`
+		TLSCert           string `json:"tls_cert"`
+		TLSKey            string `json:"tls_key"`
+		TLSClientCA       string `json:"tls_client_ca"`
+		AgentKeys         string `json:"agent_keys"`
+		AgentKeysDB       bool   `json:"agent_keys_db"`
+		APITokens         string `json:"api_tokens"`
+		APITokensDB       bool   `json:"api_tokens_db"`
+		AdminAddress      string `json:"admin_address"`
+		AdminToken        string `json:"admin_token"`
+		EnrollTokens      string `json:"enroll_tokens"`
+		CACert            string `json:"ca_cert"`
+		CAKey             string `json:"ca_key"`
+		CertTTL           string `json:"cert_ttl"`
+		StatsDAddress     string `json:"statsd_address"`
+		StatsDFlush       string `json:"statsd_flush_interval"`
+		Graphite          string `json:"graphite_address"`
+		ReplayWindow      string `json:"replay_window"`
 	}
 
 	if err = json.Unmarshal(b, &cfg); err != nil {
@@ -246,19 +494,123 @@ func (s *ServerConfig) UnmarshalJSON(b []byte) error {
 		s.CryptoKey = cfg.CryptoKey
 	}
 
+	if !s.AllowLegacyCrypto && cfg.AllowLegacyCrypto {
+		s.AllowLegacyCrypto = true
+	}
+
 	if s.Net.CIDR == "" && cfg.TrustedSubnet != "" {
 		s.Net.CIDR = cfg.TrustedSubnet
 	}
 
+	if s.Net.ReadCIDR == "" && cfg.ReadSubnet != "" {
+		s.Net.ReadCIDR = cfg.ReadSubnet
+	}
+
+	if s.Net.ProxiesCIDR == "" && cfg.TrustedProxies != "" {
+		s.Net.ProxiesCIDR = cfg.TrustedProxies
+	}
+
+	if !s.Net.TrustedProxies.RealIP && cfg.TrustRealIP {
+		s.Net.TrustedProxies.RealIP = true
+	}
+
+	if s.TLS.CertFile == "" && cfg.TLSCert != "" {
+		s.TLS.CertFile = cfg.TLSCert
+	}
+
+	if s.TLS.KeyFile == "" && cfg.TLSKey != "" {
+		s.TLS.KeyFile = cfg.TLSKey
+	}
+
+	if s.TLS.ClientCAFile == "" && cfg.TLSClientCA != "" {
+		s.TLS.ClientCAFile = cfg.TLSClientCA
+	}
+
+	if s.AgentKeys.File == "" && cfg.AgentKeys != "" {
+		s.AgentKeys.File = cfg.AgentKeys
+	}
+
+	if !s.AgentKeys.FromDB && cfg.AgentKeysDB {
+		s.AgentKeys.FromDB = true
+	}
+
+	if s.Tokens.File == "" && cfg.APITokens != "" {
+		s.Tokens.File = cfg.APITokens
+	}
+
+	if !s.Tokens.FromDB && cfg.APITokensDB {
+		s.Tokens.FromDB = true
+	}
+
+	if s.Admin.Address == "" && cfg.AdminAddress != "" {
+		s.Admin.Address = cfg.AdminAddress
+	}
+
+	if s.Admin.Token == "" && cfg.AdminToken != "" {
+		s.Admin.Token = cfg.AdminToken
+	}
+
+	if s.Enroll.BootstrapFile == "" && cfg.EnrollTokens != "" {
+		s.Enroll.BootstrapFile = cfg.EnrollTokens
+	}
+
+	if s.Enroll.CACertFile == "" && cfg.CACert != "" {
+		s.Enroll.CACertFile = cfg.CACert
+	}
+
+	if s.Enroll.CAKeyFile == "" && cfg.CAKey != "" {
+		s.Enroll.CAKeyFile = cfg.CAKey
+	}
+
+	if s.Enroll.CertTTL == 0 && cfg.CertTTL != "" {
+		s.Enroll.CertTTL, err = time.ParseDuration(cfg.CertTTL)
+		if err != nil {
+			return fmt.Errorf("error parsing cert_ttl: %w", err)
+		}
+	}
+
+	if s.StatsD.Address == "" && cfg.StatsDAddress != "" {
+		s.StatsD.Address = cfg.StatsDAddress
+	}
+
+	if s.StatsD.FlushInterval == 0 && cfg.StatsDFlush != "" {
+		s.StatsD.FlushInterval, err = time.ParseDuration(cfg.StatsDFlush)
+		if err != nil {
+			return fmt.Errorf("error parsing statsd_flush_interval: %w", err)
+		}
+	}
+
+	if s.Graphite.Address == "" && cfg.Graphite != "" {
+		s.Graphite.Address = cfg.Graphite
+	}
+
+	if s.ReplayWindow == 0 && cfg.ReplayWindow != "" {
+		s.ReplayWindow, err = time.ParseDuration(cfg.ReplayWindow)
+		if err != nil {
+			return fmt.Errorf("error parsing replay_window: %w", err)
+		}
+	}
+
 	return nil
 }
 
+// ParseNet разбирает списки доверенных подсетей и прокси
 func (s *ServerConfig) ParseNet() error {
-	_, ipv4Net, err := net.ParseCIDR(s.Net.CIDR)
-	if err != nil {
-		return fmt.Errorf("invalid CIDR: %w", err)
+	var err error
+	if s.Net.TrustedSubnets, err = realip.ParseSubnets(s.Net.CIDR); err != nil {
+		return fmt.Errorf("invalid trusted subnet: %w", err)
+	}
+
+	s.Net.ReadSubnets = s.Net.TrustedSubnets
+	if s.Net.ReadCIDR != "" {
+		if s.Net.ReadSubnets, err = realip.ParseSubnets(s.Net.ReadCIDR); err != nil {
+			return fmt.Errorf("invalid trusted read subnet: %w", err)
+		}
+	}
+
+	if s.Net.TrustedProxies.Subnets, err = realip.ParseSubnets(s.Net.ProxiesCIDR); err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
 	}
-	s.Net.TrustedSubnet = ipv4Net
 
 	return nil
 }
@@ -0,0 +1,128 @@
+package enroll
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"metrics/internal/server/tokens"
+)
+
+// bootstrapToken - запись токена первичной регистрации в файле.
+// Токен хранится в виде SHA-256 хеша и привязан к идентификатору агента
+type bootstrapToken struct {
+	AgentID string `json:"agent_id"`
+	Hash    string `json:"hash"`
+	Used    bool   `json:"used"`
+}
+
+// BootstrapStore - файл одноразовых токенов первичной регистрации.
+// Использованный токен помечается в файле, поэтому повторная регистрация невозможна и после перезапуска
+type BootstrapStore struct {
+	path string
+
+	mu sync.Mutex
+}
+
+// NewBootstrapStore - конструктор файла токенов первичной регистрации, проверяет формат файла
+func NewBootstrapStore(path string) (*BootstrapStore, error) {
+	store := &BootstrapStore{path: path}
+	if _, err := store.read(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// Lookup возвращает идентификатор агента по неиспользованному токену, не помечая его
+func (b *BootstrapStore) Lookup(token string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	records, err := b.read()
+	if err != nil {
+		return "", err
+	}
+
+	i := find(records, token)
+	if i < 0 {
+		return "", ErrInvalidToken
+	}
+
+	return records[i].AgentID, nil
+}
+
+// Consume помечает токен использованным и возвращает идентификатор агента
+func (b *BootstrapStore) Consume(token string) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	records, err := b.read()
+	if err != nil {
+		return "", err
+	}
+
+	i := find(records, token)
+	if i < 0 {
+		return "", ErrInvalidToken
+	}
+
+	records[i].Used = true
+	if err = b.write(records); err != nil {
+		return "", err
+	}
+
+	return records[i].AgentID, nil
+}
+
+// find возвращает индекс записи неиспользованного токена или -1
+func find(records []bootstrapToken, token string) int {
+	hash := tokens.Hash(token)
+	for i := range records {
+		if records[i].Hash == hash && !records[i].Used {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// read читает файл токенов
+func (b *BootstrapStore) read() ([]bootstrapToken, error) {
+	fileData, err := os.ReadFile(b.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed read bootstrap tokens file: %w", err)
+	}
+
+	var records []bootstrapToken
+	if err = json.Unmarshal(fileData, &records); err != nil {
+		return nil, fmt.Errorf("failed unmarshal bootstrap tokens file: %w", err)
+	}
+
+	for _, record := range records {
+		if record.AgentID == "" || record.Hash == "" {
+			return nil, fmt.Errorf("bootstrap tokens file: empty agent_id or hash")
+		}
+	}
+
+	return records, nil
+}
+
+// write записывает файл токенов через временный файл
+func (b *BootstrapStore) write(records []bootstrapToken) error {
+	fileData, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed marshal bootstrap tokens: %w", err)
+	}
+
+	tmp := b.path + ".tmp"
+	if err = os.WriteFile(tmp, fileData, 0600); err != nil {
+		return fmt.Errorf("failed write bootstrap tokens file: %w", err)
+	}
+	if err = os.Rename(tmp, b.path); err != nil {
+		return fmt.Errorf("failed replace bootstrap tokens file: %w", err)
+	}
+
+	return nil
+}
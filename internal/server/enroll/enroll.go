@@ -0,0 +1,238 @@
+// Модуль enroll выпускает сертификаты агентов: агент предъявляет одноразовый токен первичной регистрации
+// и запрос на сертификат, сервер подписывает сертификат своим CA и выдает HMAC ключ агента
+package enroll
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+
+	"metrics/internal/models"
+	"metrics/internal/server/agents"
+)
+
+const (
+	// secretSize - длина HMAC ключа агента в байтах
+	secretSize = 32
+
+	// defaultTTL - срок действия сертификата по умолчанию
+	defaultTTL = 30 * 24 * time.Hour
+
+	// clockSkew - запас начала действия сертификата на расхождение часов
+	clockSkew = time.Minute
+)
+
+var (
+	// ErrInvalidToken - токен первичной регистрации неизвестен или уже использован
+	ErrInvalidToken = errors.New("invalid or used bootstrap token")
+
+	// ErrInvalidCSR - запрос на сертификат не разобран или подпись не верна
+	ErrInvalidCSR = errors.New("invalid certificate request")
+)
+
+// Issuer - выпуск сертификатов агентов
+type Issuer struct {
+	caCert    *x509.Certificate
+	caKey     crypto.Signer
+	caPEM     []byte
+	ttl       time.Duration
+	bootstrap *BootstrapStore
+	keys      agents.Writer
+
+	// Регистрации выполняются по очереди, чтобы один токен не выдал два ключа агента
+	mu sync.Mutex
+}
+
+// New - конструктор выпуска сертификатов.
+// caCertFile и caKeyFile - CA для подписи, bootstrapFile - файл токенов первичной регистрации,
+// ttl - срок действия сертификата (по умолчанию 30 дней), keys - хранилище, в которое записываются HMAC ключи агентов
+func New(caCertFile string, caKeyFile string, bootstrapFile string, ttl time.Duration, keys agents.Writer) (*Issuer, error) {
+	if caCertFile == "" || caKeyFile == "" {
+		return nil, fmt.Errorf("agent enrollment requires CA certificate and key")
+	}
+
+	caPEM, err := os.ReadFile(caCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed read CA certificate: %w", err)
+	}
+
+	block, _ := pem.Decode(caPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no pem block found in %s", caCertFile)
+	}
+
+	caCert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed parse CA certificate: %w", err)
+	}
+	if !caCert.IsCA {
+		return nil, fmt.Errorf("%s is not a CA certificate", caCertFile)
+	}
+
+	caKey, err := readSigner(caKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	bootstrap, err := NewBootstrapStore(bootstrapFile)
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+
+	return &Issuer{
+		caCert:    caCert,
+		caKey:     caKey,
+		caPEM:     caPEM,
+		ttl:       ttl,
+		bootstrap: bootstrap,
+		keys:      keys,
+	}, nil
+}
+
+// Enroll регистрирует агента по токену первичной регистрации:
+// подписывает сертификат и выдает новый HMAC ключ агента.
+// Токен помечается использованным только после выпуска сертификата и записи ключа,
+// поэтому ошибка выпуска не сжигает токен
+func (i *Issuer) Enroll(token string, csrPEM string) (*models.EnrollResponse, error) {
+	csr, err := parseCSR(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	agentID, err := i.bootstrap.Lookup(token)
+	if err != nil {
+		return nil, err
+	}
+
+	// Генерация HMAC ключа агента
+	secret := make([]byte, secretSize)
+	if _, err = rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed generate agent key: %w", err)
+	}
+
+	response, err := i.issue(agentID, csr)
+	if err != nil {
+		return nil, err
+	}
+
+	response.Key = hex.EncodeToString(secret)
+	if err = i.keys.SetAgentSecret(agentID, response.Key); err != nil {
+		return nil, fmt.Errorf("failed store agent key: %w", err)
+	}
+
+	// Токен сжигается последним, при ошибке записи агент повторит регистрацию и получит новый ключ
+	if _, err = i.bootstrap.Consume(token); err != nil {
+		return nil, err
+	}
+
+	return response, nil
+}
+
+// CACert возвращает CA, которым подписаны сертификаты агентов
+func (i *Issuer) CACert() *x509.Certificate {
+	return i.caCert
+}
+
+// Renew выпускает новый сертификат агенту, предъявившему действующий сертификат
+// и подписавшему запрос своим HMAC ключом
+func (i *Issuer) Renew(agentID string, csrPEM string) (*models.EnrollResponse, error) {
+	csr, err := parseCSR(csrPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	return i.issue(agentID, csr)
+}
+
+// issue подписывает сертификат клиента с идентификатором агента в CommonName
+func (i *Issuer) issue(agentID string, csr *x509.CertificateRequest) (*models.EnrollResponse, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, fmt.Errorf("failed generate serial number: %w", err)
+	}
+
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: agentID},
+		NotBefore:    now.Add(-clockSkew),
+		NotAfter:     now.Add(i.ttl),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, i.caCert, csr.PublicKey, i.caKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed sign agent certificate: %w", err)
+	}
+
+	return &models.EnrollResponse{
+		AgentID:     agentID,
+		Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		CA:          string(i.caPEM),
+		NotAfter:    template.NotAfter,
+	}, nil
+}
+
+// parseCSR разбирает запрос на сертификат и проверяет его подпись
+func parseCSR(csrPEM string) (*x509.CertificateRequest, error) {
+	block, _ := pem.Decode([]byte(csrPEM))
+	if block == nil || block.Type != "CERTIFICATE REQUEST" {
+		return nil, fmt.Errorf("%w: no pem block", ErrInvalidCSR)
+	}
+
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCSR, err.Error())
+	}
+	if err = csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCSR, err.Error())
+	}
+
+	return csr, nil
+}
+
+// readSigner читает приватный ключ CA в формате PKCS#1 или PKCS#8
+func readSigner(path string) (crypto.Signer, error) {
+	keyPEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed read CA key: %w", err)
+	}
+
+	block, _ := pem.Decode(keyPEM)
+	if block == nil {
+		return nil, fmt.Errorf("no pem block found in %s", path)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed parse CA key: %w", err)
+	}
+
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("CA key in %s can't sign", path)
+	}
+
+	return signer, nil
+}
@@ -0,0 +1,215 @@
+package enroll
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"metrics/internal/server/agents"
+	"metrics/internal/server/tokens"
+)
+
+// keyWriter - хранилище ключей агентов для тестов, при err запись ключа завершается ошибкой
+type keyWriter struct {
+	secrets map[string]string
+	err     error
+}
+
+func (k *keyWriter) AgentSecret(agentID string) (string, error) {
+	secret, ok := k.secrets[agentID]
+	if !ok {
+		return "", agents.ErrUnknownAgent
+	}
+
+	return secret, nil
+}
+
+func (k *keyWriter) SetAgentSecret(agentID string, secret string) error {
+	if k.err != nil {
+		return k.err
+	}
+	k.secrets[agentID] = secret
+
+	return nil
+}
+
+// newTestIssuer создает CA и файл токенов первичной регистрации во временном каталоге
+func newTestIssuer(t *testing.T, bootstrap map[string]string) (*Issuer, *keyWriter, *x509.CertPool) {
+	t.Helper()
+	dir := t.TempDir()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "metrics test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caCertFile := filepath.Join(dir, "ca.crt")
+	caKeyFile := filepath.Join(dir, "ca.key")
+	writeFile(t, caCertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	writeFile(t, caKeyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}))
+
+	records := make([]bootstrapToken, 0, len(bootstrap))
+	for token, agentID := range bootstrap {
+		records = append(records, bootstrapToken{AgentID: agentID, Hash: tokens.Hash(token)})
+	}
+	bootstrapData, err := json.Marshal(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bootstrapFile := filepath.Join(dir, "bootstrap.json")
+	writeFile(t, bootstrapFile, bootstrapData)
+
+	keys := &keyWriter{secrets: make(map[string]string)}
+	issuer, err := New(caCertFile, caKeyFile, bootstrapFile, time.Hour, keys)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(issuer.caPEM)
+
+	return issuer, keys, roots
+}
+
+// writeFile записывает файл теста
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newCSR создает запрос на сертификат с новым ключом агента
+func newCSR(t *testing.T) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+// verifyClientCert проверяет, что сертификат подписан CA для аутентификации клиента агента
+func verifyClientCert(t *testing.T, certPEM string, roots *x509.CertPool, agentID string) {
+	t.Helper()
+
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		t.Fatal("no pem block in issued certificate")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	assert.NoError(t, err)
+	assert.Equal(t, agentID, cert.Subject.CommonName)
+}
+
+func TestIssuer_Enroll(t *testing.T) {
+	issuer, keys, roots := newTestIssuer(t, map[string]string{"token-a": "host-a"})
+
+	response, err := issuer.Enroll("token-a", newCSR(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "host-a", response.AgentID)
+	assert.Equal(t, keys.secrets["host-a"], response.Key)
+	assert.Len(t, response.Key, 2*secretSize)
+	verifyClientCert(t, response.Certificate, roots, "host-a")
+
+	// Токен одноразовый, в том числе после перечитывания файла
+	_, err = issuer.Enroll("token-a", newCSR(t))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	store, err := NewBootstrapStore(issuer.bootstrap.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = store.Lookup("token-a")
+	assert.ErrorIs(t, err, ErrInvalidToken)
+
+	// Неизвестный токен отклоняется
+	_, err = issuer.Enroll("token-b", newCSR(t))
+	assert.ErrorIs(t, err, ErrInvalidToken)
+}
+
+func TestIssuer_EnrollFailureKeepsToken(t *testing.T) {
+	issuer, keys, _ := newTestIssuer(t, map[string]string{"token-a": "host-a"})
+
+	// Ошибочный запрос на сертификат не сжигает токен
+	_, err := issuer.Enroll("token-a", "not a csr")
+	assert.ErrorIs(t, err, ErrInvalidCSR)
+
+	// Ошибка записи ключа агента не сжигает токен
+	keys.err = errors.New("storage unavailable")
+	_, err = issuer.Enroll("token-a", newCSR(t))
+	assert.Error(t, err)
+	assert.Empty(t, keys.secrets)
+
+	keys.err = nil
+	response, err := issuer.Enroll("token-a", newCSR(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "host-a", response.AgentID)
+}
+
+func TestIssuer_Renew(t *testing.T) {
+	issuer, keys, roots := newTestIssuer(t, map[string]string{"token-a": "host-a"})
+
+	enrolled, err := issuer.Enroll("token-a", newCSR(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Продление выпускает новый сертификат без смены ключа агента
+	renewed, err := issuer.Renew("host-a", newCSR(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, renewed.Key)
+	assert.Equal(t, enrolled.Key, keys.secrets["host-a"])
+	assert.NotEqual(t, enrolled.Certificate, renewed.Certificate)
+	verifyClientCert(t, renewed.Certificate, roots, "host-a")
+
+	_, err = issuer.Renew("host-a", "not a csr")
+	assert.ErrorIs(t, err, ErrInvalidCSR)
+}
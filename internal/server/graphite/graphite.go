@@ -0,0 +1,232 @@
+// Модуль graphite принимает метрики в формате Graphite plaintext по TCP
+// и записывает каждую строку как обновление gauge.
+// Протокол не поддерживает аутентификацию, поэтому доступ ограничивается доверенными подсетями
+package graphite
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"metrics/internal/models"
+	"metrics/pkg/realip"
+)
+
+const (
+	// idleTimeout - время ожидания данных от клиента до закрытия соединения
+	idleTimeout = 5 * time.Minute
+
+	// maxBatchSize - максимальное количество метрик в одной записи в хранилище
+	maxBatchSize = 1000
+
+	// maxLineSize - максимальная длина строки, соединение с более длинной строкой закрывается
+	maxLineSize = 16 << 10
+)
+
+// dataUpdater - интерфейс записи метрик в хранилище
+type dataUpdater interface {
+	UpdateBatch([]*models.Data) error
+}
+
+// Server - структура инстанса Graphite слушателя
+type Server struct {
+	address string
+	subnets realip.Subnets
+	updater dataUpdater
+	logger  *logrus.Logger
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+}
+
+// NewServer - конструктор Graphite слушателя на адресе address.
+// При непустом subnets соединения принимаются только с адресов этих подсетей
+func NewServer(address string, subnets realip.Subnets, updater dataUpdater, logger *logrus.Logger) *Server {
+	return &Server{
+		address: address,
+		subnets: subnets,
+		updater: updater,
+		logger:  logger,
+		conns:   make(map[net.Conn]struct{}),
+	}
+}
+
+// Run принимает соединения до отмены контекста, при остановке закрывает открытые соединения
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return fmt.Errorf("graphite could not listen on %v: %w", s.address, err)
+	}
+
+	s.logger.Infof("Starting Graphite listener on %v", listener.Addr())
+
+	s.serve(ctx, listener)
+
+	return nil
+}
+
+// serve принимает соединения слушателя до отмены контекста
+func (s *Server) serve(ctx context.Context, listener net.Listener) {
+	// Закрытие слушателя и соединений прерывает прием при остановке
+	go func() {
+		<-ctx.Done()
+		if err := listener.Close(); err != nil {
+			s.logger.Errorf("failed close Graphite listener: %s", err.Error())
+		}
+
+		s.mu.Lock()
+		for conn := range s.conns {
+			if err := conn.Close(); err != nil {
+				s.logger.Errorf("failed close Graphite connection: %s", err.Error())
+			}
+		}
+		s.conns = nil
+		s.mu.Unlock()
+	}()
+
+	wg := &sync.WaitGroup{}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				break
+			}
+			s.logger.Errorf("failed accept Graphite connection: %s", err.Error())
+			continue
+		}
+
+		// Проверка подсети адреса соединения, заголовков прокси у протокола нет
+		if !s.trusted(conn) {
+			s.logger.Errorf("Graphite connection from %s is not trusted", conn.RemoteAddr())
+			if err = conn.Close(); err != nil {
+				s.logger.Errorf("failed close Graphite connection: %s", err.Error())
+			}
+			continue
+		}
+
+		if !s.track(conn) {
+			s.untrack(conn)
+			break
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer s.untrack(conn)
+			s.handleConn(conn)
+		}()
+	}
+
+	wg.Wait()
+}
+
+// trusted сообщает, входит ли адрес соединения в доверенные подсети
+func (s *Server) trusted(conn net.Conn) bool {
+	if len(s.subnets) == 0 {
+		return true
+	}
+
+	host, _, err := net.SplitHostPort(conn.RemoteAddr().String())
+	if err != nil {
+		return false
+	}
+
+	return s.subnets.Contains(net.ParseIP(host))
+}
+
+// track запоминает соединение для закрытия при остановке.
+// Возвращает false, если слушатель уже остановлен
+func (s *Server) track(conn net.Conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conns == nil {
+		return false
+	}
+	s.conns[conn] = struct{}{}
+
+	return true
+}
+
+// untrack закрывает соединение и убирает его из открытых
+func (s *Server) untrack(conn net.Conn) {
+	s.mu.Lock()
+	delete(s.conns, conn)
+	s.mu.Unlock()
+
+	if err := conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		s.logger.Errorf("failed close Graphite connection: %s", err.Error())
+	}
+}
+
+// readerFunc - функция чтения как io.Reader
+type readerFunc func([]byte) (int, error)
+
+// Read вызывает функцию чтения
+func (f readerFunc) Read(p []byte) (int, error) {
+	return f(p)
+}
+
+// handleConn читает строки соединения. Строки, пришедшие вместе, записываются одним батчем:
+// батч сбрасывается перед ожиданием новых данных из сети или при достижении maxBatchSize
+func (s *Server) handleConn(conn net.Conn) {
+	batch := make([]*models.Data, 0)
+
+	// Сканер читает соединение, только когда в его буфере не осталось целых строк
+	scanner := bufio.NewScanner(readerFunc(func(p []byte) (int, error) {
+		batch = s.flush(batch)
+
+		if err := conn.SetReadDeadline(time.Now().Add(idleTimeout)); err != nil {
+			s.logger.Errorf("failed set Graphite read deadline: %s", err.Error())
+		}
+
+		return conn.Read(p)
+	}))
+	scanner.Buffer(make([]byte, 0, 4096), maxLineSize)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		data, err := parseLine(line)
+		if err != nil {
+			s.logger.Errorf("skip Graphite line %q from %s: %s", line, conn.RemoteAddr(), err.Error())
+			continue
+		}
+
+		if batch = append(batch, data); len(batch) >= maxBatchSize {
+			batch = s.flush(batch)
+		}
+	}
+	s.flush(batch)
+
+	// Конец данных, остановка и простой соединения - штатное закрытие
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) && !errors.Is(err, os.ErrDeadlineExceeded) {
+		s.logger.Errorf("failed read Graphite connection %s: %s", conn.RemoteAddr(), err.Error())
+	}
+}
+
+// flush записывает батч в хранилище и возвращает пустой батч
+func (s *Server) flush(batch []*models.Data) []*models.Data {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	if err := s.updater.UpdateBatch(batch); err != nil {
+		s.logger.Errorf("failed write Graphite metrics: %s", err.Error())
+	} else {
+		s.logger.Debugf("Graphite wrote %d metrics", len(batch))
+	}
+
+	return batch[:0:0]
+}
@@ -0,0 +1,187 @@
+package graphite
+
+import (
+	"context"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"metrics/internal/models"
+	"metrics/internal/storage/memory"
+	"metrics/pkg/realip"
+)
+
+// notifyUpdater - хранилище в памяти, сообщающее размер каждого записанного батча
+type notifyUpdater struct {
+	*memory.MemoryStorage
+	batches chan int
+}
+
+func (n *notifyUpdater) UpdateBatch(batch []*models.Data) error {
+	err := n.MemoryStorage.UpdateBatch(batch)
+	n.batches <- len(batch)
+
+	return err
+}
+
+// startServer запускает слушатель на свободном локальном порту и возвращает его адрес
+func startServer(t *testing.T, subnets realip.Subnets) (string, *notifyUpdater) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	updater := &notifyUpdater{MemoryStorage: memory.NewMemoryStorage(), batches: make(chan int, 16)}
+	server := NewServer(listener.Addr().String(), subnets, updater, logger)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		server.serve(ctx, listener)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+
+	return listener.Addr().String(), updater
+}
+
+// dial подключается к слушателю и отправляет данные одной записью
+func dial(t *testing.T, address string, payload string) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	if _, err = conn.Write([]byte(payload)); err != nil {
+		t.Fatal(err)
+	}
+
+	return conn
+}
+
+// waitBatch ждет записи батча в хранилище и возвращает его размер
+func waitBatch(t *testing.T, batches chan int) int {
+	t.Helper()
+
+	select {
+	case size := <-batches:
+		return size
+	case <-time.After(5 * time.Second):
+		t.Fatal("batch was not written")
+	}
+
+	return 0
+}
+
+// waitClosed ждет закрытия соединения сервером
+func waitClosed(t *testing.T, conn net.Conn) {
+	t.Helper()
+
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	// Закрытие с непрочитанными данными приходит сбросом соединения вместо EOF
+	_, err := conn.Read(make([]byte, 1))
+	if assert.Error(t, err) {
+		assert.NotErrorIs(t, err, os.ErrDeadlineExceeded)
+	}
+}
+
+func TestServer_Batch(t *testing.T) {
+	address, updater := startServer(t, nil)
+
+	// Строки одной записи попадают в один батч, ошибочная строка пропускается
+	dial(t, address, "cpu;host=web1 10 1700000000\nbroken\nmem;host=web1 20 1700000000\n")
+	assert.Equal(t, 2, waitBatch(t, updater.batches))
+
+	data, err := updater.Read("cpu", map[string]string{"host": "web1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotNil(t, data) {
+		assert.Equal(t, 10.0, *data.Value)
+	}
+}
+
+func TestServer_Backfill(t *testing.T) {
+	address, updater := startServer(t, nil)
+
+	dial(t, address, "cpu 10 1700000060\n")
+	waitBatch(t, updater.batches)
+
+	// Точка старше последней попадает только в историю
+	dial(t, address, "cpu 5 1700000000\n")
+	waitBatch(t, updater.batches)
+
+	data, err := updater.Read("cpu", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotNil(t, data) {
+		assert.Equal(t, 10.0, *data.Value)
+	}
+
+	samples, err := updater.ReadRange("cpu", nil, time.Unix(1699999999, 0), time.Unix(1700000061, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, samples, 2) {
+		assert.Equal(t, 5.0, *samples[0].Value)
+		assert.Equal(t, 10.0, *samples[1].Value)
+	}
+
+	// Более новая точка обновляет последнее значение
+	dial(t, address, "cpu 12 1700000120\n")
+	waitBatch(t, updater.batches)
+
+	data, err = updater.Read("cpu", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotNil(t, data) {
+		assert.Equal(t, 12.0, *data.Value)
+	}
+}
+
+func TestServer_LineTooLong(t *testing.T) {
+	address, updater := startServer(t, nil)
+
+	// Строки до слишком длинной записываются, затем соединение закрывается
+	conn := dial(t, address, "cpu 1\n"+strings.Repeat("a", maxLineSize+1))
+	assert.Equal(t, 1, waitBatch(t, updater.batches))
+	waitClosed(t, conn)
+}
+
+func TestServer_UntrustedSubnet(t *testing.T) {
+	subnets, err := realip.ParseSubnets("10.0.0.0/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, updater := startServer(t, subnets)
+
+	conn := dial(t, address, "cpu 1\n")
+	waitClosed(t, conn)
+
+	all, err := updater.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, all)
+}
@@ -0,0 +1,70 @@
+package graphite
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"metrics/internal/models"
+)
+
+// parseLine разбирает строку вида path value [timestamp] в обновление gauge.
+// Теги Graphite в пути вида path;tag=value становятся метками.
+// Время в секундах Unix сохраняется в точке истории, -1 или его отсутствие означает время приема
+func parseLine(line string) (*models.Data, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return nil, fmt.Errorf("expected \"path value timestamp\", got %d fields", len(fields))
+	}
+
+	name, labels, err := parsePath(fields[0])
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value: %w", err)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("unsupported value %s", fields[1])
+	}
+
+	data := &models.Data{Type: "gauge", Name: name, Value: &value, Labels: labels}
+	if len(fields) == 3 && fields[2] != "-1" {
+		seconds, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil || seconds < 0 {
+			return nil, fmt.Errorf("invalid timestamp %q", fields[2])
+		}
+
+		timestamp := time.Unix(0, int64(seconds*float64(time.Second)))
+		data.Timestamp = &timestamp
+	}
+
+	return data, nil
+}
+
+// parsePath разбирает путь метрики и теги вида path;tag=value;tag2=value2
+func parsePath(path string) (string, map[string]string, error) {
+	parts := strings.Split(path, ";")
+	if parts[0] == "" {
+		return "", nil, fmt.Errorf("missing metric path")
+	}
+
+	if len(parts) == 1 {
+		return parts[0], nil, nil
+	}
+
+	labels := make(map[string]string, len(parts)-1)
+	for _, tag := range parts[1:] {
+		key, value, ok := strings.Cut(tag, "=")
+		if !ok || key == "" || value == "" {
+			return "", nil, fmt.Errorf("invalid tag %q", tag)
+		}
+		labels[key] = value
+	}
+
+	return parts[0], labels, nil
+}
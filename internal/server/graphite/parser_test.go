@@ -0,0 +1,61 @@
+package graphite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLine(t *testing.T) {
+	timestamp := time.Unix(1700000000, 0)
+	fractional := time.Unix(1700000000, 500000000)
+
+	tests := []struct {
+		name      string
+		line      string
+		wantName  string
+		labels    map[string]string
+		value     float64
+		timestamp *time.Time
+		wantErr   bool
+	}{
+		{name: "value and timestamp", line: "servers.web1.cpu 12.5 1700000000", wantName: "servers.web1.cpu", value: 12.5, timestamp: &timestamp},
+		{name: "without timestamp", line: "servers.web1.cpu 3", wantName: "servers.web1.cpu", value: 3},
+		{name: "receive time marker", line: "servers.web1.cpu 3 -1", wantName: "servers.web1.cpu", value: 3},
+		{name: "fractional timestamp", line: "cpu 1 1700000000.5", wantName: "cpu", value: 1, timestamp: &fractional},
+		{name: "tags", line: "cpu;host=web1;dc=eu 7 1700000000", wantName: "cpu", labels: map[string]string{"host": "web1", "dc": "eu"}, value: 7, timestamp: &timestamp},
+		{name: "missing value", line: "cpu", wantErr: true},
+		{name: "extra fields", line: "cpu 1 1700000000 x", wantErr: true},
+		{name: "invalid value", line: "cpu high", wantErr: true},
+		{name: "NaN value", line: "cpu NaN", wantErr: true},
+		{name: "infinite value", line: "cpu +Inf", wantErr: true},
+		{name: "invalid timestamp", line: "cpu 1 yesterday", wantErr: true},
+		{name: "negative timestamp", line: "cpu 1 -5", wantErr: true},
+		{name: "empty path", line: ";host=web1 1", wantErr: true},
+		{name: "tag without value", line: "cpu;host= 1", wantErr: true},
+		{name: "tag without separator", line: "cpu;host 1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := parseLine(tt.line)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			assert.Equal(t, "gauge", data.Type)
+			assert.Equal(t, tt.wantName, data.Name)
+			assert.Equal(t, tt.labels, data.Labels)
+			assert.Equal(t, tt.value, *data.Value)
+			if tt.timestamp == nil {
+				assert.Nil(t, data.Timestamp)
+			} else if assert.NotNil(t, data.Timestamp) {
+				assert.True(t, tt.timestamp.Equal(*data.Timestamp), "got %s", data.Timestamp)
+			}
+		})
+	}
+}
@@ -3,24 +3,31 @@ package grpc
 import (
 	"context"
 	"crypto/hmac"
-	"crypto/rand"
 	"crypto/rsa"
+	"crypto/tls"
 	"crypto/x509"
 	"encoding/hex"
-	"encoding/pem"
-	"net"
-	"os"
+	"strings"
 	"time"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 
 	"github.com/sirupsen/logrus"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 
+	"metrics/internal/models"
+	"metrics/internal/server/otlp"
 	pb "metrics/internal/server/proto"
+	"metrics/internal/server/tokens"
 	"metrics/internal/server/utils"
+	"metrics/pkg/envelope"
+	"metrics/pkg/realip"
+	"metrics/pkg/replay"
 )
 
 // GRPCServer - структура инстанса gRPC сервера
@@ -31,18 +38,68 @@ type GRPCServer struct {
 }
 
 type auth struct {
-	cryptoKey     string
+	keys          keyProvider
 	hashKey       string
-	trustedSubnet *net.IPNet
+	agentKeys     agentKeyStore
+	tokens        tokenStore
+	replay        replayGuard
+	ingestSubnets realip.Subnets
+	readSubnets   realip.Subnets
+
+	// Доверенные прокси, которым разрешено передавать адрес клиента в заголовках
+	trustedProxies realip.Proxies
 }
 
-// NewServer создает инстанс gRPC сервера
-func NewServer(cryptoKey string, hashKey string, trustedSubnet *net.IPNet, storageCommands *StorageCommands, logger *logrus.Logger) *GRPCServer {
+// agentIDKey - ключ контекста запроса с идентификатором агента, подтвержденным его подписью или сертификатом
+type agentIDKey struct{}
+
+// payloader - интерфейс запроса, подписываемого по его телу
+type payloader interface {
+	Payload() ([]byte, error)
+}
+
+// signature - подпись запроса: хеш, время отправки и nonce
+type signature struct {
+	hash      string
+	timestamp string
+	nonce     string
+}
+
+// keyProvider - интерфейс поставщика приватного ключа для дешифровки запросов
+type keyProvider interface {
+	Key(string) (*rsa.PrivateKey, error)
+	AllowLegacy() bool
+}
+
+// agentKeyStore - интерфейс хранилища HMAC ключей агентов
+type agentKeyStore interface {
+	AgentSecret(string) (string, error)
+}
+
+// tokenStore - интерфейс хранилища токенов API
+type tokenStore interface {
+	TokenScope(string) (tokens.Scope, error)
+}
+
+// replayGuard - интерфейс проверки повторов подписанных запросов
+type replayGuard interface {
+	Check(string, string) error
+}
+
+// NewServer создает инстанс gRPC сервера, при keys == nil дешифровка запросов отключена,
+// при agentKeys == nil запросы подписываются общим ключом hashKey, при apiTokens == nil токены не проверяются,
+// при replay == nil повторы не проверяются. Преобразование OTLP converter общее с HTTP сервером
+func NewServer(keys keyProvider, hashKey string, agentKeys agentKeyStore, apiTokens tokenStore, replay replayGuard, ingestSubnets realip.Subnets, readSubnets realip.Subnets, trustedProxies realip.Proxies, tlsConfig *tls.Config, storageCommands *StorageCommands, converter *otlp.Converter, logger *logrus.Logger) *GRPCServer {
 	instance := &GRPCServer{
 		auth: &auth{
-			cryptoKey:     cryptoKey,
-			hashKey:       hashKey,
-			trustedSubnet: trustedSubnet,
+			keys:           keys,
+			hashKey:        hashKey,
+			agentKeys:      agentKeys,
+			tokens:         apiTokens,
+			replay:         replay,
+			ingestSubnets:  ingestSubnets,
+			readSubnets:    readSubnets,
+			trustedProxies: trustedProxies,
 		},
 		logger: logger,
 	}
@@ -51,15 +108,37 @@ func NewServer(cryptoKey string, hashKey string, trustedSubnet *net.IPNet, stora
 	interceptors := []grpc.UnaryServerInterceptor{
 		instance.withLogger,
 		instance.withTrustedSubnet,
+		instance.withToken,
+		instance.withClientCert,
 		instance.withHash,
 		instance.withDecrypt,
 	}
 
+	// Определение перехватчиков стримов
+	streamInterceptors := []grpc.StreamServerInterceptor{
+		instance.withStreamLogger,
+		instance.withStreamTrustedSubnet,
+		instance.withStreamToken,
+		instance.withStreamClientCert,
+		instance.withStreamHash,
+		instance.withStreamDecrypt,
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(interceptors...),
+		grpc.ChainStreamInterceptor(streamInterceptors...),
+	}
+
+	// Шифрование соединения при наличии конфигурации TLS
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
 	//Регистрация инстанса gRPC с перехватчиками
-	instance.Server = grpc.NewServer(
-		grpc.ChainUnaryInterceptor(interceptors...))
+	instance.Server = grpc.NewServer(opts...)
 
 	pb.RegisterHandlersServer(instance.Server, NewHandler(storageCommands))
+	colmetricspb.RegisterMetricsServiceServer(instance.Server, NewMetricsService(storageCommands, converter))
 
 	return instance
 }
@@ -85,28 +164,8 @@ func (g *GRPCServer) withLogger(ctx context.Context, req any,
 // withTrustedSubnet - перехватчик проверяет подсеть в метаданных
 func (g *GRPCServer) withTrustedSubnet(ctx context.Context, req any,
 	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
-	// Проверка наличия записи подсети
-	if g.auth.trustedSubnet != nil {
-		g.logger.Infof("start checking request subNet")
-
-		// Чтение метаданных
-		meta, ok := metadata.FromIncomingContext(ctx)
-		if !ok {
-			return nil, status.Errorf(codes.Internal, "can't extract metadata from request")
-		}
-		header, ok := meta["x-real-ip"]
-		if !ok {
-			return nil, status.Errorf(codes.Unauthenticated, "can't extract hash header from request")
-		}
-		requestIP := net.ParseIP(header[0])
-		if requestIP == nil {
-			return nil, status.Errorf(codes.InvalidArgument, "error parsing X-Real-IP header")
-		}
-
-		// Проверка
-		if !g.auth.trustedSubnet.Contains(requestIP) {
-			return nil, status.Errorf(codes.PermissionDenied, "IP address is not trusted")
-		}
+	if err = g.checkTrustedSubnet(ctx, info.FullMethod); err != nil {
+		return nil, err
 	}
 
 	return handler(ctx, req)
@@ -115,8 +174,29 @@ func (g *GRPCServer) withTrustedSubnet(ctx context.Context, req any,
 // withHash - перехватчик проверяет наличие хеша в метаданных и сверяет с телом запроса
 func (g *GRPCServer) withHash(ctx context.Context, req any,
 	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
-	// Проверка наличия флага ключа
-	if g.auth.hashKey != "" {
+	// Подписываются только запросы передачи метрик
+	var request payloader
+	switch typed := req.(type) {
+	case *pb.PostUpdatesRequest:
+		request = typed
+	case *colmetricspb.ExportMetricsServiceRequest:
+		// Клиенты OTLP не подписывают запросы, они аутентифицируются токеном или сертификатом
+		if err = g.checkIntegration(ctx); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	default:
+		return handler(ctx, req)
+	}
+
+	// Выбор ключа подписи агента
+	hashKey, err := g.hashKeyFor(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Проверка наличия ключа
+	if hashKey != "" {
 		g.logger.Infof("start checking gRPC request hash")
 
 		// Чтеные метаданных
@@ -124,23 +204,26 @@ func (g *GRPCServer) withHash(ctx context.Context, req any,
 		if !ok {
 			return nil, status.Errorf(codes.Internal, "can't extract metadata from request")
 		}
-		var requestHeader []byte
 		header, ok := meta["hashsha256"]
 		if !ok {
-			return nil, status.Errorf(codes.Unauthenticated, "can't extract hash header from request")
+			return nil, status.Errorf(codes.Unauthenticated, "missing HashSHA256 metadata: server requires signed requests")
 		}
-		requestHeader, err = hex.DecodeString(header[0])
-		if err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "can't decode hash header from request")
+
+		sig := signature{hash: header[0]}
+		if values := meta.Get(replay.TimestampHeader); len(values) > 0 {
+			sig.timestamp = values[0]
+		}
+		if values := meta.Get(replay.NonceHeader); len(values) > 0 {
+			sig.nonce = values[0]
 		}
 
-		// Чтение тела запроса
-		body := req.(*pb.PostUpdatesRequest).Metrics
+		if err = g.checkHash(request, sig, hashKey); err != nil {
+			return nil, err
+		}
 
-		// Вычисление и валидация хеша
-		hash := utils.GetHash(g.auth.hashKey, body)
-		if !hmac.Equal(hash, requestHeader) {
-			return nil, status.Errorf(codes.PermissionDenied, "hash does not match")
+		// Запрос подписан ключом агента: его идентификатор подтвержден
+		if agentID := instanceFrom(ctx); g.auth.agentKeys != nil && agentID != "" {
+			ctx = context.WithValue(ctx, agentIDKey{}, agentID)
 		}
 	}
 
@@ -150,56 +233,275 @@ func (g *GRPCServer) withHash(ctx context.Context, req any,
 // withDecrypt - перехватчик дешифровки тела запроса
 func (g *GRPCServer) withDecrypt(ctx context.Context, req any,
 	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
-	// Проверка наличия флага приватного ключа
-	if g.auth.cryptoKey != "" {
-		g.logger.Infof("start decrypt gRPC request")
+	// Шифруются только запросы передачи метрик
+	request, ok := req.(*pb.PostUpdatesRequest)
+	if !ok {
+		return handler(ctx, req)
+	}
 
-		// Чтение pem файла
-		var privatePEM []byte
-		privatePEM, err = os.ReadFile(g.auth.cryptoKey)
-		if err != nil {
-			return nil, status.Errorf(codes.NotFound, "unable to read private key: %v", err)
+	if err = g.decrypt(ctx, request); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// isIngest сообщает, является ли метод методом записи метрик
+func isIngest(method string) bool {
+	return method == pb.Handlers_PostUpdates_FullMethodName || method == pb.Handlers_StreamUpdates_FullMethodName ||
+		method == otlpExportMethod
+}
+
+// withToken - перехватчик проверяет bearer токен и его область доступа
+func (g *GRPCServer) withToken(ctx context.Context, req any,
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	if err = g.checkToken(ctx, info.FullMethod); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// checkToken проверяет bearer токен из метаданных: для методов записи нужна область write, иначе - read.
+// Без хранилища токенов аутентификация отключена
+func (g *GRPCServer) checkToken(ctx context.Context, method string) error {
+	if g.auth.tokens == nil {
+		return nil
+	}
+
+	scope := tokens.ScopeRead
+	if isIngest(method) {
+		scope = tokens.ScopeWrite
+	}
+
+	var header string
+	if meta, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := meta.Get("authorization"); len(values) > 0 {
+			header = values[0]
 		}
+	}
+
+	token, ok := tokens.ParseBearer(header)
+	if !ok {
+		return status.Errorf(codes.Unauthenticated, "missing bearer token")
+	}
 
-		// Поиск блока приватного ключа
-		privateKeyBlock, _ := pem.Decode(privatePEM)
+	tokenScope, err := g.auth.tokens.TokenScope(tokens.Hash(token))
+	if err != nil {
+		return status.Errorf(codes.Unauthenticated, "token rejected: %s", err.Error())
+	}
+
+	if !tokenScope.Allows(scope) {
+		return status.Errorf(codes.PermissionDenied, "token scope %s does not allow %s", tokenScope, scope)
+	}
+
+	return nil
+}
+
+// checkIntegration проверяет аутентификацию стороннего клиента при настроенной подписи запросов:
+// нужен токен, уже проверенный checkToken, или проверенный сертификат клиента
+func (g *GRPCServer) checkIntegration(ctx context.Context) error {
+	if (g.auth.hashKey == "" && g.auth.agentKeys == nil) || g.auth.tokens != nil {
+		return nil
+	}
+
+	if peerCert(ctx) != nil {
+		return nil
+	}
 
-		// Парсинг приватного ключа
-		var privateKey *rsa.PrivateKey
-		privateKey, err = x509.ParsePKCS1PrivateKey(privateKeyBlock.Bytes)
+	return status.Errorf(codes.Unauthenticated, "write token or client certificate required")
+}
+
+// withClientCert - перехватчик привязывает идентификатор инстанса к сертификату клиента
+func (g *GRPCServer) withClientCert(ctx context.Context, req any,
+	info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+	if ctx, err = g.checkInstance(ctx); err != nil {
+		return nil, err
+	}
+
+	return handler(ctx, req)
+}
+
+// checkInstance сверяет идентификатор инстанса из метаданных с CommonName проверенного сертификата клиента.
+// Совпавший идентификатор подтвержден сертификатом и добавляется в контекст
+func (g *GRPCServer) checkInstance(ctx context.Context) (context.Context, error) {
+	instance := instanceFrom(ctx)
+	cert := peerCert(ctx)
+	if instance == "" || cert == nil {
+		return ctx, nil
+	}
+
+	if instance != cert.Subject.CommonName {
+		g.logger.Errorf("instance %s does not match client certificate %s", instance, cert.Subject.CommonName)
+		return nil, status.Errorf(codes.PermissionDenied, "%s does not match client certificate", models.InstanceHeader)
+	}
+
+	return context.WithValue(ctx, agentIDKey{}, instance), nil
+}
+
+// peerCert возвращает проверенный сертификат клиента соединения или nil
+func peerCert(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return info.State.VerifiedChains[0][0]
+}
+
+// checkTrustedSubnet проверяет подсеть адреса клиента: для методов записи - подсети записи, иначе - подсети чтения.
+// Адрес берется из соединения, метаданные прокси учитываются только от доверенных прокси
+func (g *GRPCServer) checkTrustedSubnet(ctx context.Context, method string) error {
+	subnets := g.auth.readSubnets
+	if isIngest(method) {
+		subnets = g.auth.ingestSubnets
+	}
+
+	// Проверка наличия записи подсети
+	if len(subnets) > 0 {
+		g.logger.Infof("start checking request subNet")
+
+		// Адрес соединения
+		p, ok := peer.FromContext(ctx)
+		if !ok || p.Addr == nil {
+			return status.Errorf(codes.Internal, "can't extract peer address from request")
+		}
+
+		// Чтение метаданных прокси
+		var realIP, forwardedFor string
+		if meta, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := meta.Get(realip.RealIPHeader); len(values) > 0 {
+				realIP = values[0]
+			}
+			forwardedFor = strings.Join(meta.Get(realip.ForwardedForHeader), ",")
+		}
+
+		requestIP, err := realip.Resolve(p.Addr.String(), realIP, forwardedFor, g.auth.trustedProxies)
 		if err != nil {
-			return nil, status.Errorf(codes.InvalidArgument, "unable to parse private key: %v", err)
+			return status.Errorf(codes.InvalidArgument, "error resolving client IP: %s", err.Error())
 		}
-		if err = privateKey.Validate(); err != nil {
-			return nil, status.Errorf(codes.Unauthenticated, "invalid private key: %v", err)
+
+		// Проверка
+		if !subnets.Contains(requestIP) {
+			return status.Errorf(codes.PermissionDenied, "IP address is not trusted")
 		}
+	}
 
-		// Установка длины частей публичного ключа
-		blockLen := privateKey.PublicKey.Size()
+	return nil
+}
 
-		// Чтение метрик
-		body := req.(*pb.PostUpdatesRequest).Metrics
+// hashKeyFor возвращает ключ подписи агента из хранилища ключей агентов по идентификатору из метаданных,
+// для запросов без идентификатора агента - общий ключ
+func (g *GRPCServer) hashKeyFor(ctx context.Context) (string, error) {
+	if g.auth.agentKeys == nil {
+		return g.auth.hashKey, nil
+	}
 
-		// Дешифровка тела запроса частями
-		var decryptedBytes []byte
-		for start := 0; start < len(body); start += blockLen {
-			end := start + blockLen
-			if start+blockLen > len(body) {
-				end = len(body)
-			}
+	agentID := instanceFrom(ctx)
 
-			var decryptedChunk []byte
-			decryptedChunk, err = rsa.DecryptPKCS1v15(rand.Reader, privateKey, body[start:end])
-			if err != nil {
-				return nil, status.Errorf(codes.Internal, "unable to decrypt request: %v", err)
+	// При хранилище ключей агентов запрос без идентификатора принимается только с общим ключом
+	if agentID == "" {
+		if g.auth.hashKey == "" {
+			return "", status.Errorf(codes.Unauthenticated, "missing %s metadata: server requires per agent signatures", models.InstanceHeader)
+		}
+		return g.auth.hashKey, nil
+	}
+
+	hashKey, err := g.auth.agentKeys.AgentSecret(agentID)
+	if err != nil {
+		return "", status.Errorf(codes.PermissionDenied, "agent key error: %v", err)
+	}
+
+	// Пустой ключ агента не отключает проверку подписи
+	if hashKey == "" {
+		return "", status.Errorf(codes.PermissionDenied, "empty key of agent %s", agentID)
+	}
+
+	return hashKey, nil
+}
+
+// instanceFrom возвращает идентификатор инстанса агента из метаданных запроса
+func instanceFrom(ctx context.Context) string {
+	if meta, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := meta.Get(models.InstanceHeader); len(values) > 0 {
+			return values[0]
+		}
+	}
+
+	return ""
+}
+
+// checkHash сверяет хеш подписи с телом запроса, временем отправки и nonce, затем проверяет повтор запроса
+func (g *GRPCServer) checkHash(request payloader, sig signature, hashKey string) error {
+	requestHeader, err := hex.DecodeString(sig.hash)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid HashSHA256: expected hex encoded HMAC-SHA256")
+	}
+
+	// Чтение тела запроса
+	body, err := request.Payload()
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "invalid request body: %v", err)
+	}
+
+	// Вычисление и валидация хеша
+	hash := utils.GetHash(hashKey, replay.Payload(sig.timestamp, sig.nonce, body))
+	if !hmac.Equal(hash, requestHeader) {
+		return status.Errorf(codes.PermissionDenied, "hash does not match: request body or signing key differs")
+	}
+
+	// Проверка повтора запроса
+	if g.auth.replay != nil {
+		if err = g.auth.replay.Check(sig.timestamp, sig.nonce); err != nil {
+			return status.Errorf(codes.Unauthenticated, "replay check failed: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// decrypt дешифрует тело запроса при наличии флага приватного ключа
+func (g *GRPCServer) decrypt(ctx context.Context, request *pb.PostUpdatesRequest) error {
+	// Проверка наличия приватного ключа
+	if g.auth.keys != nil {
+		g.logger.Infof("start decrypt gRPC request")
+
+		// Типизированный батч передается открыто, при включенном шифровании принимаются только зашифрованные метрики
+		if len(request.Batch) > 0 {
+			return status.Errorf(codes.InvalidArgument, "typed batch is not encrypted, send encrypted metrics")
+		}
+
+		// Чтение версии схемы шифрования и идентификатора ключа из метаданных
+		var version, keyID string
+		if meta, ok := metadata.FromIncomingContext(ctx); ok {
+			if values := meta.Get(envelope.Header); len(values) > 0 {
+				version = values[0]
+			}
+			if values := meta.Get(envelope.KeyIDHeader); len(values) > 0 {
+				keyID = values[0]
 			}
+		}
 
-			decryptedBytes = append(decryptedBytes, decryptedChunk...)
+		// Выбор ключа по идентификатору
+		privateKey, err := g.auth.keys.Key(keyID)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "unable to select private key: %v", err)
+		}
+
+		// Дешифровка тела запроса по версии схемы шифрования
+		decryptedBytes, err := envelope.Decrypt(version, privateKey, request.Metrics, g.auth.keys.AllowLegacy())
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "unable to decrypt request: %v", err)
 		}
 
 		// Подмена тела запроса
-		req.(*pb.PostUpdatesRequest).Metrics = decryptedBytes
+		request.Metrics = decryptedBytes
 	}
 
-	return handler(ctx, req)
+	return nil
 }
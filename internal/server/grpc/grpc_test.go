@@ -0,0 +1,162 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"metrics/internal/models"
+	"metrics/internal/server/otlp"
+	"metrics/internal/server/tokens"
+	"metrics/internal/storage/memory"
+	"metrics/pkg/realip"
+)
+
+// apiTokens - хранилище токенов для тестов, ключ - хеш токена
+type apiTokens map[string]tokens.Scope
+
+func (a apiTokens) TokenScope(tokenHash string) (tokens.Scope, error) {
+	scope, ok := a[tokenHash]
+	if !ok {
+		return "", tokens.ErrUnknownToken
+	}
+
+	return scope, nil
+}
+
+// newTestConn запускает gRPC сервер на bufconn над отдельным хранилищем в памяти
+// и возвращает соединение клиента к нему с дополнительными опциями клиента
+func newTestConn(t *testing.T, hashKey string, opts ...grpc.DialOption) (*grpc.ClientConn, *memory.MemoryStorage) {
+	t.Helper()
+
+	return newTokenTestConn(t, hashKey, nil, opts...)
+}
+
+// newTokenTestConn запускает gRPC сервер с хранилищем токенов и возвращает соединение клиента к нему
+func newTokenTestConn(t *testing.T, hashKey string, apiTokens tokenStore, opts ...grpc.DialOption) (*grpc.ClientConn, *memory.MemoryStorage) {
+	t.Helper()
+
+	memStorage := memory.NewMemoryStorage()
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	server := NewServer(nil, hashKey, nil, apiTokens, nil, nil, nil, realip.Proxies{}, nil, NewStorageService(memStorage, memStorage), otlp.NewConverter(), logger)
+
+	listener := bufconn.Listen(1 << 20)
+	go func() {
+		_ = server.Server.Serve(listener)
+	}()
+	t.Cleanup(server.Server.Stop)
+
+	opts = append(opts,
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.NewClient("passthrough:///bufnet", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+
+	return conn, memStorage
+}
+
+// otlpGaugeRequest собирает запрос OTLP с одним gauge
+func otlpGaugeRequest(name string, value float64) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: []*metricspb.Metric{{
+			Name: name,
+			Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{{
+				Value: &metricspb.NumberDataPoint_AsDouble{AsDouble: value},
+			}}}},
+		}}}},
+	}}}
+}
+
+func TestGRPCServer_OTLPExportAuth(t *testing.T) {
+	request := otlpGaugeRequest("temperature", 21.5)
+
+	// При подписи запросов без токенов и сертификатов клиенты OTLP не аутентифицированы
+	conn, memStorage := newTestConn(t, "secret")
+	_, err := colmetricspb.NewMetricsServiceClient(conn).Export(context.Background(), request)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	all, err := memStorage.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, all)
+
+	// Запрос с токеном записи принимается без подписи
+	conn, memStorage = newTokenTestConn(t, "secret", apiTokens{
+		tokens.Hash("write-token"): tokens.ScopeWrite,
+		tokens.Hash("read-token"):  tokens.ScopeRead,
+	})
+	client := colmetricspb.NewMetricsServiceClient(conn)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer read-token")
+	_, err = client.Export(ctx, request)
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	ctx = metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer write-token")
+	_, err = client.Export(ctx, request)
+	assert.NoError(t, err)
+
+	data, err := memStorage.Read("temperature", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotNil(t, data) {
+		assert.Equal(t, 21.5, *data.Value)
+	}
+}
+
+func TestGRPCServer_CheckInstance(t *testing.T) {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	server := &GRPCServer{logger: logger}
+
+	// withCert собирает контекст соединения с проверенным сертификатом клиента
+	withCert := func(commonName string, instance string) context.Context {
+		ctx := peer.NewContext(context.Background(), &peer.Peer{AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: commonName}}}},
+		}}})
+		return metadata.NewIncomingContext(ctx, metadata.Pairs(models.InstanceHeader, instance))
+	}
+
+	// Идентификатор инстанса должен совпадать с CommonName сертификата
+	_, err := server.checkInstance(withCert("host-a", "host-b"))
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	ctx, err := server.checkInstance(withCert("host-a", "host-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "host-a", ctx.Value(agentIDKey{}))
+
+	// Без сертификата идентификатор не подтвержден
+	ctx, err = server.checkInstance(metadata.NewIncomingContext(context.Background(), metadata.Pairs(models.InstanceHeader, "host-b")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, ctx.Value(agentIDKey{}))
+}
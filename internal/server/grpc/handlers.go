@@ -3,15 +3,27 @@ package grpc
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
 
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	"metrics/internal/models"
 	pb "metrics/internal/server/proto"
 )
 
+const (
+	// Размер страницы списка метрик по умолчанию и максимальный
+	defaultPageSize = 100
+	maxPageSize     = 1000
+)
+
 // Handler - структура gRPC хендлера
 type Handler struct {
 	pb.UnimplementedHandlersServer
@@ -26,7 +38,8 @@ type StorageCommands struct {
 
 // dataReader - интерфейс хендлера для чтения из базы
 type dataReader interface {
-	Read(string) (*models.Data, error)
+	Read(string, map[string]string) (*models.Data, error)
+	ReadAll() ([]*models.Data, error)
 }
 
 // dataUpdater - интерфейс хендлера для записи в базу
@@ -52,26 +65,194 @@ func NewStorageService(dataReader dataReader, dataUpdater dataUpdater) *StorageC
 
 // PostUpdates - хендлер запросав передачи метрик батчами
 func (h *Handler) PostUpdates(ctx context.Context, request *pb.PostUpdatesRequest) (*pb.PostUpdatesResponse, error) {
-	var err error
 	var response pb.PostUpdatesResponse
 
+	if err := h.updateBatch(ctx, request); err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+// StreamUpdates - хендлер стрима передачи метрик.
+// Метрики обрабатываются последовательно: следующая читается из стрима только после записи предыдущей,
+// поэтому медленное хранилище притормаживает агента средствами flow control gRPC.
+// При ошибке количество уже записанных метрик передается в трейлере, чтобы агент повторил только остальные
+func (h *Handler) StreamUpdates(stream pb.Handlers_StreamUpdatesServer) error {
+	var summary pb.Summary
+
+	for {
+		metric, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			return stream.SendAndClose(&summary)
+		}
+		if err == nil {
+			err = h.updateMetric(stream.Context(), metric)
+		}
+		if err != nil {
+			stream.SetTrailer(metadata.Pairs(pb.AcceptedTrailer, strconv.FormatInt(summary.Metrics, 10)))
+			return err
+		}
+
+		summary.Metrics++
+	}
+}
+
+// updateMetric записывает метрику стрима в хранилище
+func (h *Handler) updateMetric(ctx context.Context, metric *pb.Metric) error {
+	data, err := metric.ToData()
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "metric error: %s", err.Error())
+	}
+
+	return h.update(ctx, []*models.Data{data})
+}
+
+// updateBatch разбирает батч запроса и записывает его в хранилище
+func (h *Handler) updateBatch(ctx context.Context, request *pb.PostUpdatesRequest) error {
+	var err error
+
+	// Устаревший и типизированный форматы не смешиваются в одном запросе
+	if len(request.Metrics) > 0 && len(request.Batch) > 0 {
+		return status.Errorf(codes.InvalidArgument, "legacy and typed metrics can't be sent together")
+	}
+
+	// Метрики в устаревшем формате JSON
 	storageData := []*models.Data{}
-	if err = json.Unmarshal(request.Metrics, &storageData); err != nil {
-		return nil, fmt.Errorf("UpdatesPostGRPC failed unmarshall request body: %w", err)
+	if len(request.Metrics) > 0 {
+		if err = json.Unmarshal(request.Metrics, &storageData); err != nil {
+			return fmt.Errorf("UpdatesPostGRPC failed unmarshall request body: %w", err)
+		}
 	}
 
-	// Проверка невалидных значений
+	// Типизированные метрики
+	for _, metric := range request.Batch {
+		var data *models.Data
+		if data, err = metric.ToData(); err != nil {
+			return status.Errorf(codes.InvalidArgument, "metric error: %s", err.Error())
+		}
+		storageData = append(storageData, data)
+	}
+
+	// Проверка пустых батчей
+	if len(storageData) == 0 {
+		return status.Errorf(codes.InvalidArgument, "empty batch data")
+	}
+
+	return h.update(ctx, storageData)
+}
+
+// update проверяет метрики, отмечает их инстансом агента и записывает в хранилище
+func (h *Handler) update(ctx context.Context, storageData []*models.Data) error {
 	for _, data := range storageData {
 		// Проверка невалидных значений
-		if err = data.CheckData(); err != nil {
-			return nil, status.Errorf(codes.Internal, "data values error: %s", err.Error())
+		if err := data.CheckData(); err != nil {
+			return status.Errorf(codes.Internal, "data values error: %s", err.Error())
 		}
+
+		// Отметка инстанса агента
+		markInstance(ctx, data)
 	}
 
 	// Обновление или сохранение новой записи в хранилище
-	if err = h.storageCommands.UpdateBatch(storageData); err != nil {
-		return nil, status.Errorf(codes.Internal, "updates handler error: %s", err.Error())
+	if err := h.storageCommands.UpdateBatch(storageData); err != nil {
+		return status.Errorf(codes.Internal, "updates handler error: %s", err.Error())
 	}
 
-	return &response, nil
+	return nil
+}
+
+// GetMetric - хендлер получения метрики по названию и меткам
+func (h *Handler) GetMetric(ctx context.Context, request *pb.GetMetricRequest) (*pb.GetMetricResponse, error) {
+	if request.Id == "" {
+		return nil, status.Errorf(codes.InvalidArgument, "empty metric id")
+	}
+
+	// Получение данных записи
+	data, err := h.storageCommands.Read(request.Id, request.Labels)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "read handler error: %s", err.Error())
+	}
+
+	// Проверка пустой даты
+	if data == nil {
+		return nil, status.Errorf(codes.NotFound, "metric %s not found", request.Id)
+	}
+
+	metric, err := pb.NewMetric(data)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "metric error: %s", err.Error())
+	}
+
+	return &pb.GetMetricResponse{Metric: metric}, nil
+}
+
+// ListMetrics - хендлер получения списка метрик с фильтром по префиксу названия и постраничным выводом.
+// Токен страницы - идентификатор последней серии предыдущей страницы
+func (h *Handler) ListMetrics(ctx context.Context, request *pb.ListMetricsRequest) (*pb.ListMetricsResponse, error) {
+	pageSize := int(request.PageSize)
+	switch {
+	case pageSize < 0:
+		return nil, status.Errorf(codes.InvalidArgument, "negative page size")
+	case pageSize == 0:
+		pageSize = defaultPageSize
+	case pageSize > maxPageSize:
+		pageSize = maxPageSize
+	}
+
+	// Получение всех записей
+	data, err := h.storageCommands.ReadAll()
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "read handler error: %s", err.Error())
+	}
+
+	// Фильтр по префиксу и токену страницы
+	series := make(map[string]*models.Data, len(data))
+	keys := make([]string, 0, len(data))
+	for _, metric := range data {
+		if !strings.HasPrefix(metric.Name, request.Prefix) {
+			continue
+		}
+
+		key := metric.SeriesKey()
+		if request.PageToken != "" && key <= request.PageToken {
+			continue
+		}
+
+		series[key] = metric
+		keys = append(keys, key)
+	}
+
+	// Сортировка для стабильного постраничного вывода
+	sort.Strings(keys)
+
+	response := &pb.ListMetricsResponse{}
+	if len(keys) > pageSize {
+		keys = keys[:pageSize]
+		response.NextPageToken = keys[len(keys)-1]
+	}
+
+	response.Metrics = make([]*pb.Metric, 0, len(keys))
+	for _, key := range keys {
+		var metric *pb.Metric
+		if metric, err = pb.NewMetric(series[key]); err != nil {
+			return nil, status.Errorf(codes.Internal, "metric error: %s", err.Error())
+		}
+		response.Metrics = append(response.Metrics, metric)
+	}
+
+	return response, nil
+}
+
+// markInstance отмечает метрику инстансом агента. Идентификатор, подтвержденный подписью или сертификатом агента,
+// заменяет метку из тела запроса, идентификатор из метаданных без подписи агента заполняет только отсутствующую метку
+func markInstance(ctx context.Context, data *models.Data) {
+	if agentID, ok := ctx.Value(agentIDKey{}).(string); ok {
+		data.SetLabel(models.InstanceLabel, agentID)
+		return
+	}
+
+	if instance := instanceFrom(ctx); instance != "" {
+		data.SetDefaultLabel(models.InstanceLabel, instance)
+	}
 }
@@ -0,0 +1,334 @@
+package grpc
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	agentgrpc "metrics/internal/agent/grpc"
+	"metrics/internal/models"
+	pb "metrics/internal/server/proto"
+	"metrics/internal/server/utils"
+	"metrics/pkg"
+	"metrics/pkg/replay"
+)
+
+// gauge собирает типизированную метрику gauge
+func gauge(name string, value float64) *pb.Metric {
+	return &pb.Metric{Id: name, Value: &pb.Metric_Gauge{Gauge: value}}
+}
+
+// counter собирает типизированную метрику counter
+func counter(name string, delta int64) *pb.Metric {
+	return &pb.Metric{Id: name, Value: &pb.Metric_Counter{Counter: delta}}
+}
+
+// signMessage подписывает метрику стрима ключом, временем отправки и nonce
+func signMessage(t *testing.T, key string, metric *pb.Metric, nonce string) {
+	t.Helper()
+
+	payload, err := metric.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metric.Timestamp = replay.Timestamp(time.Now())
+	metric.Nonce = nonce
+	metric.Hash = hex.EncodeToString(utils.GetHash(key, replay.Payload(metric.Timestamp, metric.Nonce, payload)))
+}
+
+// flakyHandlers - сервер, стрим которого записывает одну метрику и обрывается, а PostUpdates принимает батчи
+type flakyHandlers struct {
+	pb.UnimplementedHandlersServer
+	batches chan []*pb.Metric
+}
+
+func (f *flakyHandlers) StreamUpdates(stream pb.Handlers_StreamUpdatesServer) error {
+	if _, err := stream.Recv(); err != nil {
+		return err
+	}
+	stream.SetTrailer(metadata.Pairs(pb.AcceptedTrailer, "1"))
+
+	return status.Errorf(codes.Unavailable, "storage is unavailable")
+}
+
+func (f *flakyHandlers) PostUpdates(_ context.Context, request *pb.PostUpdatesRequest) (*pb.PostUpdatesResponse, error) {
+	f.batches <- request.Batch
+
+	return &pb.PostUpdatesResponse{}, nil
+}
+
+func TestHandler_PostUpdates(t *testing.T) {
+	conn, memStorage := newTestConn(t, "")
+	client := pb.NewHandlersClient(conn)
+
+	tests := []struct {
+		name     string
+		request  *pb.PostUpdatesRequest
+		wantCode codes.Code
+	}{
+		{name: "typed batch", request: &pb.PostUpdatesRequest{Batch: []*pb.Metric{gauge("alloc", 1.5), counter("polls", 2)}}},
+		{name: "legacy json", request: &pb.PostUpdatesRequest{Metrics: []byte(`[{"id":"frees","type":"gauge","value":3}]`)}},
+		{name: "mixed formats", request: &pb.PostUpdatesRequest{Metrics: []byte(`[]`), Batch: []*pb.Metric{gauge("alloc", 1)}}, wantCode: codes.InvalidArgument},
+		{name: "empty batch", request: &pb.PostUpdatesRequest{}, wantCode: codes.InvalidArgument},
+		{name: "metric without value", request: &pb.PostUpdatesRequest{Batch: []*pb.Metric{{Id: "alloc"}}}, wantCode: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := client.PostUpdates(context.Background(), tt.request)
+			assert.Equal(t, tt.wantCode, status.Code(err))
+		})
+	}
+
+	all, err := memStorage.ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, all, 3)
+}
+
+func TestHandler_StreamUpdates(t *testing.T) {
+	conn, memStorage := newTestConn(t, "")
+	client := pb.NewHandlersClient(conn)
+
+	stream, err := client.StreamUpdates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, metric := range []*pb.Metric{gauge("alloc", 1), counter("polls", 1), counter("polls", 4)} {
+		if err = stream.Send(metric); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Итог сервера приходит только после закрытия стрима
+	summary, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(3), summary.Metrics)
+
+	data, err := memStorage.Read("polls", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotNil(t, data) {
+		assert.Equal(t, int64(5), *data.Delta)
+	}
+}
+
+func TestHandler_StreamUpdatesInvalidMetric(t *testing.T) {
+	conn, _ := newTestConn(t, "")
+	client := pb.NewHandlersClient(conn)
+
+	stream, err := client.StreamUpdates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = stream.Send(gauge("alloc", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err = stream.Send(&pb.Metric{Id: "polls"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ошибка метрики завершает стрим со статусом сервера и количеством записанных метрик в трейлере
+	_, err = stream.CloseAndRecv()
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Equal(t, []string{"1"}, stream.Trailer().Get(pb.AcceptedTrailer))
+}
+
+func TestHandler_StreamUpdatesHash(t *testing.T) {
+	conn, memStorage := newTestConn(t, "secret")
+	client := pb.NewHandlersClient(conn)
+
+	// Подписанные сообщения принимаются
+	stream, err := client.StreamUpdates(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, nonce := range []string{"a", "b"} {
+		metric := counter("polls", int64(i+1))
+		signMessage(t, "secret", metric, nonce)
+		if err = stream.Send(metric); err != nil {
+			t.Fatal(err)
+		}
+	}
+	summary, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(2), summary.Metrics)
+
+	tests := []struct {
+		name     string
+		sign     func(metric *pb.Metric)
+		wantCode codes.Code
+	}{
+		{name: "unsigned", sign: func(*pb.Metric) {}, wantCode: codes.Unauthenticated},
+		{name: "other key", sign: func(metric *pb.Metric) { signMessage(t, "other", metric, "c") }, wantCode: codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stream, err := client.StreamUpdates(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			metric := counter("polls", 100)
+			tt.sign(metric)
+			if err = stream.Send(metric); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = stream.CloseAndRecv()
+			assert.Equal(t, tt.wantCode, status.Code(err))
+		})
+	}
+
+	data, err := memStorage.Read("polls", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotNil(t, data) {
+		assert.Equal(t, int64(3), *data.Delta)
+	}
+}
+
+func TestHandler_AgentClientStream(t *testing.T) {
+	conn, memStorage := newTestConn(t, "secret", agentgrpc.NewInterceptors("secret", "")...)
+	client := agentgrpc.New(pb.NewHandlersClient(conn), 1, time.Millisecond, true)
+
+	value := 2.5
+	ctx := context.WithValue(context.Background(), pkg.ContextKey{}, 0)
+	for range 2 {
+		if err := client.PostMetrics(ctx, []*models.Data{{Name: "alloc", Type: "gauge", Value: &value}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Закрытие стрима сверяет количество переданных метрик с итогом сервера
+	assert.NoError(t, client.Close())
+
+	data, err := memStorage.Read("alloc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotNil(t, data) {
+		assert.Equal(t, value, *data.Value)
+	}
+}
+
+func TestHandler_AgentClientStreamResend(t *testing.T) {
+	handlers := &flakyHandlers{batches: make(chan []*pb.Metric, 1)}
+	server := grpc.NewServer()
+	pb.RegisterHandlersServer(server, handlers)
+
+	listener := bufconn.Listen(1 << 20)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = conn.Close()
+	})
+	client := agentgrpc.New(pb.NewHandlersClient(conn), 1, time.Millisecond, true)
+
+	metrics := make([]*models.Data, 0, 3)
+	for _, name := range []string{"a", "b", "c"} {
+		value := 1.0
+		metrics = append(metrics, &models.Data{Name: name, Type: "gauge", Value: &value})
+	}
+
+	// Стрим обрывается после первой метрики: при отправке или закрытии остальные повторяются через PostUpdates
+	ctx := context.WithValue(context.Background(), pkg.ContextKey{}, 0)
+	if err = client.PostMetrics(ctx, metrics); err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, client.Close())
+
+	select {
+	case batch := <-handlers.batches:
+		ids := make([]string, 0, len(batch))
+		for _, metric := range batch {
+			ids = append(ids, metric.Id)
+		}
+		assert.Equal(t, []string{"b", "c"}, ids)
+	default:
+		t.Fatal("unconfirmed metrics are not resent")
+	}
+}
+
+func TestHandler_GetMetric(t *testing.T) {
+	conn, memStorage := newTestConn(t, "")
+	client := pb.NewHandlersClient(conn)
+
+	value := 7.5
+	if err := memStorage.Update(&models.Data{Name: "cpu", Type: "gauge", Value: &value, Labels: map[string]string{"host": "web1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := client.GetMetric(context.Background(), &pb.GetMetricRequest{Id: "cpu", Labels: map[string]string{"host": "web1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, value, response.Metric.GetGauge())
+
+	_, err = client.GetMetric(context.Background(), &pb.GetMetricRequest{Id: "cpu", Labels: map[string]string{"host": "web2"}})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	_, err = client.GetMetric(context.Background(), &pb.GetMetricRequest{})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+func TestHandler_ListMetrics(t *testing.T) {
+	conn, memStorage := newTestConn(t, "")
+	client := pb.NewHandlersClient(conn)
+
+	value := 1.0
+	for _, name := range []string{"mem.free", "cpu.user", "cpu.system", "cpu.idle"} {
+		if err := memStorage.Update(&models.Data{Name: name, Type: "gauge", Value: &value}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Постраничный вывод по префиксу в порядке идентификаторов серий
+	var names []string
+	request := &pb.ListMetricsRequest{Prefix: "cpu.", PageSize: 2}
+	for {
+		response, err := client.ListMetrics(context.Background(), request)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, metric := range response.Metrics {
+			names = append(names, metric.Id)
+		}
+		if response.NextPageToken == "" {
+			break
+		}
+		request.PageToken = response.NextPageToken
+	}
+	assert.Equal(t, []string{"cpu.idle", "cpu.system", "cpu.user"}, names)
+
+	_, err := client.ListMetrics(context.Background(), &pb.ListMetricsRequest{PageSize: -1})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
@@ -0,0 +1,56 @@
+package grpc
+
+import (
+	"context"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"metrics/internal/server/otlp"
+)
+
+// otlpExportMethod - полное имя метода записи метрик OTLP/gRPC
+const otlpExportMethod = "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export"
+
+// MetricsService - структура приемника OTLP/gRPC, регистрируется рядом с Handlers
+type MetricsService struct {
+	colmetricspb.UnimplementedMetricsServiceServer
+	storageCommands *StorageCommands
+	converter       *otlp.Converter
+}
+
+// NewMetricsService - конструктор приемника OTLP/gRPC
+func NewMetricsService(gRPCStorageCommands *StorageCommands, converter *otlp.Converter) *MetricsService {
+	return &MetricsService{
+		storageCommands: gRPCStorageCommands,
+		converter:       converter,
+	}
+}
+
+// Export - метод записи метрик OTLP ExportMetricsServiceRequest
+func (m *MetricsService) Export(ctx context.Context, request *colmetricspb.ExportMetricsServiceRequest) (*colmetricspb.ExportMetricsServiceResponse, error) {
+	storageData, partialSuccess, pending := m.converter.Convert(request)
+
+	for _, data := range storageData {
+		// Проверка невалидных значений
+		if err := data.CheckData(); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid metric %s: %s", data.Name, err.Error())
+		}
+
+		// Отметка инстанса агента
+		markInstance(ctx, data)
+	}
+
+	// Обновление или сохранение новых записей в хранилище
+	if len(storageData) > 0 {
+		if err := m.storageCommands.UpdateBatch(storageData); err != nil {
+			return nil, status.Errorf(codes.Unavailable, "failed update metrics: %s", err.Error())
+		}
+	}
+
+	// Накопленные значения сохраняются только после записи, повтор после ошибки даст те же приращения
+	m.converter.Commit(pending)
+
+	return &colmetricspb.ExportMetricsServiceResponse{PartialSuccess: partialSuccess}, nil
+}
@@ -0,0 +1,147 @@
+package grpc
+
+import (
+	"context"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	pb "metrics/internal/server/proto"
+)
+
+// wrappedStream - обертка стрима сервера для обработки каждого входящего сообщения
+type wrappedStream struct {
+	grpc.ServerStream
+	recv func(any) error
+}
+
+// agentStream - обертка стрима сервера с контекстом, дополненным идентификатором агента
+type agentStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+// Context возвращает контекст стрима с идентификатором агента
+func (a *agentStream) Context() context.Context {
+	return a.ctx
+}
+
+// RecvMsg читает сообщение стрима и передает его в обработчик обертки
+func (w *wrappedStream) RecvMsg(m any) error {
+	if err := w.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	return w.recv(m)
+}
+
+// withStreamLogger - перехватчик логирует стримы
+func (g *GRPCServer) withStreamLogger(srv any, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	// Запуск таймера
+	start := time.Now()
+
+	g.logger.Infof("gRPC server received stream: %s", info.FullMethod)
+
+	// Запуск RPC-метода
+	err := handler(srv, ss)
+
+	// Логирует код и таймер
+	e, _ := status.FromError(err)
+	g.logger.Infof("Stream completed with code %v in %s", e.Code(), time.Since(start))
+
+	return err
+}
+
+// withStreamTrustedSubnet - перехватчик проверяет подсеть в метаданных при открытии стрима
+func (g *GRPCServer) withStreamTrustedSubnet(srv any, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := g.checkTrustedSubnet(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+
+	return handler(srv, ss)
+}
+
+// withStreamToken - перехватчик проверяет bearer токен при открытии стрима
+func (g *GRPCServer) withStreamToken(srv any, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := g.checkToken(ss.Context(), info.FullMethod); err != nil {
+		return err
+	}
+
+	return handler(srv, ss)
+}
+
+// withStreamClientCert - перехватчик привязывает идентификатор инстанса стрима к сертификату клиента
+func (g *GRPCServer) withStreamClientCert(srv any, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := g.checkInstance(ss.Context())
+	if err != nil {
+		return err
+	}
+
+	if ctx != ss.Context() {
+		ss = &agentStream{ServerStream: ss, ctx: ctx}
+	}
+
+	return handler(srv, ss)
+}
+
+// withStreamHash - перехватчик проверяет хеш каждой метрики стрима.
+// Метаданные передаются один раз на стрим, поэтому подпись передается в самой метрике
+func (g *GRPCServer) withStreamHash(srv any, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	// Выбор ключа подписи агента при открытии стрима
+	hashKey, err := g.hashKeyFor(ss.Context())
+	if err != nil {
+		return err
+	}
+
+	// Проверка наличия ключа
+	if hashKey == "" {
+		return handler(srv, ss)
+	}
+
+	// Стрим подписан ключом агента: каждое сообщение проверяется, поэтому идентификатор агента подтвержден
+	if agentID := instanceFrom(ss.Context()); g.auth.agentKeys != nil && agentID != "" {
+		ss = &agentStream{
+			ServerStream: ss,
+			ctx:          context.WithValue(ss.Context(), agentIDKey{}, agentID),
+		}
+	}
+
+	return handler(srv, &wrappedStream{
+		ServerStream: ss,
+		recv: func(m any) error {
+			metric, ok := m.(*pb.Metric)
+			if !ok {
+				return nil
+			}
+
+			g.logger.Infof("start checking gRPC stream message hash")
+			if metric.Hash == "" {
+				return status.Errorf(codes.Unauthenticated, "missing hash in stream message: server requires signed requests")
+			}
+
+			return g.checkHash(metric, signature{
+				hash:      metric.Hash,
+				timestamp: metric.Timestamp,
+				nonce:     metric.Nonce,
+			}, hashKey)
+		},
+	})
+}
+
+// withStreamDecrypt - перехватчик шифрования стрима. Метрики стрима передаются открыто,
+// поэтому при включенном шифровании стрим отклоняется и метрики передаются через PostUpdates
+func (g *GRPCServer) withStreamDecrypt(srv any, ss grpc.ServerStream,
+	info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if g.auth.keys != nil && info.FullMethod == pb.Handlers_StreamUpdates_FullMethodName {
+		return status.Errorf(codes.FailedPrecondition, "stream metrics are not encrypted, send encrypted metrics with PostUpdates")
+	}
+
+	return handler(srv, ss)
+}
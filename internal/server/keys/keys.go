@@ -0,0 +1,204 @@
+// Модуль keys загружает приватные ключи сервера и перечитывает их при ротации
+package keys
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"metrics/pkg/envelope"
+)
+
+// pollInterval - интервал проверки изменения файлов ключей
+const pollInterval = 10 * time.Second
+
+// ErrUnknownKey - ключ с запрошенным идентификатором не найден
+var ErrUnknownKey = errors.New("unknown key id")
+
+// Provider - поставщик связки приватных ключей, общий для HTTP и gRPC серверов
+type Provider struct {
+	paths  []string
+	ring   atomic.Pointer[keyring]
+	logger *logrus.Logger
+
+	// Разрешает расшифровку тел без версии устаревшей схемой
+	allowLegacy bool
+
+	// Защищает перечитывание файлов и время их изменения
+	mu       sync.Mutex
+	modTimes []time.Time
+}
+
+// keyring - связка ключей по идентификаторам
+type keyring struct {
+	keys    map[string]*rsa.PrivateKey
+	primary *rsa.PrivateKey
+}
+
+// NewProvider - конструктор поставщика, читает ключи при создании.
+// Первый ключ списка используется для запросов без идентификатора ключа,
+// allowLegacy разрешает устаревшую схему шифрования для тел без версии
+func NewProvider(paths []string, allowLegacy bool, logger *logrus.Logger) (*Provider, error) {
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("empty private keys list")
+	}
+
+	provider := &Provider{
+		paths:       paths,
+		logger:      logger,
+		allowLegacy: allowLegacy,
+	}
+
+	if err := provider.Reload(); err != nil {
+		return nil, err
+	}
+
+	return provider, nil
+}
+
+// Key возвращает приватный ключ по идентификатору, при пустом идентификаторе - основной ключ
+func (p *Provider) Key(id string) (*rsa.PrivateKey, error) {
+	ring := p.ring.Load()
+	if id == "" {
+		return ring.primary, nil
+	}
+
+	key, ok := ring.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownKey, id)
+	}
+
+	return key, nil
+}
+
+// AllowLegacy сообщает, разрешена ли устаревшая схема шифрования для тел без версии
+func (p *Provider) AllowLegacy() bool {
+	return p.allowLegacy
+}
+
+// Reload перечитывает файлы ключей. При ошибке остается предыдущая связка
+func (p *Provider) Reload() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ring := &keyring{keys: make(map[string]*rsa.PrivateKey, len(p.paths))}
+	modTimes := make([]time.Time, 0, len(p.paths))
+	for _, path := range p.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("failed stat private key %s: %w", path, err)
+		}
+
+		key, err := readKey(path)
+		if err != nil {
+			return fmt.Errorf("failed load %s: %w", path, err)
+		}
+
+		id, err := envelope.KeyID(&key.PublicKey)
+		if err != nil {
+			return fmt.Errorf("failed build key id of %s: %w", path, err)
+		}
+
+		if ring.primary == nil {
+			ring.primary = key
+		}
+		ring.keys[id] = key
+		modTimes = append(modTimes, info.ModTime())
+	}
+
+	p.ring.Store(ring)
+	p.modTimes = modTimes
+
+	return nil
+}
+
+// Watch перечитывает ключи при изменении файлов или по сигналу SIGHUP до отмены контекста
+func (p *Provider) Watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			p.logger.Infof("SIGHUP received, reloading private keys")
+			p.reload()
+		case <-ticker.C:
+			if p.changed() {
+				p.logger.Infof("private key files changed, reloading")
+				p.reload()
+			}
+		}
+	}
+}
+
+// reload перечитывает ключи с логированием результата
+func (p *Provider) reload() {
+	if err := p.Reload(); err != nil {
+		p.logger.Errorf("failed reload private keys, keep previous: %s", err.Error())
+		return
+	}
+	p.logger.Infof("private keys reloaded")
+}
+
+// changed сообщает, изменился ли любой из файлов ключей с последнего чтения
+func (p *Provider) changed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, path := range p.paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			p.logger.Errorf("failed stat private key: %s", err.Error())
+			return false
+		}
+
+		if !info.ModTime().Equal(p.modTimes[i]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readKey читает и проверяет приватный ключ из pem файла
+func readKey(path string) (*rsa.PrivateKey, error) {
+	// Чтение pem файла
+	privatePEM, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed read private key: %w", err)
+	}
+
+	// Поиск блока приватного ключа
+	privateKeyBlock, _ := pem.Decode(privatePEM)
+	if privateKeyBlock == nil {
+		return nil, fmt.Errorf("no pem block found in %s", path)
+	}
+
+	// Парсинг приватного ключа
+	privateKey, err := x509.ParsePKCS1PrivateKey(privateKeyBlock.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed parse private key: %w", err)
+	}
+	if err = privateKey.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+
+	return privateKey, nil
+}
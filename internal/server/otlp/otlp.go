@@ -0,0 +1,304 @@
+// Модуль otlp преобразует запросы OTLP ExportMetricsServiceRequest в метрики хранилища.
+// Sum становится counter, Gauge - gauge, атрибуты ресурса и точки - метками серии
+package otlp
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+
+	"metrics/internal/models"
+)
+
+const (
+	// seriesTTL - время, после которого не обновлявшаяся накопительная серия забывается
+	seriesTTL = time.Hour
+
+	// sweepInterval - интервал удаления забытых серий
+	sweepInterval = time.Minute
+
+	// maxSeries - максимальное количество отслеживаемых накопительных серий
+	maxSeries = 100000
+)
+
+// cumulative - последнее накопленное значение серии Sum с накопительной темпоральностью
+type cumulative struct {
+	start uint64
+	value int64
+	seen  time.Time
+}
+
+// Pending - новые накопленные значения серий из преобразованного запроса.
+// Сохраняются методом Commit только после записи метрик, чтобы повтор запроса после ошибки записи
+// снова дал те же приращения
+type Pending struct {
+	sums map[string]pendingSum
+	// Количество серий запроса, которых еще нет в преобразовании
+	added int
+}
+
+// pendingSum - накопленное значение серии, на котором основано приращение, и новое значение
+type pendingSum struct {
+	base *cumulative
+	next *cumulative
+}
+
+// Converter - преобразование запросов OTLP, общее для приемников HTTP и gRPC.
+// Накопительные Sum пересчитываются в приращения counter, для этого хранится последнее значение серии
+type Converter struct {
+	mu        sync.Mutex
+	sums      map[string]*cumulative
+	lastSweep time.Time
+	now       func() time.Time
+
+	// Серии, начатые раньше горизонта, могли быть учтены до перезапуска или удаления из памяти
+	horizon time.Time
+}
+
+// NewConverter - конструктор преобразования запросов OTLP
+func NewConverter() *Converter {
+	now := time.Now()
+
+	return &Converter{
+		sums:      make(map[string]*cumulative),
+		lastSweep: now,
+		now:       time.Now,
+		horizon:   now,
+	}
+}
+
+// Convert преобразует запрос в метрики хранилища, не меняя накопленные значения серий.
+// Точки неподдерживаемых типов (Histogram, ExponentialHistogram, Summary) отклоняются
+// и возвращаются в частичном успехе, как требует OTLP. Новые накопленные значения возвращаются в Pending
+// и сохраняются вызовом Commit после записи метрик
+func (c *Converter) Convert(request *colmetricspb.ExportMetricsServiceRequest) ([]*models.Data, *colmetricspb.ExportMetricsPartialSuccess, *Pending) {
+	data := make([]*models.Data, 0)
+	pending := &Pending{sums: make(map[string]pendingSum)}
+	var rejected int64
+	var message string
+
+	for _, resourceMetrics := range request.GetResourceMetrics() {
+		resourceLabels := attributesToLabels(nil, resourceMetrics.GetResource().GetAttributes())
+
+		for _, scopeMetrics := range resourceMetrics.GetScopeMetrics() {
+			for _, metric := range scopeMetrics.GetMetrics() {
+				switch {
+				case metric.GetGauge() != nil:
+					for _, point := range metric.GetGauge().GetDataPoints() {
+						value, ok := pointValue(point)
+						if !ok {
+							rejected++
+							continue
+						}
+
+						data = append(data, &models.Data{
+							Type:      "gauge",
+							Name:      metric.GetName(),
+							Value:     &value,
+							Labels:    attributesToLabels(resourceLabels, point.GetAttributes()),
+							Timestamp: pointTime(point),
+						})
+					}
+
+				case metric.GetSum() != nil:
+					sum := metric.GetSum()
+					for _, point := range sum.GetDataPoints() {
+						value, ok := pointValue(point)
+						if !ok {
+							rejected++
+							continue
+						}
+
+						labels := attributesToLabels(resourceLabels, point.GetAttributes())
+						delta := int64(math.Round(value))
+						if sum.GetAggregationTemporality() == metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+							if delta, ok = c.delta(pending, models.SeriesKey(metric.GetName(), labels), point.GetStartTimeUnixNano(), delta, sum.GetIsMonotonic()); !ok {
+								rejected++
+								message = "too many cumulative series"
+								continue
+							}
+						}
+
+						data = append(data, &models.Data{
+							Type:      "counter",
+							Name:      metric.GetName(),
+							Delta:     &delta,
+							Labels:    labels,
+							Timestamp: pointTime(point),
+						})
+					}
+
+				default:
+					points := unsupportedPoints(metric)
+					rejected += points
+					if points > 0 {
+						message = fmt.Sprintf("unsupported data type of metric %s", metric.GetName())
+					}
+				}
+			}
+		}
+	}
+
+	if rejected == 0 {
+		return data, nil, pending
+	}
+	if message == "" {
+		message = "data points without value rejected"
+	}
+
+	return data, &colmetricspb.ExportMetricsPartialSuccess{RejectedDataPoints: rejected, ErrorMessage: message}, pending
+}
+
+// Commit сохраняет накопленные значения серий записанного запроса.
+// Значение не сохраняется, если серия изменилась после преобразования: его уже сохранил параллельный запрос
+func (c *Converter) Commit(pending *Pending) {
+	if pending == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, sum := range pending.sums {
+		current, ok := c.sums[key]
+		if current != sum.base || (!ok && len(c.sums) >= maxSeries) {
+			continue
+		}
+		c.sums[key] = sum.next
+	}
+}
+
+// delta возвращает приращение накопительного значения серии с прошлого запроса.
+// Новое время начала или уменьшение монотонного значения означают сброс счетчика.
+// Первая точка серии, начатой до горизонта, только запоминается как база: ее значение
+// уже могло быть учтено до перезапуска сервера или удаления серии. Новое значение запоминается в pending,
+// предыдущее берется из pending, если серия уже встречалась в запросе. Возвращает false, если отслеживаемых серий слишком много
+func (c *Converter) delta(pending *Pending, key string, start uint64, value int64, monotonic bool) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.now()
+	if now.Sub(c.lastSweep) >= sweepInterval {
+		c.sweep(now)
+	}
+
+	sum, inRequest := pending.sums[key]
+	if !inRequest {
+		sum.base = c.sums[key]
+		sum.next = sum.base
+	}
+
+	previous := sum.next
+	ok := previous != nil
+	if !ok {
+		if len(c.sums)+pending.added >= maxSeries {
+			return 0, false
+		}
+		pending.added++
+	}
+	sum.next = &cumulative{start: start, value: value, seen: now}
+	pending.sums[key] = sum
+
+	switch {
+	case !ok && (start == 0 || time.Unix(0, int64(start)).Before(c.horizon)):
+		return 0, true
+	case !ok || previous.start != start || (monotonic && value < previous.value):
+		return value, true
+	}
+
+	return value - previous.value, true
+}
+
+// sweep удаляет серии, не обновлявшиеся дольше seriesTTL, и сдвигает горизонт за их последнюю точку
+func (c *Converter) sweep(now time.Time) {
+	for key, series := range c.sums {
+		if now.Sub(series.seen) > seriesTTL {
+			delete(c.sums, key)
+			if series.seen.After(c.horizon) {
+				c.horizon = series.seen
+			}
+		}
+	}
+	c.lastSweep = now
+}
+
+// pointValue возвращает значение точки, целые значения приводятся к float64
+func pointValue(point *metricspb.NumberDataPoint) (float64, bool) {
+	switch value := point.GetValue().(type) {
+	case *metricspb.NumberDataPoint_AsDouble:
+		if math.IsNaN(value.AsDouble) || math.IsInf(value.AsDouble, 0) {
+			return 0, false
+		}
+		return value.AsDouble, true
+	case *metricspb.NumberDataPoint_AsInt:
+		return float64(value.AsInt), true
+	}
+
+	return 0, false
+}
+
+// pointTime возвращает время точки для истории, nil если источник его не передал
+func pointTime(point *metricspb.NumberDataPoint) *time.Time {
+	if point.GetTimeUnixNano() == 0 {
+		return nil
+	}
+
+	timestamp := time.Unix(0, int64(point.GetTimeUnixNano()))
+
+	return &timestamp
+}
+
+// unsupportedPoints возвращает количество точек метрики неподдерживаемого типа
+func unsupportedPoints(metric *metricspb.Metric) int64 {
+	switch {
+	case metric.GetHistogram() != nil:
+		return int64(len(metric.GetHistogram().GetDataPoints()))
+	case metric.GetExponentialHistogram() != nil:
+		return int64(len(metric.GetExponentialHistogram().GetDataPoints()))
+	case metric.GetSummary() != nil:
+		return int64(len(metric.GetSummary().GetDataPoints()))
+	}
+
+	return 0
+}
+
+// attributesToLabels дополняет копию меток base атрибутами, атрибуты имеют приоритет.
+// Массивы и вложенные списки атрибутов пропускаются
+func attributesToLabels(base map[string]string, attributes []*commonpb.KeyValue) map[string]string {
+	if len(base) == 0 && len(attributes) == 0 {
+		return nil
+	}
+
+	labels := make(map[string]string, len(base)+len(attributes))
+	for key, value := range base {
+		labels[key] = value
+	}
+
+	for _, attribute := range attributes {
+		if attribute.GetKey() == "" {
+			continue
+		}
+
+		switch value := attribute.GetValue().GetValue().(type) {
+		case *commonpb.AnyValue_StringValue:
+			labels[attribute.GetKey()] = value.StringValue
+		case *commonpb.AnyValue_BoolValue:
+			labels[attribute.GetKey()] = strconv.FormatBool(value.BoolValue)
+		case *commonpb.AnyValue_IntValue:
+			labels[attribute.GetKey()] = strconv.FormatInt(value.IntValue, 10)
+		case *commonpb.AnyValue_DoubleValue:
+			labels[attribute.GetKey()] = strconv.FormatFloat(value.DoubleValue, 'g', -1, 64)
+		case *commonpb.AnyValue_BytesValue:
+			labels[attribute.GetKey()] = base64.StdEncoding.EncodeToString(value.BytesValue)
+		}
+	}
+
+	return labels
+}
@@ -0,0 +1,246 @@
+package otlp
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	colmetricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+)
+
+// request собирает запрос OTLP с метриками одного ресурса
+func request(metrics ...*metricspb.Metric) *colmetricspb.ExportMetricsServiceRequest {
+	return &colmetricspb.ExportMetricsServiceRequest{ResourceMetrics: []*metricspb.ResourceMetrics{{
+		Resource: &resourcepb.Resource{Attributes: []*commonpb.KeyValue{{
+			Key:   "service.name",
+			Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "api"}},
+		}}},
+		ScopeMetrics: []*metricspb.ScopeMetrics{{Metrics: metrics}},
+	}}}
+}
+
+// sum собирает Sum с одной целочисленной точкой
+func sum(temporality metricspb.AggregationTemporality, start time.Time, value int64) *metricspb.Metric {
+	return &metricspb.Metric{
+		Name: "requests",
+		Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			AggregationTemporality: temporality,
+			IsMonotonic:            true,
+			DataPoints: []*metricspb.NumberDataPoint{{
+				StartTimeUnixNano: uint64(start.UnixNano()),
+				TimeUnixNano:      uint64(start.Add(time.Minute).UnixNano()),
+				Value:             &metricspb.NumberDataPoint_AsInt{AsInt: value},
+			}},
+		}},
+	}
+}
+
+// cumulativeSum собирает накопительную монотонную Sum
+func cumulativeSum(start time.Time, value int64) *metricspb.Metric {
+	return sum(metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE, start, value)
+}
+
+// deltas преобразует запросы по очереди и возвращает приращения counter
+func deltas(t *testing.T, converter *Converter, requests ...*colmetricspb.ExportMetricsServiceRequest) []int64 {
+	t.Helper()
+
+	got := make([]int64, 0, len(requests))
+	for _, req := range requests {
+		data, partial, pending := converter.Convert(req)
+		assert.Nil(t, partial)
+		converter.Commit(pending)
+		if assert.Len(t, data, 1) {
+			assert.Equal(t, "counter", data[0].Type)
+			assert.Equal(t, map[string]string{"service.name": "api"}, data[0].Labels)
+			got = append(got, *data[0].Delta)
+		}
+	}
+
+	return got
+}
+
+func TestConverter_Gauge(t *testing.T) {
+	converter := NewConverter()
+	point := time.Unix(1700000000, 0)
+
+	data, partial, _ := converter.Convert(request(&metricspb.Metric{
+		Name: "temperature",
+		Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{
+			{
+				TimeUnixNano: uint64(point.UnixNano()),
+				Value:        &metricspb.NumberDataPoint_AsDouble{AsDouble: 21.5},
+				Attributes: []*commonpb.KeyValue{{
+					Key:   "room",
+					Value: &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: "kitchen"}},
+				}},
+			},
+			{Value: &metricspb.NumberDataPoint_AsInt{AsInt: 3}},
+		}}},
+	}))
+	assert.Nil(t, partial)
+
+	if assert.Len(t, data, 2) {
+		assert.Equal(t, "gauge", data[0].Type)
+		assert.Equal(t, 21.5, *data[0].Value)
+		assert.Equal(t, map[string]string{"service.name": "api", "room": "kitchen"}, data[0].Labels)
+		assert.True(t, data[0].Timestamp.Equal(point))
+
+		assert.Equal(t, 3.0, *data[1].Value)
+		assert.Nil(t, data[1].Timestamp)
+	}
+}
+
+func TestConverter_DeltaSum(t *testing.T) {
+	converter := NewConverter()
+	start := time.Now().Add(-time.Hour)
+	delta := func(value int64) *colmetricspb.ExportMetricsServiceRequest {
+		return request(sum(metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA, start, value))
+	}
+
+	// Приращения передаются как есть, даже для серий, начатых до запуска
+	assert.Equal(t, []int64{5, 3, 7}, deltas(t, converter, delta(5), delta(3), delta(7)))
+}
+
+func TestConverter_CumulativeSum(t *testing.T) {
+	tests := []struct {
+		name   string
+		points func(started time.Time) []*colmetricspb.ExportMetricsServiceRequest
+		want   []int64
+	}{
+		{
+			name: "series started before converter is a baseline",
+			points: func(started time.Time) []*colmetricspb.ExportMetricsServiceRequest {
+				start := started.Add(-time.Hour)
+				return []*colmetricspb.ExportMetricsServiceRequest{
+					request(cumulativeSum(start, 100)),
+					request(cumulativeSum(start, 110)),
+					request(cumulativeSum(start, 125)),
+				}
+			},
+			want: []int64{0, 10, 15},
+		},
+		{
+			name: "series started after converter counts in full",
+			points: func(started time.Time) []*colmetricspb.ExportMetricsServiceRequest {
+				start := started.Add(time.Second)
+				return []*colmetricspb.ExportMetricsServiceRequest{
+					request(cumulativeSum(start, 4)),
+					request(cumulativeSum(start, 9)),
+				}
+			},
+			want: []int64{4, 5},
+		},
+		{
+			name: "new start time resets counter",
+			points: func(started time.Time) []*colmetricspb.ExportMetricsServiceRequest {
+				return []*colmetricspb.ExportMetricsServiceRequest{
+					request(cumulativeSum(started.Add(-time.Hour), 100)),
+					request(cumulativeSum(started.Add(-time.Hour), 120)),
+					request(cumulativeSum(started.Add(time.Second), 3)),
+				}
+			},
+			want: []int64{0, 20, 3},
+		},
+		{
+			name: "monotonic decrease resets counter",
+			points: func(started time.Time) []*colmetricspb.ExportMetricsServiceRequest {
+				start := started.Add(-time.Hour)
+				return []*colmetricspb.ExportMetricsServiceRequest{
+					request(cumulativeSum(start, 100)),
+					request(cumulativeSum(start, 2)),
+					request(cumulativeSum(start, 6)),
+				}
+			},
+			want: []int64{0, 2, 4},
+		},
+		{
+			name: "missing start time is a baseline",
+			points: func(started time.Time) []*colmetricspb.ExportMetricsServiceRequest {
+				return []*colmetricspb.ExportMetricsServiceRequest{
+					request(cumulativeSum(time.Unix(0, 0), 50)),
+					request(cumulativeSum(time.Unix(0, 0), 51)),
+				}
+			},
+			want: []int64{0, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			converter := NewConverter()
+			assert.Equal(t, tt.want, deltas(t, converter, tt.points(converter.horizon)...))
+		})
+	}
+}
+
+func TestConverter_RetryAfterFailedWrite(t *testing.T) {
+	converter := NewConverter()
+	start := converter.horizon.Add(time.Second)
+
+	assert.Equal(t, []int64{10}, deltas(t, converter, request(cumulativeSum(start, 10))))
+
+	// Без Commit накопленное значение не меняется, повтор запроса дает то же приращение
+	data, _, _ := converter.Convert(request(cumulativeSum(start, 15)))
+	if assert.Len(t, data, 1) {
+		assert.Equal(t, int64(5), *data[0].Delta)
+	}
+	assert.Equal(t, []int64{5, 3}, deltas(t, converter, request(cumulativeSum(start, 15)), request(cumulativeSum(start, 18))))
+
+	// Точки одной серии в запросе считаются друг от друга
+	req := request(cumulativeSum(start, 20), cumulativeSum(start, 26))
+	data, _, pending := converter.Convert(req)
+	if assert.Len(t, data, 2) {
+		assert.Equal(t, int64(2), *data[0].Delta)
+		assert.Equal(t, int64(6), *data[1].Delta)
+	}
+	converter.Commit(pending)
+	assert.Equal(t, []int64{1}, deltas(t, converter, request(cumulativeSum(start, 27))))
+}
+
+func TestConverter_Sweep(t *testing.T) {
+	converter := NewConverter()
+	start := converter.horizon.Add(time.Second)
+	now := start.Add(time.Minute)
+	converter.now = func() time.Time { return now }
+
+	assert.Equal(t, []int64{10}, deltas(t, converter, request(cumulativeSum(start, 10))))
+
+	// Серия без обновлений дольше seriesTTL удаляется из памяти
+	now = now.Add(seriesTTL + sweepInterval + time.Second)
+	other := request(&metricspb.Metric{
+		Name: "other",
+		Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+			AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE,
+			DataPoints:             []*metricspb.NumberDataPoint{{Value: &metricspb.NumberDataPoint_AsInt{AsInt: 1}}},
+		}},
+	})
+	_, _, pending := converter.Convert(other)
+	converter.Commit(pending)
+	assert.Len(t, converter.sums, 1)
+
+	// Вернувшаяся серия с тем же временем начала не учитывается повторно
+	assert.Equal(t, []int64{0, 5}, deltas(t, converter, request(cumulativeSum(start, 12)), request(cumulativeSum(start, 17))))
+}
+
+func TestConverter_Unsupported(t *testing.T) {
+	converter := NewConverter()
+
+	data, partial, _ := converter.Convert(request(
+		&metricspb.Metric{
+			Name: "latency",
+			Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{DataPoints: []*metricspb.HistogramDataPoint{{}, {}}}},
+		},
+		&metricspb.Metric{
+			Name: "temperature",
+			Data: &metricspb.Metric_Gauge{Gauge: &metricspb.Gauge{DataPoints: []*metricspb.NumberDataPoint{{}}}},
+		},
+	))
+
+	assert.Empty(t, data)
+	if assert.NotNil(t, partial) {
+		assert.Equal(t, int64(3), partial.RejectedDataPoints)
+		assert.Equal(t, "unsupported data type of metric latency", partial.ErrorMessage)
+	}
+}
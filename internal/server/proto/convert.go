@@ -0,0 +1,91 @@
+package proto
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/proto"
+
+	"metrics/internal/models"
+)
+
+// AcceptedTrailer - ключ трейлера стрима с количеством метрик, записанных до ошибки
+const AcceptedTrailer = "accepted-metrics"
+
+// NewMetric собирает типизированную метрику из данных хранилища
+func NewMetric(data *models.Data) (*Metric, error) {
+	metric := &Metric{
+		Id:     data.Name,
+		Labels: data.Labels,
+	}
+
+	switch data.Type {
+	case "gauge":
+		if data.Value == nil {
+			return nil, fmt.Errorf("empty gauge value")
+		}
+		metric.Value = &Metric_Gauge{Gauge: *data.Value}
+	case "counter":
+		if data.Delta == nil {
+			return nil, fmt.Errorf("empty counter delta")
+		}
+		metric.Value = &Metric_Counter{Counter: *data.Delta}
+	default:
+		return nil, fmt.Errorf("unknown metric type %q", data.Type)
+	}
+
+	return metric, nil
+}
+
+// ToData приводит типизированную метрику к данным хранилища
+func (x *Metric) ToData() (*models.Data, error) {
+	data := &models.Data{Name: x.GetId()}
+	if len(x.GetLabels()) > 0 {
+		data.Labels = x.GetLabels()
+	}
+
+	switch value := x.GetValue().(type) {
+	case *Metric_Gauge:
+		data.Type = "gauge"
+		data.Value = &value.Gauge
+	case *Metric_Counter:
+		data.Type = "counter"
+		data.Delta = &value.Counter
+	default:
+		return nil, fmt.Errorf("empty value of metric %q", x.GetId())
+	}
+
+	return data, nil
+}
+
+// Payload возвращает тело запроса для вычисления подписи:
+// устаревшее поле с JSON, либо детерминированно сериализованный типизированный батч
+func (x *PostUpdatesRequest) Payload() ([]byte, error) {
+	if len(x.GetMetrics()) > 0 && len(x.GetBatch()) > 0 {
+		return nil, fmt.Errorf("legacy and typed metrics can't be sent together")
+	}
+
+	if len(x.GetMetrics()) > 0 {
+		return x.GetMetrics(), nil
+	}
+
+	payload, err := proto.MarshalOptions{Deterministic: true}.Marshal(&PostUpdatesRequest{Batch: x.GetBatch()})
+	if err != nil {
+		return nil, fmt.Errorf("marshal batch: %w", err)
+	}
+
+	return payload, nil
+}
+
+// Payload возвращает тело метрики стрима для вычисления подписи: детерминированно сериализованная метрика без подписи
+func (x *Metric) Payload() ([]byte, error) {
+	payload, err := proto.MarshalOptions{Deterministic: true}.Marshal(&Metric{
+		Id:     x.GetId(),
+		Value:  x.Value,
+		Labels: x.GetLabels(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("marshal metric: %w", err)
+	}
+
+	return payload, nil
+}
@@ -7,12 +7,11 @@
 package proto
 
 import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 	reflect "reflect"
 	sync "sync"
 	unsafe "unsafe"
-
-	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
-	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
 )
 
 const (
@@ -22,16 +21,140 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// Типизированная метрика
+type Metric struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Id    string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"` // название метрики
+	// Types that are valid to be assigned to Value:
+	//
+	//	*Metric_Gauge
+	//	*Metric_Counter
+	Value         isMetric_Value    `protobuf_oneof:"value"`
+	Labels        map[string]string `protobuf:"bytes,4,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // метки серии
+	Hash          string            `protobuf:"bytes,5,opt,name=hash,proto3" json:"hash,omitempty"`                                                                               // подпись сообщения стрима, в остальных запросах не заполняется
+	Timestamp     string            `protobuf:"bytes,6,opt,name=timestamp,proto3" json:"timestamp,omitempty"`                                                                     // время отправки сообщения стрима в секундах unix
+	Nonce         string            `protobuf:"bytes,7,opt,name=nonce,proto3" json:"nonce,omitempty"`                                                                             // одноразовое значение сообщения стрима
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Metric) Reset() {
+	*x = Metric{}
+	mi := &file_internal_server_proto_handlers_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Metric) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Metric) ProtoMessage() {}
+
+func (x *Metric) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_server_proto_handlers_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Metric.ProtoReflect.Descriptor instead.
+func (*Metric) Descriptor() ([]byte, []int) {
+	return file_internal_server_proto_handlers_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Metric) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Metric) GetValue() isMetric_Value {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *Metric) GetGauge() float64 {
+	if x != nil {
+		if x, ok := x.Value.(*Metric_Gauge); ok {
+			return x.Gauge
+		}
+	}
+	return 0
+}
+
+func (x *Metric) GetCounter() int64 {
+	if x != nil {
+		if x, ok := x.Value.(*Metric_Counter); ok {
+			return x.Counter
+		}
+	}
+	return 0
+}
+
+func (x *Metric) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+func (x *Metric) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+func (x *Metric) GetTimestamp() string {
+	if x != nil {
+		return x.Timestamp
+	}
+	return ""
+}
+
+func (x *Metric) GetNonce() string {
+	if x != nil {
+		return x.Nonce
+	}
+	return ""
+}
+
+type isMetric_Value interface {
+	isMetric_Value()
+}
+
+type Metric_Gauge struct {
+	Gauge float64 `protobuf:"fixed64,2,opt,name=gauge,proto3,oneof"` // значение метрики типа gauge
+}
+
+type Metric_Counter struct {
+	Counter int64 `protobuf:"varint,3,opt,name=counter,proto3,oneof"` // значение метрики типа counter
+}
+
+func (*Metric_Gauge) isMetric_Value() {}
+
+func (*Metric_Counter) isMetric_Value() {}
+
 type PostUpdatesRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Metrics       []byte                 `protobuf:"bytes,1,opt,name=metrics,proto3" json:"metrics,omitempty"`
+	Metrics       []byte                 `protobuf:"bytes,1,opt,name=metrics,proto3" json:"metrics,omitempty"` // устаревшее поле: метрики в JSON, поддерживается на время миграции
+	Batch         []*Metric              `protobuf:"bytes,2,rep,name=batch,proto3" json:"batch,omitempty"`     // типизированные метрики
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
 func (x *PostUpdatesRequest) Reset() {
 	*x = PostUpdatesRequest{}
-	mi := &file_internal_server_proto_handlers_proto_msgTypes[0]
+	mi := &file_internal_server_proto_handlers_proto_msgTypes[1]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -43,7 +166,7 @@ func (x *PostUpdatesRequest) String() string {
 func (*PostUpdatesRequest) ProtoMessage() {}
 
 func (x *PostUpdatesRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_server_proto_handlers_proto_msgTypes[0]
+	mi := &file_internal_server_proto_handlers_proto_msgTypes[1]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -56,7 +179,7 @@ func (x *PostUpdatesRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PostUpdatesRequest.ProtoReflect.Descriptor instead.
 func (*PostUpdatesRequest) Descriptor() ([]byte, []int) {
-	return file_internal_server_proto_handlers_proto_rawDescGZIP(), []int{0}
+	return file_internal_server_proto_handlers_proto_rawDescGZIP(), []int{1}
 }
 
 func (x *PostUpdatesRequest) GetMetrics() []byte {
@@ -66,6 +189,13 @@ func (x *PostUpdatesRequest) GetMetrics() []byte {
 	return nil
 }
 
+func (x *PostUpdatesRequest) GetBatch() []*Metric {
+	if x != nil {
+		return x.Batch
+	}
+	return nil
+}
+
 type PostUpdatesResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	Error         string                 `protobuf:"bytes,1,opt,name=error,proto3" json:"error,omitempty"` // ошибка
@@ -75,7 +205,7 @@ type PostUpdatesResponse struct {
 
 func (x *PostUpdatesResponse) Reset() {
 	*x = PostUpdatesResponse{}
-	mi := &file_internal_server_proto_handlers_proto_msgTypes[1]
+	mi := &file_internal_server_proto_handlers_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -87,7 +217,7 @@ func (x *PostUpdatesResponse) String() string {
 func (*PostUpdatesResponse) ProtoMessage() {}
 
 func (x *PostUpdatesResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_internal_server_proto_handlers_proto_msgTypes[1]
+	mi := &file_internal_server_proto_handlers_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -100,7 +230,7 @@ func (x *PostUpdatesResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use PostUpdatesResponse.ProtoReflect.Descriptor instead.
 func (*PostUpdatesResponse) Descriptor() ([]byte, []int) {
-	return file_internal_server_proto_handlers_proto_rawDescGZIP(), []int{1}
+	return file_internal_server_proto_handlers_proto_rawDescGZIP(), []int{2}
 }
 
 func (x *PostUpdatesResponse) GetError() string {
@@ -110,17 +240,303 @@ func (x *PostUpdatesResponse) GetError() string {
 	return ""
 }
 
+type Summary struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Metrics       int64                  `protobuf:"varint,1,opt,name=metrics,proto3" json:"metrics,omitempty"` // количество принятых метрик
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Summary) Reset() {
+	*x = Summary{}
+	mi := &file_internal_server_proto_handlers_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Summary) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Summary) ProtoMessage() {}
+
+func (x *Summary) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_server_proto_handlers_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Summary.ProtoReflect.Descriptor instead.
+func (*Summary) Descriptor() ([]byte, []int) {
+	return file_internal_server_proto_handlers_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Summary) GetMetrics() int64 {
+	if x != nil {
+		return x.Metrics
+	}
+	return 0
+}
+
+type GetMetricRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`                                                                                   // название метрики
+	Labels        map[string]string      `protobuf:"bytes,2,rep,name=labels,proto3" json:"labels,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"` // метки серии
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetMetricRequest) Reset() {
+	*x = GetMetricRequest{}
+	mi := &file_internal_server_proto_handlers_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetMetricRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetMetricRequest) ProtoMessage() {}
+
+func (x *GetMetricRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_server_proto_handlers_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetMetricRequest.ProtoReflect.Descriptor instead.
+func (*GetMetricRequest) Descriptor() ([]byte, []int) {
+	return file_internal_server_proto_handlers_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetMetricRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *GetMetricRequest) GetLabels() map[string]string {
+	if x != nil {
+		return x.Labels
+	}
+	return nil
+}
+
+type GetMetricResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Metric        *Metric                `protobuf:"bytes,1,opt,name=metric,proto3" json:"metric,omitempty"` // метрика
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetMetricResponse) Reset() {
+	*x = GetMetricResponse{}
+	mi := &file_internal_server_proto_handlers_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetMetricResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetMetricResponse) ProtoMessage() {}
+
+func (x *GetMetricResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_server_proto_handlers_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetMetricResponse.ProtoReflect.Descriptor instead.
+func (*GetMetricResponse) Descriptor() ([]byte, []int) {
+	return file_internal_server_proto_handlers_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetMetricResponse) GetMetric() *Metric {
+	if x != nil {
+		return x.Metric
+	}
+	return nil
+}
+
+type ListMetricsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Prefix        string                 `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`                        // фильтр по префиксу названия
+	PageSize      int32                  `protobuf:"varint,2,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`   // размер страницы
+	PageToken     string                 `protobuf:"bytes,3,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"` // токен страницы из предыдущего ответа
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListMetricsRequest) Reset() {
+	*x = ListMetricsRequest{}
+	mi := &file_internal_server_proto_handlers_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListMetricsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListMetricsRequest) ProtoMessage() {}
+
+func (x *ListMetricsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_server_proto_handlers_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListMetricsRequest.ProtoReflect.Descriptor instead.
+func (*ListMetricsRequest) Descriptor() ([]byte, []int) {
+	return file_internal_server_proto_handlers_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *ListMetricsRequest) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+func (x *ListMetricsRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *ListMetricsRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
+	}
+	return ""
+}
+
+type ListMetricsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Metrics       []*Metric              `protobuf:"bytes,1,rep,name=metrics,proto3" json:"metrics,omitempty"`                                    // метрики страницы
+	NextPageToken string                 `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"` // токен следующей страницы, пустой на последней странице
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListMetricsResponse) Reset() {
+	*x = ListMetricsResponse{}
+	mi := &file_internal_server_proto_handlers_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListMetricsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListMetricsResponse) ProtoMessage() {}
+
+func (x *ListMetricsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_server_proto_handlers_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListMetricsResponse.ProtoReflect.Descriptor instead.
+func (*ListMetricsResponse) Descriptor() ([]byte, []int) {
+	return file_internal_server_proto_handlers_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *ListMetricsResponse) GetMetrics() []*Metric {
+	if x != nil {
+		return x.Metrics
+	}
+	return nil
+}
+
+func (x *ListMetricsResponse) GetNextPageToken() string {
+	if x != nil {
+		return x.NextPageToken
+	}
+	return ""
+}
+
 var File_internal_server_proto_handlers_proto protoreflect.FileDescriptor
 
 const file_internal_server_proto_handlers_proto_rawDesc = "" +
 	"\n" +
-	"$internal/server/proto/handlers.proto\x12\vserver_grpc\".\n" +
+	"$internal/server/proto/handlers.proto\x12\vserver_grpc\"\x91\x02\n" +
+	"\x06Metric\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x05gauge\x18\x02 \x01(\x01H\x00R\x05gauge\x12\x1a\n" +
+	"\acounter\x18\x03 \x01(\x03H\x00R\acounter\x127\n" +
+	"\x06labels\x18\x04 \x03(\v2\x1f.server_grpc.Metric.LabelsEntryR\x06labels\x12\x12\n" +
+	"\x04hash\x18\x05 \x01(\tR\x04hash\x12\x1c\n" +
+	"\ttimestamp\x18\x06 \x01(\tR\ttimestamp\x12\x14\n" +
+	"\x05nonce\x18\a \x01(\tR\x05nonce\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01B\a\n" +
+	"\x05value\"Y\n" +
 	"\x12PostUpdatesRequest\x12\x18\n" +
-	"\ametrics\x18\x01 \x01(\fR\ametrics\"+\n" +
+	"\ametrics\x18\x01 \x01(\fR\ametrics\x12)\n" +
+	"\x05batch\x18\x02 \x03(\v2\x13.server_grpc.MetricR\x05batch\"+\n" +
 	"\x13PostUpdatesResponse\x12\x14\n" +
-	"\x05error\x18\x01 \x01(\tR\x05error2\\\n" +
+	"\x05error\x18\x01 \x01(\tR\x05error\"#\n" +
+	"\aSummary\x12\x18\n" +
+	"\ametrics\x18\x01 \x01(\x03R\ametrics\"\xa0\x01\n" +
+	"\x10GetMetricRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12A\n" +
+	"\x06labels\x18\x02 \x03(\v2).server_grpc.GetMetricRequest.LabelsEntryR\x06labels\x1a9\n" +
+	"\vLabelsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
+	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"@\n" +
+	"\x11GetMetricResponse\x12+\n" +
+	"\x06metric\x18\x01 \x01(\v2\x13.server_grpc.MetricR\x06metric\"h\n" +
+	"\x12ListMetricsRequest\x12\x16\n" +
+	"\x06prefix\x18\x01 \x01(\tR\x06prefix\x12\x1b\n" +
+	"\tpage_size\x18\x02 \x01(\x05R\bpageSize\x12\x1d\n" +
+	"\n" +
+	"page_token\x18\x03 \x01(\tR\tpageToken\"l\n" +
+	"\x13ListMetricsResponse\x12-\n" +
+	"\ametrics\x18\x01 \x03(\v2\x13.server_grpc.MetricR\ametrics\x12&\n" +
+	"\x0fnext_page_token\x18\x02 \x01(\tR\rnextPageToken2\xb8\x02\n" +
 	"\bHandlers\x12P\n" +
-	"\vPostUpdates\x12\x1f.server_grpc.PostUpdatesRequest\x1a .server_grpc.PostUpdatesResponseB\x17Z\x15internal/server/protob\x06proto3"
+	"\vPostUpdates\x12\x1f.server_grpc.PostUpdatesRequest\x1a .server_grpc.PostUpdatesResponse\x12<\n" +
+	"\rStreamUpdates\x12\x13.server_grpc.Metric\x1a\x14.server_grpc.Summary(\x01\x12J\n" +
+	"\tGetMetric\x12\x1d.server_grpc.GetMetricRequest\x1a\x1e.server_grpc.GetMetricResponse\x12P\n" +
+	"\vListMetrics\x12\x1f.server_grpc.ListMetricsRequest\x1a .server_grpc.ListMetricsResponseB\x17Z\x15internal/server/protob\x06proto3"
 
 var (
 	file_internal_server_proto_handlers_proto_rawDescOnce sync.Once
@@ -134,19 +550,38 @@ func file_internal_server_proto_handlers_proto_rawDescGZIP() []byte {
 	return file_internal_server_proto_handlers_proto_rawDescData
 }
 
-var file_internal_server_proto_handlers_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_internal_server_proto_handlers_proto_msgTypes = make([]protoimpl.MessageInfo, 10)
 var file_internal_server_proto_handlers_proto_goTypes = []any{
-	(*PostUpdatesRequest)(nil),  // 0: server_grpc.PostUpdatesRequest
-	(*PostUpdatesResponse)(nil), // 1: server_grpc.PostUpdatesResponse
+	(*Metric)(nil),              // 0: server_grpc.Metric
+	(*PostUpdatesRequest)(nil),  // 1: server_grpc.PostUpdatesRequest
+	(*PostUpdatesResponse)(nil), // 2: server_grpc.PostUpdatesResponse
+	(*Summary)(nil),             // 3: server_grpc.Summary
+	(*GetMetricRequest)(nil),    // 4: server_grpc.GetMetricRequest
+	(*GetMetricResponse)(nil),   // 5: server_grpc.GetMetricResponse
+	(*ListMetricsRequest)(nil),  // 6: server_grpc.ListMetricsRequest
+	(*ListMetricsResponse)(nil), // 7: server_grpc.ListMetricsResponse
+	nil,                         // 8: server_grpc.Metric.LabelsEntry
+	nil,                         // 9: server_grpc.GetMetricRequest.LabelsEntry
 }
 var file_internal_server_proto_handlers_proto_depIdxs = []int32{
-	0, // 0: server_grpc.Handlers.PostUpdates:input_type -> server_grpc.PostUpdatesRequest
-	1, // 1: server_grpc.Handlers.PostUpdates:output_type -> server_grpc.PostUpdatesResponse
-	1, // [1:2] is the sub-list for method output_type
-	0, // [0:1] is the sub-list for method input_type
-	0, // [0:0] is the sub-list for extension type_name
-	0, // [0:0] is the sub-list for extension extendee
-	0, // [0:0] is the sub-list for field type_name
+	8, // 0: server_grpc.Metric.labels:type_name -> server_grpc.Metric.LabelsEntry
+	0, // 1: server_grpc.PostUpdatesRequest.batch:type_name -> server_grpc.Metric
+	9, // 2: server_grpc.GetMetricRequest.labels:type_name -> server_grpc.GetMetricRequest.LabelsEntry
+	0, // 3: server_grpc.GetMetricResponse.metric:type_name -> server_grpc.Metric
+	0, // 4: server_grpc.ListMetricsResponse.metrics:type_name -> server_grpc.Metric
+	1, // 5: server_grpc.Handlers.PostUpdates:input_type -> server_grpc.PostUpdatesRequest
+	0, // 6: server_grpc.Handlers.StreamUpdates:input_type -> server_grpc.Metric
+	4, // 7: server_grpc.Handlers.GetMetric:input_type -> server_grpc.GetMetricRequest
+	6, // 8: server_grpc.Handlers.ListMetrics:input_type -> server_grpc.ListMetricsRequest
+	2, // 9: server_grpc.Handlers.PostUpdates:output_type -> server_grpc.PostUpdatesResponse
+	3, // 10: server_grpc.Handlers.StreamUpdates:output_type -> server_grpc.Summary
+	5, // 11: server_grpc.Handlers.GetMetric:output_type -> server_grpc.GetMetricResponse
+	7, // 12: server_grpc.Handlers.ListMetrics:output_type -> server_grpc.ListMetricsResponse
+	9, // [9:13] is the sub-list for method output_type
+	5, // [5:9] is the sub-list for method input_type
+	5, // [5:5] is the sub-list for extension type_name
+	5, // [5:5] is the sub-list for extension extendee
+	0, // [0:5] is the sub-list for field type_name
 }
 
 func init() { file_internal_server_proto_handlers_proto_init() }
@@ -154,13 +589,17 @@ func file_internal_server_proto_handlers_proto_init() {
 	if File_internal_server_proto_handlers_proto != nil {
 		return
 	}
+	file_internal_server_proto_handlers_proto_msgTypes[0].OneofWrappers = []any{
+		(*Metric_Gauge)(nil),
+		(*Metric_Counter)(nil),
+	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_internal_server_proto_handlers_proto_rawDesc), len(file_internal_server_proto_handlers_proto_rawDesc)),
 			NumEnums:      0,
-			NumMessages:   2,
+			NumMessages:   10,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
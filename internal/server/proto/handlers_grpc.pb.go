@@ -8,7 +8,6 @@ package proto
 
 import (
 	context "context"
-
 	grpc "google.golang.org/grpc"
 	codes "google.golang.org/grpc/codes"
 	status "google.golang.org/grpc/status"
@@ -20,7 +19,10 @@ import (
 const _ = grpc.SupportPackageIsVersion9
 
 const (
-	Handlers_PostUpdates_FullMethodName = "/server_grpc.Handlers/PostUpdates"
+	Handlers_PostUpdates_FullMethodName   = "/server_grpc.Handlers/PostUpdates"
+	Handlers_StreamUpdates_FullMethodName = "/server_grpc.Handlers/StreamUpdates"
+	Handlers_GetMetric_FullMethodName     = "/server_grpc.Handlers/GetMetric"
+	Handlers_ListMetrics_FullMethodName   = "/server_grpc.Handlers/ListMetrics"
 )
 
 // HandlersClient is the client API for Handlers service.
@@ -28,6 +30,11 @@ const (
 // For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
 type HandlersClient interface {
 	PostUpdates(ctx context.Context, in *PostUpdatesRequest, opts ...grpc.CallOption) (*PostUpdatesResponse, error)
+	// Метаданные передаются один раз на стрим, поэтому подпись, время отправки и nonce идут в каждой метрике.
+	// Метрики стрима не шифруются, зашифрованные метрики передаются через PostUpdates
+	StreamUpdates(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[Metric, Summary], error)
+	GetMetric(ctx context.Context, in *GetMetricRequest, opts ...grpc.CallOption) (*GetMetricResponse, error)
+	ListMetrics(ctx context.Context, in *ListMetricsRequest, opts ...grpc.CallOption) (*ListMetricsResponse, error)
 }
 
 type handlersClient struct {
@@ -48,11 +55,49 @@ func (c *handlersClient) PostUpdates(ctx context.Context, in *PostUpdatesRequest
 	return out, nil
 }
 
+func (c *handlersClient) StreamUpdates(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[Metric, Summary], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Handlers_ServiceDesc.Streams[0], Handlers_StreamUpdates_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Metric, Summary]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Handlers_StreamUpdatesClient = grpc.ClientStreamingClient[Metric, Summary]
+
+func (c *handlersClient) GetMetric(ctx context.Context, in *GetMetricRequest, opts ...grpc.CallOption) (*GetMetricResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetMetricResponse)
+	err := c.cc.Invoke(ctx, Handlers_GetMetric_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *handlersClient) ListMetrics(ctx context.Context, in *ListMetricsRequest, opts ...grpc.CallOption) (*ListMetricsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListMetricsResponse)
+	err := c.cc.Invoke(ctx, Handlers_ListMetrics_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // HandlersServer is the server API for Handlers service.
 // All implementations must embed UnimplementedHandlersServer
 // for forward compatibility.
 type HandlersServer interface {
 	PostUpdates(context.Context, *PostUpdatesRequest) (*PostUpdatesResponse, error)
+	// Метаданные передаются один раз на стрим, поэтому подпись, время отправки и nonce идут в каждой метрике.
+	// Метрики стрима не шифруются, зашифрованные метрики передаются через PostUpdates
+	StreamUpdates(grpc.ClientStreamingServer[Metric, Summary]) error
+	GetMetric(context.Context, *GetMetricRequest) (*GetMetricResponse, error)
+	ListMetrics(context.Context, *ListMetricsRequest) (*ListMetricsResponse, error)
 	mustEmbedUnimplementedHandlersServer()
 }
 
@@ -66,6 +111,15 @@ type UnimplementedHandlersServer struct{}
 func (UnimplementedHandlersServer) PostUpdates(context.Context, *PostUpdatesRequest) (*PostUpdatesResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method PostUpdates not implemented")
 }
+func (UnimplementedHandlersServer) StreamUpdates(grpc.ClientStreamingServer[Metric, Summary]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamUpdates not implemented")
+}
+func (UnimplementedHandlersServer) GetMetric(context.Context, *GetMetricRequest) (*GetMetricResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMetric not implemented")
+}
+func (UnimplementedHandlersServer) ListMetrics(context.Context, *ListMetricsRequest) (*ListMetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListMetrics not implemented")
+}
 func (UnimplementedHandlersServer) mustEmbedUnimplementedHandlersServer() {}
 func (UnimplementedHandlersServer) testEmbeddedByValue()                  {}
 
@@ -105,6 +159,49 @@ func _Handlers_PostUpdates_Handler(srv interface{}, ctx context.Context, dec fun
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Handlers_StreamUpdates_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(HandlersServer).StreamUpdates(&grpc.GenericServerStream[Metric, Summary]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Handlers_StreamUpdatesServer = grpc.ClientStreamingServer[Metric, Summary]
+
+func _Handlers_GetMetric_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMetricRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HandlersServer).GetMetric(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Handlers_GetMetric_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HandlersServer).GetMetric(ctx, req.(*GetMetricRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Handlers_ListMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(HandlersServer).ListMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Handlers_ListMetrics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(HandlersServer).ListMetrics(ctx, req.(*ListMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Handlers_ServiceDesc is the grpc.ServiceDesc for Handlers service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -116,7 +213,21 @@ var Handlers_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "PostUpdates",
 			Handler:    _Handlers_PostUpdates_Handler,
 		},
+		{
+			MethodName: "GetMetric",
+			Handler:    _Handlers_GetMetric_Handler,
+		},
+		{
+			MethodName: "ListMetrics",
+			Handler:    _Handlers_ListMetrics_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamUpdates",
+			Handler:       _Handlers_StreamUpdates_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "internal/server/proto/handlers.proto",
 }
@@ -3,19 +3,33 @@ package server
 
 import (
 	"context"
+	"crypto/rsa"
+	"crypto/tls"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
+	"metrics/internal/server/agents"
 	"metrics/internal/server/api"
 	"metrics/internal/server/config"
+	"metrics/internal/server/enroll"
+	"metrics/internal/server/graphite"
 	"metrics/internal/server/grpc"
+	"metrics/internal/server/keys"
 	"metrics/internal/server/metrics"
+	"metrics/internal/server/otlp"
+	"metrics/internal/server/statsd"
+	"metrics/internal/server/tokens"
+	"metrics/pkg/admin"
+	"metrics/pkg/realip"
+	"metrics/pkg/replay"
+	"metrics/pkg/tlsconfig"
 )
 
 // Server - структура сервера
@@ -37,12 +51,34 @@ type options struct {
 	storeInterval   float64
 	fileStoragePath string
 	restore         bool
+	tls             *config.TLS
+	admin           *config.Admin
+	enroll          *config.Enroll
+	statsd          *config.StatsD
+	graphite        *config.Graphite
+	replayWindow    time.Duration
 }
 
 type auth struct {
-	cryptoKey     string
-	hashKey       string
-	trustedSubnet *net.IPNet
+	cryptoKey         string
+	hashKey           string
+	allowLegacyCrypto bool
+	agentKeys         agents.Keystore
+	tokens            tokens.Store
+	ingestSubnets     realip.Subnets
+	readSubnets       realip.Subnets
+	trustedProxies    realip.Proxies
+}
+
+// privateKeyProvider - интерфейс поставщика приватного ключа
+type privateKeyProvider interface {
+	Key(string) (*rsa.PrivateKey, error)
+	AllowLegacy() bool
+}
+
+// replayGuard - интерфейс проверки повторов подписанных запросов
+type replayGuard interface {
+	Check(string, string) error
 }
 
 // New - конструктор инстанса сервера
@@ -50,6 +86,8 @@ func New(
 	apiStorageCommands *api.StorageCommands,
 	gRPCStorageCommands *grpc.StorageCommands,
 	metricsFileStorage *metrics.MetricsFileStorage,
+	agentKeys agents.Keystore,
+	apiTokens tokens.Store,
 	logger *logrus.Logger,
 	cfg *config.ServerConfig) *Server {
 	return &Server{
@@ -63,11 +101,22 @@ func New(
 			storeInterval:   cfg.FileStorage.StoreInterval,
 			fileStoragePath: cfg.FileStorage.FileStoragePath,
 			restore:         cfg.FileStorage.Restore,
+			tls:             cfg.TLS,
+			admin:           cfg.Admin,
+			enroll:          cfg.Enroll,
+			statsd:          cfg.StatsD,
+			graphite:        cfg.Graphite,
+			replayWindow:    cfg.ReplayWindow,
 		},
 		auth: &auth{
-			cryptoKey:     cfg.CryptoKey,
-			hashKey:       cfg.Key,
-			trustedSubnet: cfg.Net.TrustedSubnet,
+			cryptoKey:         cfg.CryptoKey,
+			allowLegacyCrypto: cfg.AllowLegacyCrypto,
+			hashKey:           cfg.Key,
+			agentKeys:         agentKeys,
+			tokens:            apiTokens,
+			ingestSubnets:     cfg.Net.TrustedSubnets,
+			readSubnets:       cfg.Net.ReadSubnets,
+			trustedProxies:    cfg.Net.TrustedProxies,
 		},
 	}
 }
@@ -106,13 +155,99 @@ func (s *Server) Start(ctx context.Context, host *config.Host) error {
 		}
 	}()
 
+	// Конфигурация TLS слушателей
+	var tlsConfig *tls.Config
+	if s.options.tls != nil && s.options.tls.Enabled() {
+		var err error
+		if tlsConfig, err = tlsconfig.NewServer(s.options.tls.CertFile, s.options.tls.KeyFile, s.options.tls.ClientCAFile); err != nil {
+			return fmt.Errorf("failed build TLS config: %w", err)
+		}
+	}
+
+	// Поставщик приватных ключей, общий для HTTP и gRPC серверов.
+	// Без ключа остается nil интерфейса, дешифровка запросов отключена
+	var keyProvider privateKeyProvider
+	if s.auth.cryptoKey != "" {
+		provider, err := keys.NewProvider(strings.Split(s.auth.cryptoKey, ","), s.auth.allowLegacyCrypto, s.logger)
+		if err != nil {
+			return fmt.Errorf("failed load private keys: %w", err)
+		}
+		keyProvider = provider
+
+		// Перечитывание ключа при ротации
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			provider.Watch(ctx)
+		}()
+	}
+
+	// Проверка повторов подписанных запросов, общая для HTTP и gRPC серверов.
+	// При ключе подписи окно по умолчанию replay.DefaultWindow, отрицательное окно отключает проверку
+	// и остается nil интерфейса
+	var guard replayGuard
+	signed := s.auth.hashKey != "" || s.auth.agentKeys != nil
+	replayWindow := s.options.replayWindow
+	if replayWindow == 0 && signed {
+		replayWindow = replay.DefaultWindow
+	}
+	if replayWindow > 0 {
+		guard = replay.NewGuard(replayWindow)
+	} else if signed {
+		s.logger.Warn("replay protection of signed requests is disabled")
+	}
+
+	// Служебный слушатель с профилировщиком, по умолчанию отключен
+	if s.options.admin != nil && s.options.admin.Address != "" {
+		// Профилировщик без токена раскрывает память процесса, поэтому слушатель не запускается
+		if s.options.admin.Token == "" && s.auth.tokens == nil {
+			return fmt.Errorf("admin listener on %s requires admin token or API tokens", s.options.admin.Address)
+		}
+
+		adminSRV := admin.New(s.options.admin.Address, s.adminAuthorizer())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := adminSRV.Run(ctx); err != nil {
+				s.logger.Error(err)
+			}
+		}()
+	}
+
+	// Преобразование OTLP общее для HTTP и gRPC, чтобы накопительные серии считались одинаково при отправке любым транспортом
+	converter := otlp.NewConverter()
+
 	// HTTP Server
-	httpSRV := api.NewServer(host.String(), s.auth.cryptoKey, s.auth.hashKey, s.auth.trustedSubnet, s.services.apiStorageCommands, s.logger)
+	httpSRV := api.NewServer(host.String(), keyProvider, s.auth.hashKey, s.auth.agentKeys, s.auth.tokens, guard, s.auth.ingestSubnets, s.auth.readSubnets, s.auth.trustedProxies, tlsConfig, s.services.apiStorageCommands, converter, s.logger)
+
+	// Регистрация агентов и выпуск сертификатов
+	if s.options.enroll != nil && s.options.enroll.Enabled() {
+		keyWriter, ok := s.auth.agentKeys.(agents.Writer)
+		if !ok {
+			return fmt.Errorf("agent enrollment requires agent keys file or database")
+		}
+
+		issuer, err := enroll.New(s.options.enroll.CACertFile, s.options.enroll.CAKeyFile,
+			s.options.enroll.BootstrapFile, s.options.enroll.CertTTL, keyWriter)
+		if err != nil {
+			return fmt.Errorf("failed init agent enrollment: %w", err)
+		}
+		if err = httpSRV.EnableEnrollment(issuer); err != nil {
+			return fmt.Errorf("failed init agent enrollment: %w", err)
+		}
+	}
 
 	// Старт HTTP сервера
 	go func() {
-		s.logger.Infof("Starting server on %v", host.String())
-		if err := httpSRV.Server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		s.logger.Infof("Starting server on %v, TLS: %t", host.String(), tlsConfig != nil)
+
+		// Сертификат уже загружен в конфигурацию TLS сервера
+		serve := httpSRV.Server.ListenAndServe
+		if tlsConfig != nil {
+			serve = func() error { return httpSRV.Server.ListenAndServeTLS("", "") }
+		}
+
+		if err := serve(); err != nil && err != http.ErrServerClosed {
 			log.Fatal("HTTP Server Error:", err)
 		}
 	}()
@@ -123,7 +258,7 @@ func (s *Server) Start(ctx context.Context, host *config.Host) error {
 		return fmt.Errorf("gRPC could not listen on %v: %v", host.GRPCPort, err)
 	}
 
-	gRPCServer := grpc.NewServer(s.auth.cryptoKey, s.auth.hashKey, s.auth.trustedSubnet, s.services.gRPCStorageCommands, s.logger)
+	gRPCServer := grpc.NewServer(keyProvider, s.auth.hashKey, s.auth.agentKeys, s.auth.tokens, guard, s.auth.ingestSubnets, s.auth.readSubnets, s.auth.trustedProxies, tlsConfig, s.services.gRPCStorageCommands, converter, s.logger)
 
 	// Старт gRPC сервера
 	go func() {
@@ -133,6 +268,40 @@ func (s *Server) Start(ctx context.Context, host *config.Host) error {
 		}
 	}()
 
+	// StatsD слушатель, по умолчанию отключен
+	if s.options.statsd != nil && s.options.statsd.Address != "" {
+		// Протокол без аутентификации не должен обходить подпись и токены остальных приемников
+		if len(s.auth.ingestSubnets) == 0 && (s.auth.hashKey != "" || s.auth.agentKeys != nil || s.auth.tokens != nil) {
+			return fmt.Errorf("statsd listener has no authentication, restrict it with trusted subnet")
+		}
+
+		statsdSRV := statsd.NewServer(s.options.statsd.Address, s.options.statsd.FlushInterval, s.auth.ingestSubnets, s.services.apiStorageCommands, s.logger)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := statsdSRV.Run(ctx); err != nil {
+				log.Fatal("StatsD Server Error:", err)
+			}
+		}()
+	}
+
+	// Graphite слушатель, по умолчанию отключен
+	if s.options.graphite != nil && s.options.graphite.Address != "" {
+		// Протокол без аутентификации не должен обходить подпись и токены остальных приемников
+		if len(s.auth.ingestSubnets) == 0 && (s.auth.hashKey != "" || s.auth.agentKeys != nil || s.auth.tokens != nil) {
+			return fmt.Errorf("graphite listener has no authentication, restrict it with trusted subnet")
+		}
+
+		graphiteSRV := graphite.NewServer(s.options.graphite.Address, s.auth.ingestSubnets, s.services.apiStorageCommands, s.logger)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := graphiteSRV.Run(ctx); err != nil {
+				log.Fatal("Graphite Server Error:", err)
+			}
+		}()
+	}
+
 	// Ожидание сигнала остановки приложения
 	<-ctx.Done()
 
@@ -149,3 +318,26 @@ func (s *Server) Start(ctx context.Context, host *config.Host) error {
 
 	return nil
 }
+
+// adminAuthorizer проверяет токен служебного слушателя:
+// токен из конфигурации или токен API с областью admin
+func (s *Server) adminAuthorizer() admin.Authorizer {
+	static := admin.StaticToken(s.options.admin.Token)
+	if s.auth.tokens == nil {
+		return static
+	}
+
+	return func(token string) error {
+		scope, err := s.auth.tokens.TokenScope(tokens.Hash(token))
+		if err == nil && scope.Allows(tokens.ScopeAdmin) {
+			return nil
+		}
+
+		// Без токена в конфигурации нужен токен API с областью admin
+		if s.options.admin.Token == "" {
+			return admin.ErrInvalidToken
+		}
+
+		return static(token)
+	}
+}
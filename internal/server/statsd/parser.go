@@ -0,0 +1,102 @@
+package statsd
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// kind - тип метрики StatsD
+type kind int
+
+const (
+	kindCounter kind = iota
+	kindGauge
+	kindTimer
+)
+
+// metric - разобранная строка StatsD
+type metric struct {
+	series
+	kind     kind
+	value    float64
+	rate     float64
+	relative bool
+}
+
+// parseLine разбирает строку вида name:value|type[|@rate][|#tag:value,...].
+// Поддерживаются типы c, g и ms, теги DogStatsD становятся метками
+func parseLine(line string) (*metric, error) {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok {
+		return nil, fmt.Errorf("missing name:value separator")
+	}
+	if name == "" {
+		return nil, fmt.Errorf("missing metric name")
+	}
+
+	fields := strings.Split(rest, "|")
+	if len(fields) < 2 {
+		return nil, fmt.Errorf("missing metric type")
+	}
+
+	m := &metric{series: series{name: name}, rate: 1}
+	switch fields[1] {
+	case "c":
+		m.kind = kindCounter
+	case "g":
+		m.kind = kindGauge
+		m.relative = strings.HasPrefix(fields[0], "+") || strings.HasPrefix(fields[0], "-")
+	case "ms":
+		m.kind = kindTimer
+	default:
+		return nil, fmt.Errorf("unsupported metric type %q", fields[1])
+	}
+
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value: %w", err)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("unsupported value %s", fields[0])
+	}
+	m.value = value
+
+	// Частота выборки и теги
+	for _, field := range fields[2:] {
+		switch {
+		case strings.HasPrefix(field, "@"):
+			// Сравнение в такой форме отклоняет и NaN
+			if m.rate, err = strconv.ParseFloat(field[1:], 64); err != nil || !(m.rate > 0 && m.rate <= 1) {
+				return nil, fmt.Errorf("invalid sample rate %q", field)
+			}
+		case strings.HasPrefix(field, "#"):
+			m.labels = parseTags(field[1:])
+		}
+	}
+
+	// Приращение счетчика с учетом частоты выборки должно помещаться в int64
+	if m.kind == kindCounter && math.Abs(math.Round(m.value/m.rate)) >= math.MaxInt64 {
+		return nil, fmt.Errorf("counter value %s out of range", fields[0])
+	}
+
+	return m, nil
+}
+
+// parseTags разбирает теги вида key:value,key2:value2, тег без значения получает значение "true"
+func parseTags(tags string) map[string]string {
+	labels := make(map[string]string)
+	for _, tag := range strings.Split(tags, ",") {
+		key, value, ok := strings.Cut(tag, ":")
+		if key == "" {
+			continue
+		}
+		if !ok {
+			value = "true"
+		}
+		labels[key] = value
+	}
+
+	return labels
+}
@@ -0,0 +1,77 @@
+package statsd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    *metric
+		wantErr bool
+	}{
+		{
+			name: "counter",
+			line: "requests:3|c",
+			want: &metric{series: series{name: "requests"}, kind: kindCounter, value: 3, rate: 1},
+		},
+		{
+			name: "counter with sample rate",
+			line: "requests:1|c|@0.1",
+			want: &metric{series: series{name: "requests"}, kind: kindCounter, value: 1, rate: 0.1},
+		},
+		{
+			name: "gauge",
+			line: "temperature:21.5|g",
+			want: &metric{series: series{name: "temperature"}, kind: kindGauge, value: 21.5, rate: 1},
+		},
+		{
+			name: "gauge increment",
+			line: "connections:+4|g",
+			want: &metric{series: series{name: "connections"}, kind: kindGauge, value: 4, rate: 1, relative: true},
+		},
+		{
+			name: "gauge decrement",
+			line: "connections:-2|g",
+			want: &metric{series: series{name: "connections"}, kind: kindGauge, value: -2, rate: 1, relative: true},
+		},
+		{
+			name: "timer with tags",
+			line: "latency:320|ms|@0.5|#route:/api,canary",
+			want: &metric{
+				series: series{name: "latency", labels: map[string]string{"route": "/api", "canary": "true"}},
+				kind:   kindTimer,
+				value:  320,
+				rate:   0.5,
+			},
+		},
+		{name: "missing separator", line: "requests", wantErr: true},
+		{name: "missing name", line: ":1|c", wantErr: true},
+		{name: "missing type", line: "requests:1", wantErr: true},
+		{name: "unsupported type", line: "users:1|s", wantErr: true},
+		{name: "invalid value", line: "requests:many|c", wantErr: true},
+		{name: "NaN value", line: "temperature:NaN|g", wantErr: true},
+		{name: "infinite value", line: "requests:+Inf|c", wantErr: true},
+		{name: "zero rate", line: "requests:1|c|@0", wantErr: true},
+		{name: "negative rate", line: "requests:1|c|@-0.5", wantErr: true},
+		{name: "rate above one", line: "requests:1|c|@2", wantErr: true},
+		{name: "NaN rate", line: "requests:1|c|@NaN", wantErr: true},
+		{name: "counter out of range", line: "requests:1e300|c", wantErr: true},
+		{name: "scaled counter out of range", line: "requests:1e18|c|@0.01", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLine(tt.line)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
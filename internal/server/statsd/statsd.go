@@ -0,0 +1,454 @@
+// Модуль statsd принимает метрики в формате StatsD по UDP,
+// агрегирует их за интервал сброса и записывает в хранилище
+package statsd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"metrics/internal/models"
+	"metrics/pkg/realip"
+)
+
+const (
+	// maxPacketSize - максимальный размер UDP пакета
+	maxPacketSize = 65535
+
+	// defaultInterval - интервал сброса агрегатов по умолчанию
+	defaultInterval = 10 * time.Second
+
+	// gaugeTTL - время, после которого не обновлявшийся gauge забывается,
+	// следующее относительное изменение отсчитывается от нуля
+	gaugeTTL = time.Hour
+
+	// maxTimerSamples - максимальное количество значений таймера, хранимых между сбросами.
+	// Сверх лимита значения попадают в выборку случайно, количество, сумма и границы считаются по всем
+	maxTimerSamples = 10000
+)
+
+// dataUpdater - интерфейс записи метрик в хранилище
+type dataUpdater interface {
+	UpdateBatch([]*models.Data) error
+}
+
+// series - серия метрики: имя и метки из тегов
+type series struct {
+	name   string
+	labels map[string]string
+}
+
+// Server - структура инстанса StatsD слушателя
+type Server struct {
+	address  string
+	interval time.Duration
+	subnets  realip.Subnets
+	updater  dataUpdater
+	logger   *logrus.Logger
+
+	mu       sync.Mutex
+	counters map[string]*counter
+	gauges   map[string]*gauge
+	timers   map[string]*timer
+}
+
+// counter - сумма счетчика за интервал
+type counter struct {
+	series
+	delta int64
+}
+
+// gauge - последнее значение gauge. Значение хранится между интервалами
+// для относительных изменений вида name:+1|g, записываются только обновленные
+type gauge struct {
+	series
+	value   float64
+	updated bool
+	seen    time.Time
+}
+
+// timer - значения таймера за интервал: выборка значений для перцентиля и агрегаты по всем значениям
+type timer struct {
+	series
+	values []float64
+	count  int64
+	sum    float64
+	min    float64
+	max    float64
+}
+
+// snapshot - агрегаты интервала, изъятые для записи. При ошибке записи возвращаются в агрегаты
+type snapshot struct {
+	counters map[string]*counter
+	gauges   map[string]*gauge
+	timers   map[string]*timer
+}
+
+// NewServer - конструктор StatsD слушателя на адресе address с интервалом сброса interval (по умолчанию 10 секунд).
+// При непустом subnets пакеты принимаются только с адресов этих подсетей
+func NewServer(address string, interval time.Duration, subnets realip.Subnets, updater dataUpdater, logger *logrus.Logger) *Server {
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	return &Server{
+		address:  address,
+		interval: interval,
+		subnets:  subnets,
+		updater:  updater,
+		logger:   logger,
+		counters: make(map[string]*counter),
+		gauges:   make(map[string]*gauge),
+		timers:   make(map[string]*timer),
+	}
+}
+
+// Run принимает пакеты и сбрасывает агрегаты до отмены контекста, при остановке выполняется последний сброс
+func (s *Server) Run(ctx context.Context) error {
+	conn, err := net.ListenPacket("udp", s.address)
+	if err != nil {
+		return fmt.Errorf("statsd could not listen on %v: %w", s.address, err)
+	}
+
+	s.logger.Infof("Starting StatsD listener on %v, flush interval %s", conn.LocalAddr(), s.interval)
+
+	s.serve(ctx, conn)
+
+	return nil
+}
+
+// serve принимает пакеты до отмены контекста
+func (s *Server) serve(ctx context.Context, conn net.PacketConn) {
+	// Закрытие соединения прерывает чтение при остановке
+	go func() {
+		<-ctx.Done()
+		if err := conn.Close(); err != nil {
+			s.logger.Errorf("failed close StatsD listener: %s", err.Error())
+		}
+	}()
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.flushLoop(ctx)
+	}()
+
+	buf := make([]byte, maxPacketSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				break
+			}
+			s.logger.Errorf("failed read StatsD packet: %s", err.Error())
+			continue
+		}
+
+		// Проверка подсети адреса отправителя, заголовков прокси у протокола нет
+		if !s.trusted(addr) {
+			s.logger.Errorf("StatsD packet from %s is not trusted", addr)
+			continue
+		}
+
+		s.handlePacket(buf[:n])
+	}
+
+	wg.Wait()
+}
+
+// trusted сообщает, входит ли адрес отправителя в доверенные подсети
+func (s *Server) trusted(addr net.Addr) bool {
+	if len(s.subnets) == 0 {
+		return true
+	}
+
+	udpAddr, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+
+	return s.subnets.Contains(udpAddr.IP)
+}
+
+// flushLoop сбрасывает агрегаты по таймеру
+func (s *Server) flushLoop(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.flush()
+			return
+		case <-ticker.C:
+			s.flush()
+		}
+	}
+}
+
+// handlePacket разбирает строки пакета, ошибочные строки пропускаются
+func (s *Server) handlePacket(packet []byte) {
+	for _, line := range strings.Split(string(packet), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		m, err := parseLine(line)
+		if err != nil {
+			s.logger.Errorf("skip StatsD line %q: %s", line, err.Error())
+			continue
+		}
+
+		if err = s.add(m, time.Now()); err != nil {
+			s.logger.Errorf("skip StatsD line %q: %s", line, err.Error())
+		}
+	}
+}
+
+// add добавляет метрику в агрегаты. Значение, переполняющее агрегат, отклоняется
+func (s *Server) add(m *metric, now time.Time) error {
+	key := models.SeriesKey(m.name, m.labels)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch m.kind {
+	case kindCounter:
+		delta := int64(math.Round(m.value / m.rate))
+		c, ok := s.counters[key]
+		if !ok {
+			c = &counter{series: m.series}
+			s.counters[key] = c
+		}
+		if (delta > 0 && c.delta > math.MaxInt64-delta) || (delta < 0 && c.delta < math.MinInt64-delta) {
+			return fmt.Errorf("counter %s overflows", m.name)
+		}
+		c.delta += delta
+
+	case kindGauge:
+		g, ok := s.gauges[key]
+		if !ok {
+			g = &gauge{series: m.series}
+			s.gauges[key] = g
+		}
+		value := m.value
+		if m.relative {
+			value += g.value
+		}
+		if math.IsInf(value, 0) {
+			return fmt.Errorf("gauge %s overflows", m.name)
+		}
+		g.value = value
+		g.updated = true
+		g.seen = now
+
+	case kindTimer:
+		t, ok := s.timers[key]
+		if !ok {
+			t = &timer{series: m.series}
+			s.timers[key] = t
+		}
+		t.add(m.value)
+	}
+
+	return nil
+}
+
+// add добавляет значение таймера. Выборка ограничена maxTimerSamples,
+// сверх лимита значение заменяет случайное значение выборки (reservoir sampling)
+func (t *timer) add(value float64) {
+	if t.count == 0 || value < t.min {
+		t.min = value
+	}
+	if t.count == 0 || value > t.max {
+		t.max = value
+	}
+	t.count++
+	t.sum += value
+
+	if len(t.values) < maxTimerSamples {
+		t.values = append(t.values, value)
+		return
+	}
+	if i := rand.Int64N(t.count); i < maxTimerSamples {
+		t.values[i] = value
+	}
+}
+
+// merge добавляет к таймеру значения другого таймера того же ключа
+func (t *timer) merge(other *timer) {
+	if other.count == 0 {
+		return
+	}
+	if t.count == 0 || other.min < t.min {
+		t.min = other.min
+	}
+	if t.count == 0 || other.max > t.max {
+		t.max = other.max
+	}
+	t.count += other.count
+	t.sum += other.sum
+
+	for _, value := range other.values {
+		if len(t.values) >= maxTimerSamples {
+			break
+		}
+		t.values = append(t.values, value)
+	}
+}
+
+// flush записывает агрегаты интервала в хранилище.
+// Счетчики передаются приращением, таймеры - gauge статистиками и счетчиком количества.
+// При ошибке записи агрегаты возвращаются и записываются при следующем сбросе
+func (s *Server) flush() {
+	snap := s.collect(time.Now())
+	batch := snap.batch()
+	if len(batch) == 0 {
+		return
+	}
+
+	if err := s.updater.UpdateBatch(batch); err != nil {
+		s.logger.Errorf("failed write StatsD metrics: %s", err.Error())
+		s.restore(snap)
+		return
+	}
+
+	s.logger.Debugf("StatsD flushed %d metrics", len(batch))
+}
+
+// collect изымает агрегаты интервала, забытые gauge удаляются
+func (s *Server) collect(now time.Time) *snapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := &snapshot{
+		counters: s.counters,
+		gauges:   make(map[string]*gauge),
+		timers:   s.timers,
+	}
+	s.counters = make(map[string]*counter)
+	s.timers = make(map[string]*timer)
+
+	for key, g := range s.gauges {
+		switch {
+		case g.updated:
+			updated := *g
+			snap.gauges[key] = &updated
+			g.updated = false
+		case now.Sub(g.seen) > gaugeTTL:
+			delete(s.gauges, key)
+		}
+	}
+
+	return snap
+}
+
+// restore возвращает незаписанные агрегаты. Значения, полученные после изъятия, не теряются:
+// счетчики и таймеры складываются, у gauge остается более новое значение
+func (s *Server) restore(snap *snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for key, c := range snap.counters {
+		current, ok := s.counters[key]
+		if !ok {
+			s.counters[key] = c
+			continue
+		}
+		if (c.delta > 0 && current.delta > math.MaxInt64-c.delta) || (c.delta < 0 && current.delta < math.MinInt64-c.delta) {
+			s.logger.Errorf("drop StatsD counter %s: restored delta overflows", c.name)
+			continue
+		}
+		current.delta += c.delta
+	}
+
+	for key, g := range snap.gauges {
+		current, ok := s.gauges[key]
+		if !ok {
+			s.gauges[key] = g
+			continue
+		}
+		current.updated = true
+	}
+
+	for key, t := range snap.timers {
+		current, ok := s.timers[key]
+		if !ok {
+			s.timers[key] = t
+			continue
+		}
+		current.merge(t)
+	}
+}
+
+// batch собирает метрики хранилища из агрегатов
+func (snap *snapshot) batch() []*models.Data {
+	batch := make([]*models.Data, 0, len(snap.counters)+len(snap.gauges)+len(snap.timers)*5)
+	for _, c := range snap.counters {
+		batch = append(batch, newCounter(c.name, c.labels, c.delta))
+	}
+
+	for _, g := range snap.gauges {
+		batch = append(batch, newGauge(g.name, g.labels, g.value))
+	}
+
+	for _, t := range snap.timers {
+		values := append([]float64(nil), t.values...)
+		sort.Float64s(values)
+
+		batch = append(batch,
+			newCounter(t.name+".count", t.labels, t.count),
+			newGauge(t.name+".min", t.labels, t.min),
+			newGauge(t.name+".max", t.labels, t.max),
+			newGauge(t.name+".mean", t.labels, t.sum/float64(t.count)),
+			newGauge(t.name+".p95", t.labels, percentile(values, 0.95)),
+		)
+	}
+
+	return batch
+}
+
+// percentile возвращает перцентиль отсортированных значений
+func percentile(sorted []float64, p float64) float64 {
+	idx := int(p*float64(len(sorted))+0.5) - 1
+	if idx < 0 {
+		idx = 0
+	}
+
+	return sorted[idx]
+}
+
+// newCounter создает метрику counter
+func newCounter(name string, labels map[string]string, delta int64) *models.Data {
+	return &models.Data{Type: "counter", Name: name, Delta: &delta, Labels: copyLabels(labels)}
+}
+
+// newGauge создает метрику gauge
+func newGauge(name string, labels map[string]string, value float64) *models.Data {
+	return &models.Data{Type: "gauge", Name: name, Value: &value, Labels: copyLabels(labels)}
+}
+
+// copyLabels копирует метки, чтобы хранилище не разделяло их с агрегатами
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	copied := make(map[string]string, len(labels))
+	for key, value := range labels {
+		copied[key] = value
+	}
+
+	return copied
+}
@@ -0,0 +1,248 @@
+package statsd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+
+	"metrics/internal/models"
+	"metrics/internal/storage/memory"
+	"metrics/pkg/realip"
+)
+
+// newTestServer создает слушатель без записи в хранилище для проверки агрегатов
+func newTestServer() *Server {
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+
+	return NewServer("", 0, nil, nil, logger)
+}
+
+// add разбирает строку и добавляет ее в агрегаты
+func add(t *testing.T, s *Server, line string, now time.Time) error {
+	t.Helper()
+
+	m, err := parseLine(line)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s.add(m, now)
+}
+
+// values возвращает значения батча в виде "имя значение" в порядке имен
+func values(batch []*models.Data) []string {
+	got := make([]string, 0, len(batch))
+	for _, data := range batch {
+		switch data.Type {
+		case "counter":
+			got = append(got, data.Name+" "+strconv.FormatInt(*data.Delta, 10))
+		case "gauge":
+			got = append(got, data.Name+" "+strconv.FormatFloat(*data.Value, 'g', -1, 64))
+		}
+	}
+	sort.Strings(got)
+
+	return got
+}
+
+func TestServer_Counter(t *testing.T) {
+	s := newTestServer()
+	now := time.Now()
+
+	assert.NoError(t, add(t, s, "requests:3|c", now))
+	assert.NoError(t, add(t, s, "requests:1|c|@0.25", now))
+	assert.Equal(t, []string{"requests 7"}, values(s.collect(now).batch()))
+
+	// Счетчики сбрасываются после записи
+	assert.Empty(t, s.collect(now).batch())
+
+	// Переполнение суммы отклоняется, накопленное значение сохраняется
+	assert.NoError(t, add(t, s, "requests:9e18|c", now))
+	assert.Error(t, add(t, s, "requests:9e18|c", now))
+	assert.NoError(t, add(t, s, "requests:-1|c", now))
+	assert.Equal(t, []string{"requests 8999999999999999999"}, values(s.collect(now).batch()))
+}
+
+func TestServer_Gauge(t *testing.T) {
+	s := newTestServer()
+	now := time.Now()
+
+	assert.NoError(t, add(t, s, "connections:10|g", now))
+	assert.NoError(t, add(t, s, "connections:+4|g", now))
+	assert.NoError(t, add(t, s, "connections:-2|g", now))
+	assert.Equal(t, []string{"connections 12"}, values(s.collect(now).batch()))
+
+	// Без обновлений gauge не записывается, но его значение хранится для относительных изменений
+	assert.Empty(t, s.collect(now).batch())
+	assert.NoError(t, add(t, s, "connections:+1|g", now))
+	assert.Equal(t, []string{"connections 13"}, values(s.collect(now).batch()))
+
+	// Переполнение значения отклоняется
+	assert.NoError(t, add(t, s, "load:1e308|g", now))
+	assert.Error(t, add(t, s, "load:+1e308|g", now))
+	assert.Equal(t, []string{"load 1e+308"}, values(s.collect(now).batch()))
+}
+
+func TestServer_GaugePruned(t *testing.T) {
+	s := newTestServer()
+	now := time.Now()
+
+	assert.NoError(t, add(t, s, "connections:10|g", now))
+	s.collect(now)
+
+	// Gauge без обновлений дольше gaugeTTL удаляется, относительное изменение отсчитывается от нуля
+	now = now.Add(gaugeTTL + time.Second)
+	assert.Empty(t, s.collect(now).batch())
+	assert.Empty(t, s.gauges)
+
+	assert.NoError(t, add(t, s, "connections:+1|g", now))
+	assert.Equal(t, []string{"connections 1"}, values(s.collect(now).batch()))
+}
+
+func TestServer_Timer(t *testing.T) {
+	s := newTestServer()
+	now := time.Now()
+
+	for _, line := range []string{"latency:30|ms", "latency:10|ms", "latency:20|ms"} {
+		assert.NoError(t, add(t, s, line, now))
+	}
+
+	assert.Equal(t, []string{
+		"latency.count 3",
+		"latency.max 30",
+		"latency.mean 20",
+		"latency.min 10",
+		"latency.p95 30",
+	}, values(s.collect(now).batch()))
+}
+
+func TestServer_TimerSamplesCapped(t *testing.T) {
+	s := newTestServer()
+	now := time.Now()
+
+	for i := range maxTimerSamples * 2 {
+		assert.NoError(t, add(t, s, "latency:"+strconv.Itoa(i)+"|ms", now))
+	}
+	assert.Len(t, s.timers["latency"].values, maxTimerSamples)
+
+	// Количество, сумма и границы считаются по всем значениям
+	got := values(s.collect(now).batch())
+	assert.Contains(t, got, "latency.count "+strconv.Itoa(maxTimerSamples*2))
+	assert.Contains(t, got, "latency.min 0")
+	assert.Contains(t, got, "latency.max "+strconv.Itoa(maxTimerSamples*2-1))
+	assert.Contains(t, got, "latency.mean 9999.5")
+}
+
+// failingUpdater - хранилище, возвращающее ошибку записи, пока установлен err
+type failingUpdater struct {
+	*memory.MemoryStorage
+	err error
+}
+
+func (f *failingUpdater) UpdateBatch(batch []*models.Data) error {
+	if f.err != nil {
+		return f.err
+	}
+
+	return f.MemoryStorage.UpdateBatch(batch)
+}
+
+func TestServer_FlushRestoresOnError(t *testing.T) {
+	updater := &failingUpdater{MemoryStorage: memory.NewMemoryStorage(), err: errors.New("storage unavailable")}
+	logger := logrus.New()
+	logger.SetLevel(logrus.PanicLevel)
+	s := NewServer("", 0, nil, updater, logger)
+	now := time.Now()
+
+	assert.NoError(t, add(t, s, "requests:3|c", now))
+	assert.NoError(t, add(t, s, "connections:10|g", now))
+	assert.NoError(t, add(t, s, "latency:10|ms", now))
+	s.flush()
+
+	// Агрегаты неудачной записи возвращаются и складываются с новыми значениями
+	assert.NoError(t, add(t, s, "requests:2|c", now))
+	assert.NoError(t, add(t, s, "latency:30|ms", now))
+	updater.err = nil
+	s.flush()
+
+	for name, want := range map[string]string{"requests": "5", "connections": "10", "latency.count": "2", "latency.mean": "20"} {
+		data, err := updater.Read(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if assert.NotNil(t, data, name) {
+			assert.Equal(t, []string{name + " " + want}, values([]*models.Data{data}))
+		}
+	}
+}
+
+func TestServer_UntrustedSubnet(t *testing.T) {
+	tests := []struct {
+		name    string
+		subnets string
+		want    bool
+	}{
+		{name: "trusted sender", subnets: "127.0.0.0/8", want: true},
+		{name: "untrusted sender", subnets: "10.0.0.0/8", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subnets, err := realip.ParseSubnets(tt.subnets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			logger := logrus.New()
+			logger.SetLevel(logrus.PanicLevel)
+			updater := memory.NewMemoryStorage()
+			s := NewServer("", time.Hour, subnets, updater, logger)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				s.serve(ctx, conn)
+			}()
+
+			client, err := net.Dial("udp", conn.LocalAddr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() {
+				_ = client.Close()
+			})
+			if _, err = client.Write([]byte("requests:1|c")); err != nil {
+				t.Fatal(err)
+			}
+
+			// Пакет доверенного отправителя попадает в агрегаты, остальные отбрасываются
+			if tt.want {
+				assert.Eventually(t, func() bool {
+					s.mu.Lock()
+					defer s.mu.Unlock()
+					return len(s.counters) == 1
+				}, 5*time.Second, 10*time.Millisecond)
+			} else {
+				time.Sleep(100 * time.Millisecond)
+				s.mu.Lock()
+				assert.Empty(t, s.counters)
+				s.mu.Unlock()
+			}
+
+			cancel()
+			<-done
+		})
+	}
+}
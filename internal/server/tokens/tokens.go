@@ -0,0 +1,191 @@
+// Модуль tokens хранит bearer токены API и их области доступа.
+// Токены хранятся в виде SHA-256 хешей, поэтому утечка файла или таблицы не раскрывает сами токены
+package tokens
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scope - область доступа токена
+type Scope string
+
+const (
+	// ScopeWrite - запись метрик
+	ScopeWrite Scope = "write"
+
+	// ScopeRead - чтение метрик
+	ScopeRead Scope = "read"
+
+	// ScopeAdmin - полный доступ, включая профилировщик
+	ScopeAdmin Scope = "admin"
+)
+
+var (
+	// ErrUnknownToken - токен не найден в хранилище
+	ErrUnknownToken = errors.New("unknown token")
+
+	// ErrRevoked - токен отозван
+	ErrRevoked = errors.New("token revoked")
+
+	// ErrInvalidScope - неизвестная область доступа
+	ErrInvalidScope = errors.New("invalid token scope")
+)
+
+// Allows сообщает, разрешает ли область доступа токена требуемую область. Admin разрешает все
+func (s Scope) Allows(required Scope) bool {
+	return s == ScopeAdmin || s == required
+}
+
+// ParseScope проверяет область доступа
+func ParseScope(scope string) (Scope, error) {
+	switch Scope(scope) {
+	case ScopeWrite, ScopeRead, ScopeAdmin:
+		return Scope(scope), nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrInvalidScope, scope)
+	}
+}
+
+// ParseBearer извлекает токен из значения заголовка Authorization вида "Bearer <token>".
+// Схема "Token <token>" принимается для клиентов InfluxDB, например Telegraf
+func ParseBearer(header string) (string, bool) {
+	for _, prefix := range []string{"Bearer ", "Token "} {
+		if len(header) > len(prefix) && strings.EqualFold(header[:len(prefix)], prefix) {
+			return strings.TrimSpace(header[len(prefix):]), true
+		}
+	}
+
+	return "", false
+}
+
+// Hash вычисляет хеш токена для хранения и поиска
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Store - хранилище токенов по хешу.
+// Возвращает ErrUnknownToken для неизвестного токена и ErrRevoked для отозванного
+type Store interface {
+	TokenScope(tokenHash string) (Scope, error)
+}
+
+// New выбирает хранилище токенов: файл, если задан путь, иначе таблица БД при fromDB.
+// Возвращает nil, если хранилище не настроено и аутентификация по токенам отключена
+func New(file string, fromDB bool, db Store) (Store, error) {
+	switch {
+	case file != "":
+		return NewFileStore(file)
+	case fromDB:
+		if db == nil {
+			return nil, fmt.Errorf("api tokens from database require database storage")
+		}
+		return db, nil
+	default:
+		return nil, nil
+	}
+}
+
+// apiToken - запись токена в файле
+type apiToken struct {
+	Name    string `json:"name"`
+	Hash    string `json:"hash"`
+	Scope   string `json:"scope"`
+	Revoked bool   `json:"revoked"`
+}
+
+// FileStore - хранилище токенов в JSON файле.
+// Файл перечитывается при изменении, поэтому выпуск и отзыв токена не требуют перезапуска сервера
+type FileStore struct {
+	path string
+
+	mu      sync.Mutex
+	modTime time.Time
+	tokens  map[string]apiToken
+}
+
+// NewFileStore - конструктор файлового хранилища токенов
+func NewFileStore(path string) (*FileStore, error) {
+	store := &FileStore{path: path}
+
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+// TokenScope возвращает область доступа токена по хешу
+func (f *FileStore) TokenScope(tokenHash string) (Scope, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	// Перечитывание файла при изменении, при ошибке остаются прежние токены
+	if info, err := os.Stat(f.path); err == nil && !info.ModTime().Equal(f.modTime) {
+		if err = f.reload(); err != nil {
+			log.Printf("api tokens: keep previous tokens: %s", err.Error())
+		}
+	}
+
+	token, ok := f.tokens[tokenHash]
+	switch {
+	case !ok:
+		return "", ErrUnknownToken
+	case token.Revoked:
+		return "", fmt.Errorf("%w: %s", ErrRevoked, token.Name)
+	}
+
+	return Scope(token.Scope), nil
+}
+
+// load читает файл токенов под блокировкой
+func (f *FileStore) load() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.reload()
+}
+
+// reload читает файл токенов, вызывается под блокировкой
+func (f *FileStore) reload() error {
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return fmt.Errorf("failed stat api tokens file: %w", err)
+	}
+
+	fileData, err := os.ReadFile(f.path)
+	if err != nil {
+		return fmt.Errorf("failed read api tokens file: %w", err)
+	}
+
+	var records []apiToken
+	if err = json.Unmarshal(fileData, &records); err != nil {
+		return fmt.Errorf("failed unmarshal api tokens file: %w", err)
+	}
+
+	tokens := make(map[string]apiToken, len(records))
+	for _, record := range records {
+		if record.Hash == "" {
+			return fmt.Errorf("api tokens file: empty hash of token %q", record.Name)
+		}
+		if _, err = ParseScope(record.Scope); err != nil {
+			return fmt.Errorf("api tokens file: token %q: %w", record.Name, err)
+		}
+		tokens[record.Hash] = record
+	}
+
+	f.tokens = tokens
+	f.modTime = info.ModTime()
+
+	return nil
+}
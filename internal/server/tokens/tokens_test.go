@@ -0,0 +1,130 @@
+package tokens
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeTokens записывает файл токенов и сдвигает время изменения, чтобы хранилище заметило запись
+func writeTokens(t *testing.T, path string, records []apiToken, modTime time.Time) {
+	t.Helper()
+
+	fileData, err := json.Marshal(records)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, fileData, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScope_Allows(t *testing.T) {
+	tests := []struct {
+		scope    Scope
+		required Scope
+		want     bool
+	}{
+		{scope: ScopeWrite, required: ScopeWrite, want: true},
+		{scope: ScopeWrite, required: ScopeRead, want: false},
+		{scope: ScopeWrite, required: ScopeAdmin, want: false},
+		{scope: ScopeRead, required: ScopeRead, want: true},
+		{scope: ScopeRead, required: ScopeWrite, want: false},
+		{scope: ScopeAdmin, required: ScopeWrite, want: true},
+		{scope: ScopeAdmin, required: ScopeRead, want: true},
+		{scope: ScopeAdmin, required: ScopeAdmin, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.scope)+"/"+string(tt.required), func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.scope.Allows(tt.required))
+		})
+	}
+
+	_, err := ParseScope("root")
+	assert.ErrorIs(t, err, ErrInvalidScope)
+}
+
+func TestParseBearer(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+		ok     bool
+	}{
+		{header: "Bearer abc", want: "abc", ok: true},
+		{header: "bearer  abc ", want: "abc", ok: true},
+		{header: "Token abc", want: "abc", ok: true},
+		{header: "Basic abc"},
+		{header: "Bearer "},
+		{header: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			token, ok := ParseBearer(tt.header)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.want, token)
+		})
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	modTime := time.Now().Add(-time.Hour)
+	writeTokens(t, path, []apiToken{
+		{Name: "agent", Hash: Hash("write-token"), Scope: "write"},
+		{Name: "grafana", Hash: Hash("read-token"), Scope: "read"},
+	}, modTime)
+
+	store, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scope, err := store.TokenScope(Hash("write-token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ScopeWrite, scope)
+
+	_, err = store.TokenScope(Hash("other"))
+	assert.ErrorIs(t, err, ErrUnknownToken)
+
+	// Отзыв токена применяется без перезапуска
+	writeTokens(t, path, []apiToken{
+		{Name: "agent", Hash: Hash("write-token"), Scope: "write", Revoked: true},
+		{Name: "grafana", Hash: Hash("read-token"), Scope: "read"},
+	}, modTime.Add(time.Minute))
+	_, err = store.TokenScope(Hash("write-token"))
+	assert.ErrorIs(t, err, ErrRevoked)
+
+	// Ошибочный файл не сбрасывает прежние токены
+	if err = os.WriteFile(path, []byte(`[{"name":"bad","hash":"x","scope":"root"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(path, modTime.Add(2*time.Minute), modTime.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	scope, err = store.TokenScope(Hash("read-token"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, ScopeRead, scope)
+}
+
+func TestNew(t *testing.T) {
+	store, err := New("", false, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, store)
+
+	_, err = New("", true, nil)
+	assert.Error(t, err)
+
+	_, err = New(filepath.Join(t.TempDir(), "missing.json"), false, nil)
+	assert.Error(t, err)
+}
@@ -1,31 +1,62 @@
 package memory
 
 import (
+	"sort"
 	"sync"
+	"time"
 
 	"metrics/internal/models"
 )
 
+// historyLimit - максимальное количество точек истории, хранимых для одной метрики
+const historyLimit = 10000
+
 // MemoryStorage - структура хранилища памяти
 type MemoryStorage struct {
 	mu      sync.RWMutex
 	metrics map[string]*models.Data
+	history map[string][]*models.Sample
 }
 
 // NewMemoryStorage - конструктор хранилища
 func NewMemoryStorage() *MemoryStorage {
-	return &MemoryStorage{metrics: make(map[string]*models.Data)}
+	return &MemoryStorage{
+		metrics: make(map[string]*models.Data),
+		history: make(map[string][]*models.Sample),
+	}
 }
 
-// Read получает метрику из хранилища по названию
-func (m *MemoryStorage) Read(id string) (*models.Data, error) {
+// Read получает метрику из хранилища по названию и меткам.
+// Если метки не заданы и серии без меток нет, возвращает последнюю обновленную серию с таким названием
+func (m *MemoryStorage) Read(name string, labels map[string]string) (*models.Data, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
-	res, ok := m.metrics[id]
-	if !ok {
+	res, ok := m.metrics[models.SeriesKey(name, labels)]
+	if ok {
+		return res, nil
+	}
+
+	if len(labels) > 0 {
 		return nil, nil
 	}
 
+	// Поиск последней обновленной серии по времени последней точки истории
+	var updated time.Time
+	for key, data := range m.metrics {
+		if data.Name != name {
+			continue
+		}
+
+		samples := m.history[key]
+		if len(samples) == 0 {
+			continue
+		}
+
+		if last := samples[len(samples)-1].Timestamp; res == nil || last.After(updated) {
+			res, updated = data, last
+		}
+	}
+
 	return res, nil
 }
 
@@ -41,16 +72,27 @@ func (m *MemoryStorage) ReadAll() ([]*models.Data, error) {
 	return res, nil
 }
 
+// ReadRange получает точки истории серии метрики за период [from, to]
+func (m *MemoryStorage) ReadRange(name string, labels map[string]string, from time.Time, to time.Time) ([]*models.Sample, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	res := make([]*models.Sample, 0)
+	for _, sample := range m.history[models.SeriesKey(name, labels)] {
+		if sample.Timestamp.Before(from) || sample.Timestamp.After(to) {
+			continue
+		}
+		res = append(res, sample)
+	}
+
+	return res, nil
+}
+
 // Update создает новую или обновляет существующую запись метрики в хранилище
 func (m *MemoryStorage) Update(query *models.Data) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	if metric, ok := m.metrics[query.Name]; ok && query.Type == "counter" && metric.Type == query.Type {
-		*query.Delta += *metric.Delta
-	}
-
-	m.metrics[query.Name] = query
+	m.update(query, time.Now())
 
 	return nil
 }
@@ -60,12 +102,58 @@ func (m *MemoryStorage) UpdateBatch(queries []*models.Data) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	now := time.Now()
 	for _, query := range queries {
-		if metric, ok := m.metrics[query.Name]; ok && query.Type == "counter" && metric.Type == query.Type {
-			*query.Delta += *metric.Delta
-		}
-		m.metrics[query.Name] = query
+		m.update(query, now)
 	}
 
 	return nil
 }
+
+// update обновляет последнее значение серии и добавляет точку в историю.
+// Gauge со временем источника старше последней точки серии дописывается только в историю.
+// Вызывается под блокировкой на запись
+func (m *MemoryStorage) update(query *models.Data, now time.Time) {
+	key := query.SeriesKey()
+	metric, ok := m.metrics[key]
+	if ok && query.Type == "counter" && metric.Type == query.Type {
+		*query.Delta += *metric.Delta
+	}
+
+	if !ok || metric.Type != query.Type || !m.backfill(key, query) {
+		m.metrics[key] = query
+	}
+	m.appendSample(key, query, now)
+}
+
+// backfill сообщает, что точка gauge старше последней точки истории серии
+func (m *MemoryStorage) backfill(key string, query *models.Data) bool {
+	if query.Type != "gauge" || query.Timestamp == nil {
+		return false
+	}
+
+	samples := m.history[key]
+
+	return len(samples) > 0 && samples[len(samples)-1].Timestamp.After(*query.Timestamp)
+}
+
+// appendSample добавляет точку в историю серии, отбрасывая самые старые при превышении лимита.
+// Точка с переданным источником временем вставляется по порядку времени.
+// Вызывается под блокировкой на запись
+func (m *MemoryStorage) appendSample(key string, query *models.Data, timestamp time.Time) {
+	sample := query.NewSample(timestamp)
+	samples := m.history[key]
+
+	i := sort.Search(len(samples), func(i int) bool {
+		return samples[i].Timestamp.After(sample.Timestamp)
+	})
+	samples = append(samples, nil)
+	copy(samples[i+1:], samples[i:])
+	samples[i] = sample
+
+	if len(samples) > historyLimit {
+		samples = samples[len(samples)-historyLimit:]
+	}
+
+	m.history[key] = samples
+}
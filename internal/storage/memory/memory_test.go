@@ -0,0 +1,120 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"metrics/internal/models"
+)
+
+// gaugeAt собирает gauge со временем источника
+func gaugeAt(value float64, timestamp time.Time) *models.Data {
+	return &models.Data{Name: "temperature", Type: "gauge", Value: &value, Timestamp: &timestamp}
+}
+
+// counter собирает counter без времени источника
+func counter(delta int64) *models.Data {
+	return &models.Data{Name: "polls", Type: "counter", Delta: &delta, Labels: map[string]string{"host": "web1"}}
+}
+
+func TestMemoryStorage_GaugeBackfill(t *testing.T) {
+	storage := NewMemoryStorage()
+	start := time.Now().Add(-time.Hour)
+
+	if err := storage.UpdateBatch([]*models.Data{gaugeAt(3, start.Add(2*time.Minute))}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Точка старше последней попадает только в историю
+	if err := storage.UpdateBatch([]*models.Data{gaugeAt(1, start)}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := storage.Read("temperature", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotNil(t, data) {
+		assert.Equal(t, 3.0, *data.Value)
+	}
+
+	samples, err := storage.ReadRange("temperature", nil, start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, samples, 2) {
+		assert.Equal(t, 1.0, *samples[0].Value)
+		assert.Equal(t, 3.0, *samples[1].Value)
+	}
+
+	// Более новая точка обновляет последнее значение
+	if err = storage.UpdateBatch([]*models.Data{gaugeAt(5, start.Add(3*time.Minute))}); err != nil {
+		t.Fatal(err)
+	}
+	data, err = storage.Read("temperature", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotNil(t, data) {
+		assert.Equal(t, 5.0, *data.Value)
+	}
+}
+
+func TestMemoryStorage_CounterAccumulates(t *testing.T) {
+	storage := NewMemoryStorage()
+
+	for _, delta := range []int64{2, 3} {
+		if err := storage.Update(counter(delta)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := storage.UpdateBatch([]*models.Data{counter(4), counter(1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := storage.Read("polls", map[string]string{"host": "web1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotNil(t, data) {
+		assert.Equal(t, int64(10), *data.Delta)
+	}
+
+	// Серия с другими метками накапливается отдельно
+	delta := int64(7)
+	if err = storage.Update(&models.Data{Name: "polls", Type: "counter", Delta: &delta, Labels: map[string]string{"host": "web2"}}); err != nil {
+		t.Fatal(err)
+	}
+	data, err = storage.Read("polls", map[string]string{"host": "web2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.NotNil(t, data) {
+		assert.Equal(t, int64(7), *data.Delta)
+	}
+}
+
+func TestMemoryStorage_HistoryLimit(t *testing.T) {
+	storage := NewMemoryStorage()
+	start := time.Now().Add(-24 * time.Hour)
+
+	batch := make([]*models.Data, 0, historyLimit+10)
+	for i := range historyLimit + 10 {
+		batch = append(batch, gaugeAt(float64(i), start.Add(time.Duration(i)*time.Second)))
+	}
+	if err := storage.UpdateBatch(batch); err != nil {
+		t.Fatal(err)
+	}
+
+	// Остаются только последние historyLimit точек
+	samples, err := storage.ReadRange("temperature", nil, start, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if assert.Len(t, samples, historyLimit) {
+		assert.Equal(t, 10.0, *samples[0].Value)
+		assert.Equal(t, float64(historyLimit+9), *samples[len(samples)-1].Value)
+	}
+}
@@ -0,0 +1 @@
+DROP TABLE metrics_history;
\ No newline at end of file
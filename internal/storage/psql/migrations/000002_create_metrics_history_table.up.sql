@@ -0,0 +1,14 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS metrics_history(
+    id BIGSERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    type VARCHAR(10) NOT NULL,
+    value DOUBLE PRECISION,
+    delta BIGINT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX metrics_history_name_created_at ON metrics_history (name, created_at);
+
+COMMIT ;
\ No newline at end of file
@@ -0,0 +1,12 @@
+BEGIN;
+
+DROP INDEX metrics_history_series_created_at;
+ALTER TABLE metrics_history DROP COLUMN labels;
+CREATE INDEX metrics_history_name_created_at ON metrics_history (name, created_at);
+
+DELETE FROM metrics WHERE labels <> '{}'::jsonb;
+ALTER TABLE metrics DROP CONSTRAINT metrics_pkey;
+ALTER TABLE metrics DROP COLUMN labels;
+ALTER TABLE metrics ADD PRIMARY KEY (name);
+
+COMMIT ;
\ No newline at end of file
@@ -0,0 +1,11 @@
+BEGIN;
+
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}'::jsonb;
+ALTER TABLE metrics DROP CONSTRAINT metrics_pkey;
+ALTER TABLE metrics ADD PRIMARY KEY (name, labels);
+
+ALTER TABLE metrics_history ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}'::jsonb;
+DROP INDEX metrics_history_name_created_at;
+CREATE INDEX metrics_history_series_created_at ON metrics_history (name, labels, created_at);
+
+COMMIT ;
\ No newline at end of file
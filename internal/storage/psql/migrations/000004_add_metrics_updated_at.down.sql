@@ -0,0 +1,6 @@
+BEGIN;
+
+DROP INDEX metrics_name_updated_at;
+ALTER TABLE metrics DROP COLUMN updated_at;
+
+COMMIT ;
\ No newline at end of file
@@ -0,0 +1,7 @@
+BEGIN;
+
+ALTER TABLE metrics ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+
+CREATE INDEX metrics_name_updated_at ON metrics (name, updated_at);
+
+COMMIT ;
\ No newline at end of file
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS agent_keys;
\ No newline at end of file
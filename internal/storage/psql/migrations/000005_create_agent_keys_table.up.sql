@@ -0,0 +1,10 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS agent_keys(
+    agent_id VARCHAR(255) PRIMARY KEY,
+    secret VARCHAR(255) NOT NULL,
+    revoked BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+COMMIT ;
\ No newline at end of file
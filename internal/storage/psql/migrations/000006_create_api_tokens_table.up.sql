@@ -0,0 +1,11 @@
+BEGIN;
+
+CREATE TABLE IF NOT EXISTS api_tokens(
+    token_hash CHAR(64) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    scope VARCHAR(16) NOT NULL CHECK (scope IN ('write', 'read', 'admin')),
+    revoked BOOLEAN NOT NULL DEFAULT false,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+COMMIT ;
\ No newline at end of file
@@ -1,10 +1,13 @@
 package psql
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	sq "github.com/Masterminds/squirrel"
 	"github.com/golang-migrate/migrate/v4"
@@ -13,11 +16,47 @@ import (
 	_ "github.com/jackc/pgx/v5/stdlib"
 
 	"metrics/internal/models"
+	"metrics/internal/server/agents"
+	"metrics/internal/server/tokens"
 	"metrics/pkg"
 )
 
 const (
 	migrateFilesPath = "file://./internal/storage/psql/migrations"
+
+	// historyLimit - максимальное количество точек истории, хранимых для одной серии, как в хранилище в памяти
+	historyLimit = 10000
+
+	// pruneInterval - интервал удаления точек истории сверх historyLimit
+	pruneInterval = time.Minute
+
+	// Запрос удаления самых старых точек каждой серии сверх лимита
+	pruneHistoryQuery = `
+		DELETE FROM metrics_history
+		WHERE id IN (
+			SELECT id FROM (
+				SELECT id, row_number() OVER (PARTITION BY name, labels ORDER BY created_at DESC, id DESC) AS position
+				FROM metrics_history
+			) ranked
+			WHERE position > $1
+		);`
+
+	// Запрос обновления последнего значения метрики с записью точки в историю.
+	// Время точки берется из запроса, если его передал источник.
+	// Значение старше последнего обновления серии дописывается только в историю
+	upsertQuery = `
+		WITH latest AS (
+			INSERT INTO metrics (name, type, value, delta, labels, updated_at)
+			VALUES($1,$2,$3,$4,$5::jsonb,COALESCE($6::timestamptz, now()))
+			ON CONFLICT (name, labels) DO UPDATE
+			SET
+				value = CASE WHEN metrics.updated_at > excluded.updated_at THEN metrics.value ELSE excluded.value END,
+				delta = metrics.delta + excluded.delta,
+				updated_at = GREATEST(metrics.updated_at, excluded.updated_at)
+			RETURNING name, type, delta, labels
+		)
+		INSERT INTO metrics_history (name, type, value, delta, labels, created_at)
+		SELECT name, type, $3::double precision, delta, labels, COALESCE($6::timestamptz, now()) FROM latest;`
 )
 
 // DataBase - структура инстанса хранилища
@@ -55,14 +94,27 @@ func (db *DataBase) BootStrap(connectionString string) error {
 	return nil
 }
 
-// Read получает метрику из хранилища по названию
-func (db *DataBase) Read(name string) (*models.Data, error) {
+// Read получает метрику из хранилища по названию и меткам.
+// Если метки не заданы и серии без меток нет, возвращает последнюю обновленную серию с таким названием
+func (db *DataBase) Read(name string, labels map[string]string) (*models.Data, error) {
 	res := models.Data{}
 
+	encodedLabels, err := encodeLabels(labels)
+	if err != nil {
+		return nil, err
+	}
+
 	// Формирование строки запроса и аргументов
-	query, args, err := sq.Select("type, name, value, delta").
+	builder := sq.Select("type, name, value, delta, labels").
 		From("metrics").
-		Where(sq.Eq{"name": name}).
+		Where(sq.Eq{"name": name})
+	if len(labels) > 0 {
+		builder = builder.Where(sq.Expr("labels = ?::jsonb", encodedLabels))
+	} else {
+		builder = builder.OrderBy("labels = '{}'::jsonb DESC", "updated_at DESC").Limit(1)
+	}
+
+	query, args, err := builder.
 		PlaceholderFormat(sq.Dollar).
 		ToSql()
 	if err != nil {
@@ -70,14 +122,19 @@ func (db *DataBase) Read(name string) (*models.Data, error) {
 	}
 
 	// Запрос в базу
+	var rawLabels []byte
 	row := db.Instance.QueryRow(query, args...)
-	if err = row.Scan(&res.Type, &res.Name, &res.Value, &res.Delta); err != nil {
+	if err = row.Scan(&res.Type, &res.Name, &res.Value, &res.Delta, &rawLabels); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, nil
 		}
 		return nil, fmt.Errorf("scanning data: %w", err)
 	}
 
+	if res.Labels, err = decodeLabels(rawLabels); err != nil {
+		return nil, err
+	}
+
 	return &res, nil
 }
 
@@ -86,7 +143,7 @@ func (db *DataBase) ReadAll() ([]*models.Data, error) {
 	res := make([]*models.Data, 0)
 
 	// Формирование строки запроса и аргументов
-	query, args, err := sq.Select("type, name, value, delta").
+	query, args, err := sq.Select("type, name, value, delta, labels").
 		From("metrics").
 		ToSql()
 	if err != nil {
@@ -111,17 +168,74 @@ func (db *DataBase) ReadAll() ([]*models.Data, error) {
 
 	// Сканирование строк
 	for rows.Next() {
+		var rawLabels []byte
 		row := models.Data{}
-		if err = rows.Scan(&row.Type, &row.Name, &row.Value, &row.Delta); err != nil {
+		if err = rows.Scan(&row.Type, &row.Name, &row.Value, &row.Delta, &rawLabels); err != nil {
 			return nil, fmt.Errorf("scanning row: %w", err)
 		}
 
+		if row.Labels, err = decodeLabels(rawLabels); err != nil {
+			return nil, err
+		}
+
 		res = append(res, &row)
 	}
 
 	return res, nil
 }
 
+// ReadRange получает точки истории серии метрики за период [from, to]
+func (db *DataBase) ReadRange(name string, labels map[string]string, from time.Time, to time.Time) ([]*models.Sample, error) {
+	res := make([]*models.Sample, 0)
+
+	encodedLabels, err := encodeLabels(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	// Формирование строки запроса и аргументов
+	query, args, err := sq.Select("created_at, value, delta").
+		From("metrics_history").
+		Where(sq.Eq{"name": name}).
+		Where(sq.Expr("labels = ?::jsonb", encodedLabels)).
+		Where(sq.GtOrEq{"created_at": from}).
+		Where(sq.LtOrEq{"created_at": to}).
+		OrderBy("created_at").
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("building query metrics history: %w", err)
+	}
+
+	// Выполнение запроса
+	rows, err := db.Instance.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("querying metrics history: %w", err)
+	}
+
+	defer func() {
+		if err = rows.Close(); err != nil {
+			log.Printf("Read Range Closing Rows Error: %v", err)
+		}
+	}()
+
+	// Сканирование строк
+	for rows.Next() {
+		row := models.Sample{}
+		if err = rows.Scan(&row.Timestamp, &row.Value, &row.Delta); err != nil {
+			return nil, fmt.Errorf("scanning row: %w", err)
+		}
+
+		res = append(res, &row)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("rows error: %w", rows.Err())
+	}
+
+	return res, nil
+}
+
 // Update создает новую или обновляет существующую запись метрики в хранилище
 func (db *DataBase) Update(query *models.Data) error {
 	// Начало транзакции
@@ -136,18 +250,19 @@ func (db *DataBase) Update(query *models.Data) error {
 		}
 	}()
 
+	encodedLabels, err := encodeLabels(query.Labels)
+	if err != nil {
+		return err
+	}
+
 	// Выполнение запроса
-	if _, err = tx.Exec(`
-		INSERT INTO metrics (name, type, value, delta)
-		VALUES($1,$2,$3,$4)
-		ON CONFLICT (name) DO UPDATE 
-		SET
-			value = excluded.value,
-			delta = metrics.delta + excluded.delta;`,
+	if _, err = tx.Exec(upsertQuery,
 		query.Name,
 		query.Type,
 		query.Value,
-		query.Delta); err != nil {
+		query.Delta,
+		encodedLabels,
+		query.Timestamp); err != nil {
 		return fmt.Errorf("updating metrics: %w", err)
 	}
 
@@ -170,13 +285,7 @@ func (db *DataBase) UpdateBatch(queries []*models.Data) error {
 	}()
 
 	// Парсинг запроса в контексте транзакции
-	statement, err := tx.Prepare(`
-		INSERT INTO metrics (name, type, value, delta)
-		VALUES($1,$2,$3,$4)
-		ON CONFLICT (name) DO UPDATE 
-		SET 
-			value = excluded.value,
-			delta = metrics.delta + excluded.delta;`)
+	statement, err := tx.Prepare(upsertQuery)
 	if err != nil {
 		return fmt.Errorf("preparing transaction: %w", err)
 	}
@@ -189,11 +298,18 @@ func (db *DataBase) UpdateBatch(queries []*models.Data) error {
 
 	// Проход по метрикам и запись в базу
 	for _, query := range queries {
+		var encodedLabels string
+		if encodedLabels, err = encodeLabels(query.Labels); err != nil {
+			return err
+		}
+
 		if _, err = statement.Exec(
 			query.Name,
 			query.Type,
 			query.Value,
-			query.Delta); err != nil {
+			query.Delta,
+			encodedLabels,
+			query.Timestamp); err != nil {
 			return fmt.Errorf("updating metric: %w", err)
 		}
 	}
@@ -202,6 +318,43 @@ func (db *DataBase) UpdateBatch(queries []*models.Data) error {
 	return tx.Commit()
 }
 
+// PruneHistory удаляет точки истории каждой серии сверх historyLimit, возвращает количество удаленных точек
+func (db *DataBase) PruneHistory() (int64, error) {
+	result, err := db.Instance.Exec(pruneHistoryQuery, historyLimit)
+	if err != nil {
+		return 0, fmt.Errorf("pruning metrics history: %w", err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("counting pruned metrics history: %w", err)
+	}
+
+	return deleted, nil
+}
+
+// WatchHistory удаляет лишние точки истории с интервалом pruneInterval до отмены контекста
+func (db *DataBase) WatchHistory(ctx context.Context) {
+	ticker := time.NewTicker(pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			deleted, err := db.PruneHistory()
+			if err != nil {
+				log.Printf("Prune History Error: %v", err)
+				continue
+			}
+			if deleted > 0 {
+				log.Printf("Pruned %d metrics history points", deleted)
+			}
+		}
+	}
+}
+
 // Ping проверяет доступность БД
 func (db *DataBase) Ping() error {
 	if err := pkg.AnyFunc(db.Instance.Ping).WithRetry(); err != nil {
@@ -210,3 +363,106 @@ func (db *DataBase) Ping() error {
 
 	return nil
 }
+
+// AgentSecret возвращает HMAC ключ агента из таблицы agent_keys.
+// Отзыв агента: UPDATE agent_keys SET revoked = true WHERE agent_id = ...
+func (db *DataBase) AgentSecret(agentID string) (string, error) {
+	query, args, err := sq.Select("secret, revoked").
+		From("agent_keys").
+		Where(sq.Eq{"agent_id": agentID}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return "", fmt.Errorf("building query agent key: %w", err)
+	}
+
+	var secret string
+	var revoked bool
+	if err = db.Instance.QueryRow(query, args...).Scan(&secret, &revoked); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", fmt.Errorf("%w: %s", agents.ErrUnknownAgent, agentID)
+		}
+		return "", fmt.Errorf("scanning agent key: %w", err)
+	}
+
+	if revoked {
+		return "", fmt.Errorf("%w: %s", agents.ErrRevoked, agentID)
+	}
+
+	return secret, nil
+}
+
+// SetAgentSecret записывает HMAC ключ агента в таблицу agent_keys, отзыв снимается
+func (db *DataBase) SetAgentSecret(agentID string, secret string) error {
+	query, args, err := sq.Insert("agent_keys").
+		Columns("agent_id", "secret").
+		Values(agentID, secret).
+		Suffix("ON CONFLICT (agent_id) DO UPDATE SET secret = EXCLUDED.secret, revoked = false").
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return fmt.Errorf("building query set agent key: %w", err)
+	}
+
+	if _, err = db.Instance.Exec(query, args...); err != nil {
+		return fmt.Errorf("executing set agent key: %w", err)
+	}
+
+	return nil
+}
+
+// TokenScope возвращает область доступа токена API по хешу из таблицы api_tokens.
+// Отзыв токена: UPDATE api_tokens SET revoked = true WHERE name = ...
+func (db *DataBase) TokenScope(tokenHash string) (tokens.Scope, error) {
+	query, args, err := sq.Select("name, scope, revoked").
+		From("api_tokens").
+		Where(sq.Eq{"token_hash": tokenHash}).
+		PlaceholderFormat(sq.Dollar).
+		ToSql()
+	if err != nil {
+		return "", fmt.Errorf("building query api token: %w", err)
+	}
+
+	var name, scope string
+	var revoked bool
+	if err = db.Instance.QueryRow(query, args...).Scan(&name, &scope, &revoked); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", tokens.ErrUnknownToken
+		}
+		return "", fmt.Errorf("scanning api token: %w", err)
+	}
+
+	if revoked {
+		return "", fmt.Errorf("%w: %s", tokens.ErrRevoked, name)
+	}
+
+	return tokens.ParseScope(scope)
+}
+
+// encodeLabels сериализует метки серии для колонки JSONB
+func encodeLabels(labels map[string]string) (string, error) {
+	if len(labels) == 0 {
+		return "{}", nil
+	}
+
+	encoded, err := json.Marshal(labels)
+	if err != nil {
+		return "", fmt.Errorf("marshal labels: %w", err)
+	}
+
+	return string(encoded), nil
+}
+
+// decodeLabels десериализует метки серии из колонки JSONB
+func decodeLabels(raw []byte) (map[string]string, error) {
+	labels := make(map[string]string)
+	if err := json.Unmarshal(raw, &labels); err != nil {
+		return nil, fmt.Errorf("unmarshal labels: %w", err)
+	}
+
+	if len(labels) == 0 {
+		return nil, nil
+	}
+
+	return labels, nil
+}
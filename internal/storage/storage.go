@@ -1,11 +1,14 @@
 package storage
 
 import (
+	"context"
 	"log"
 
+	"metrics/internal/server/agents"
 	"metrics/internal/server/api"
 	"metrics/internal/server/grpc"
 	"metrics/internal/server/metrics"
+	"metrics/internal/server/tokens"
 	"metrics/internal/storage/memory"
 	"metrics/internal/storage/psql"
 )
@@ -15,6 +18,8 @@ type Storage struct {
 	APIStorageCommands  *api.StorageCommands
 	GRPCStorageCommands *grpc.StorageCommands
 	MetricsFileStorage  *metrics.MetricsFileStorage
+	AgentKeys           agents.Keystore
+	Tokens              tokens.Store
 	Closer              func()
 }
 
@@ -43,6 +48,10 @@ func NewStorage(dsn string, fsPath string) *Storage {
 			log.Fatal("Build Server Storage Bootstrap Error:", err)
 		}
 
+		// Удаление лишней истории метрик до закрытия хранилища
+		ctx, cancel := context.WithCancel(context.Background())
+		go psqlStorage.WatchHistory(ctx)
+
 		// Присвоение интерфейса для сервера HTTP
 		s.APIStorageCommands = api.NewStorageService(
 			psqlStorage,
@@ -59,9 +68,16 @@ func NewStorage(dsn string, fsPath string) *Storage {
 		//Присвоение интерфейса для файла с метриками
 		s.MetricsFileStorage = metrics.NewMetricsFileStorage(psqlStorage, fsPath)
 
+		// Присвоение интерфейса для ключей агентов
+		s.AgentKeys = psqlStorage
+
+		// Присвоение интерфейса для токенов API
+		s.Tokens = psqlStorage
+
 		// Передача функции закрытия подключения в инстанс
 		s.Closer = func() {
 			log.Printf("Closing Server Storage Postgres Instance")
+			cancel()
 			if err = psqlStorage.Instance.Close(); err != nil {
 				log.Printf("Closing Server Storage Instance Error: %s", err.Error())
 			}
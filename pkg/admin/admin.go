@@ -0,0 +1,118 @@
+// Модуль admin реализует отдельный служебный HTTP слушатель сервера и агента:
+// профилировщик pprof и служебные маршруты закрыты bearer токеном и не доступны на публичном порту
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// shutdownTimeout - время ожидания завершения запросов при остановке
+const shutdownTimeout = 5 * time.Second
+
+var (
+	// ErrMissingToken - в запросе нет bearer токена
+	ErrMissingToken = errors.New("missing bearer token")
+
+	// ErrInvalidToken - токен не подходит
+	ErrInvalidToken = errors.New("invalid admin token")
+)
+
+// Authorizer проверяет bearer токен запроса к служебному слушателю
+type Authorizer func(token string) error
+
+// StaticToken возвращает проверку токена из конфигурации.
+// Пустой токен не отключает проверку: такой проверке не подходит ни один токен
+func StaticToken(expected string) Authorizer {
+	return func(token string) error {
+		if expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			return ErrInvalidToken
+		}
+
+		return nil
+	}
+}
+
+// Server - структура служебного слушателя
+type Server struct {
+	Server *http.Server
+	Router chi.Router
+}
+
+// New - конструктор служебного слушателя с профилировщиком под /debug.
+// Дополнительные служебные маршруты регистрируются в Router
+func New(address string, authorize Authorizer) *Server {
+	router := chi.NewRouter()
+	router.Use(withToken(authorize))
+
+	// /debug profiler
+	router.Mount("/debug", middleware.Profiler())
+
+	return &Server{
+		Server: &http.Server{
+			Addr:    address,
+			Handler: router,
+		},
+		Router: router,
+	}
+}
+
+// Run запускает слушатель и останавливает его при отмене контекста
+func (s *Server) Run(ctx context.Context) error {
+	errCh := make(chan error, 1)
+	go func() {
+		log.Printf("Starting admin server on %v", s.Server.Addr)
+		if err := s.Server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			errCh <- fmt.Errorf("admin server: %w", err)
+		}
+		close(errCh)
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+	defer cancel()
+
+	if err := s.Server.Shutdown(shutdownCtx); err != nil {
+		return fmt.Errorf("admin server shutdown: %w", err)
+	}
+
+	return nil
+}
+
+// withToken - middleware проверяет bearer токен служебных запросов
+func withToken(authorize Authorizer) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := ""
+			if header := r.Header.Get("Authorization"); len(header) > len("Bearer ") && strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+				token = strings.TrimSpace(header[len("Bearer "):])
+			}
+
+			err := authorize(token)
+			if err != nil && token == "" {
+				err = ErrMissingToken
+			}
+			if err != nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+				http.Error(w, err.Error(), http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
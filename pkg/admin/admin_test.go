@@ -0,0 +1,45 @@
+package admin
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServer_Token(t *testing.T) {
+	server := New("", StaticToken("secret"))
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantCode      int
+	}{
+		{name: "missing token", wantCode: http.StatusUnauthorized},
+		{name: "invalid token", authorization: "Bearer other", wantCode: http.StatusUnauthorized},
+		{name: "other scheme", authorization: "Basic secret", wantCode: http.StatusUnauthorized},
+		{name: "valid token", authorization: "Bearer secret", wantCode: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)
+			if tt.authorization != "" {
+				request.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+
+			server.Router.ServeHTTP(w, request)
+			assert.Equal(t, tt.wantCode, w.Code)
+		})
+	}
+}
+
+func TestStaticToken(t *testing.T) {
+	assert.NoError(t, StaticToken("secret")("secret"))
+	assert.ErrorIs(t, StaticToken("secret")("other"), ErrInvalidToken)
+
+	// Пустой токен в конфигурации не открывает доступ
+	assert.ErrorIs(t, StaticToken("")(""), ErrInvalidToken)
+	assert.ErrorIs(t, StaticToken("")("any"), ErrInvalidToken)
+}
@@ -0,0 +1,178 @@
+// Модуль envelope реализует гибридное шифрование тела запросов:
+// случайный ключ AES-256-GCM шифрует тело, ключ шифруется RSA-OAEP с SHA-256
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+const (
+	// Header - заголовок HTTP и ключ метаданных gRPC с версией схемы шифрования
+	Header = "X-Encryption"
+
+	// KeyIDHeader - заголовок HTTP и ключ метаданных gRPC с идентификатором ключа шифрования
+	KeyIDHeader = "X-Key-ID"
+
+	// Version - версия схемы конверта. Запросы без заголовка считаются
+	// зашифрованными устаревшей схемой RSA PKCS#1 v1.5 по частям
+	Version = "v2"
+
+	keySize = 32
+)
+
+var (
+	// ErrUnknownVersion - неизвестная версия схемы шифрования
+	ErrUnknownVersion = errors.New("unknown encryption version")
+
+	// ErrLegacyDisabled - тело без версии схемы, а устаревшая схема не разрешена
+	ErrLegacyDisabled = errors.New("legacy encryption disabled")
+)
+
+// Seal шифрует тело запроса.
+// Формат: длина зашифрованного ключа (2 байта) | зашифрованный ключ | nonce | шифротекст
+func Seal(publicKey *rsa.PublicKey, plaintext []byte) ([]byte, error) {
+	// Генерация ключа тела
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed generate key: %w", err)
+	}
+
+	// Шифрование ключа тела
+	wrappedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed wrap key: %w", err)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed generate nonce: %w", err)
+	}
+
+	// Сборка конверта
+	sealed := make([]byte, 2, 2+len(wrappedKey)+len(nonce)+len(plaintext)+aead.Overhead())
+	binary.BigEndian.PutUint16(sealed, uint16(len(wrappedKey)))
+	sealed = append(sealed, wrappedKey...)
+	sealed = append(sealed, nonce...)
+
+	return aead.Seal(sealed, nonce, plaintext, nil), nil
+}
+
+// Open расшифровывает тело запроса, зашифрованное Seal
+func Open(privateKey *rsa.PrivateKey, sealed []byte) ([]byte, error) {
+	if len(sealed) < 2 {
+		return nil, fmt.Errorf("envelope too short")
+	}
+
+	// Разбор конверта
+	keyLen := int(binary.BigEndian.Uint16(sealed))
+	sealed = sealed[2:]
+	if len(sealed) < keyLen {
+		return nil, fmt.Errorf("envelope too short")
+	}
+	wrappedKey, sealed := sealed[:keyLen], sealed[keyLen:]
+
+	// Расшифровка ключа тела
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, wrappedKey, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed unwrap key: %w", err)
+	}
+
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("envelope too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed decrypt body: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+// Decrypt расшифровывает тело запроса по версии схемы из заголовка.
+// Тело без версии расшифровывается устаревшей схемой, только если allowLegacy
+func Decrypt(version string, privateKey *rsa.PrivateKey, body []byte, allowLegacy bool) ([]byte, error) {
+	switch version {
+	case Version:
+		return Open(privateKey, body)
+	case "":
+		if !allowLegacy {
+			return nil, fmt.Errorf("%w: missing %s header", ErrLegacyDisabled, Header)
+		}
+		return openLegacy(privateKey, body)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnknownVersion, version)
+	}
+}
+
+// openLegacy расшифровывает тело устаревшей схемы RSA PKCS#1 v1.5 по частям.
+// Поддерживается на время перехода агентов на конверт
+func openLegacy(privateKey *rsa.PrivateKey, body []byte) ([]byte, error) {
+	// Установка длины частей публичного ключа
+	blockLen := privateKey.PublicKey.Size()
+
+	// Дешифровка тела запроса частями
+	var decryptedBytes []byte
+	for start := 0; start < len(body); start += blockLen {
+		end := start + blockLen
+		if start+blockLen > len(body) {
+			end = len(body)
+		}
+
+		decryptedChunk, err := rsa.DecryptPKCS1v15(rand.Reader, privateKey, body[start:end])
+		if err != nil {
+			return nil, fmt.Errorf("failed decrypt legacy body: %w", err)
+		}
+
+		decryptedBytes = append(decryptedBytes, decryptedChunk...)
+	}
+
+	return decryptedBytes, nil
+}
+
+// newAEAD создает шифр AES-GCM
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed create cipher: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed create GCM: %w", err)
+	}
+
+	return aead, nil
+}
+
+// KeyID вычисляет идентификатор ключа: первые 8 байт SHA-256 от публичного ключа в формате PKIX
+func KeyID(publicKey *rsa.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return "", fmt.Errorf("failed marshal public key: %w", err)
+	}
+
+	sum := sha256.Sum256(der)
+
+	return hex.EncodeToString(sum[:8]), nil
+}
@@ -0,0 +1,109 @@
+package envelope
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestKey генерирует приватный ключ для тестов
+func newTestKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func TestSealOpen(t *testing.T) {
+	key := newTestKey(t)
+
+	for _, plaintext := range [][]byte{
+		[]byte(`[{"id":"alloc","type":"gauge","value":1}]`),
+		make([]byte, 1<<16),
+	} {
+		sealed, err := Seal(&key.PublicKey, plaintext)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		opened, err := Decrypt(Version, key, sealed, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, plaintext, opened)
+	}
+}
+
+func TestOpen_Tampered(t *testing.T) {
+	key := newTestKey(t)
+
+	sealed, err := Seal(&key.PublicKey, []byte("cpu usage=1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		sealed []byte
+	}{
+		{name: "ciphertext", sealed: flipLast(sealed)},
+		{name: "wrapped key", sealed: flipAt(sealed, 10)},
+		{name: "truncated", sealed: sealed[:len(sealed)-20]},
+		{name: "key length", sealed: flipAt(sealed, 0)},
+		{name: "empty", sealed: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Open(key, tt.sealed)
+			assert.Error(t, err)
+		})
+	}
+
+	// Конверт не открывается чужим ключом
+	_, err = Open(newTestKey(t), sealed)
+	assert.Error(t, err)
+}
+
+func TestDecrypt_Legacy(t *testing.T) {
+	key := newTestKey(t)
+	plaintext := []byte(`[{"id":"alloc","type":"gauge","value":1}]`)
+
+	legacy, err := rsa.EncryptPKCS1v15(rand.Reader, &key.PublicKey, plaintext)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Без разрешения тело без версии отклоняется
+	_, err = Decrypt("", key, legacy, false)
+	assert.ErrorIs(t, err, ErrLegacyDisabled)
+
+	// С разрешением расшифровывается устаревшей схемой
+	opened, err := Decrypt("", key, legacy, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, plaintext, opened)
+
+	// Неизвестная версия отклоняется независимо от разрешения
+	_, err = Decrypt("v9", key, legacy, true)
+	assert.ErrorIs(t, err, ErrUnknownVersion)
+}
+
+// flipAt возвращает копию с инвертированным байтом в позиции i
+func flipAt(b []byte, i int) []byte {
+	flipped := append([]byte(nil), b...)
+	flipped[i] ^= 0xff
+
+	return flipped
+}
+
+// flipLast возвращает копию с инвертированным последним байтом
+func flipLast(b []byte) []byte {
+	return flipAt(b, len(b)-1)
+}
@@ -0,0 +1,105 @@
+// Модуль realip определяет адрес клиента по адресу соединения.
+// Заголовок X-Forwarded-For учитывается только от доверенных прокси,
+// заголовок X-Real-IP - только при явном разрешении
+package realip
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+const (
+	// RealIPHeader - заголовок HTTP и ключ метаданных gRPC с адресом клиента от прокси
+	RealIPHeader = "X-Real-IP"
+
+	// ForwardedForHeader - заголовок HTTP и ключ метаданных gRPC с цепочкой адресов от прокси
+	ForwardedForHeader = "X-Forwarded-For"
+)
+
+// Subnets - список подсетей IPv4 и IPv6
+type Subnets []*net.IPNet
+
+// ParseSubnets разбирает список подсетей через запятую
+func ParseSubnets(list string) (Subnets, error) {
+	var subnets Subnets
+	for _, cidr := range strings.Split(list, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR: %w", err)
+		}
+		subnets = append(subnets, subnet)
+	}
+
+	return subnets, nil
+}
+
+// Contains сообщает, входит ли адрес в одну из подсетей
+func (s Subnets) Contains(ip net.IP) bool {
+	for _, subnet := range s {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Proxies - доверенные прокси перед сервером
+type Proxies struct {
+	// Subnets - подсети доверенных прокси
+	Subnets Subnets
+
+	// RealIP разрешает заголовок X-Real-IP, если прокси перезаписывает его адресом своего клиента
+	RealIP bool
+}
+
+// Resolve возвращает адрес клиента.
+// remoteAddr - адрес соединения в формате host:port или host,
+// realIP и forwardedFor - значения заголовков, proxies - доверенные прокси
+func Resolve(remoteAddr string, realIP string, forwardedFor string, proxies Proxies) (net.IP, error) {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+
+	peerIP := net.ParseIP(host)
+	if peerIP == nil {
+		return nil, fmt.Errorf("invalid remote address %q", remoteAddr)
+	}
+
+	// Заголовки от недоверенного соединения игнорируются
+	if !proxies.Subnets.Contains(peerIP) {
+		return peerIP, nil
+	}
+
+	// Разбор цепочки справа налево до первого адреса вне доверенных прокси:
+	// левые элементы задает сам клиент, им нельзя верить
+	if forwardedFor != "" {
+		hops := strings.Split(forwardedFor, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := net.ParseIP(strings.TrimSpace(hops[i]))
+			if ip == nil {
+				return nil, fmt.Errorf("invalid %s header %q", ForwardedForHeader, forwardedFor)
+			}
+			if i == 0 || !proxies.Subnets.Contains(ip) {
+				return ip, nil
+			}
+		}
+	}
+
+	if proxies.RealIP && realIP != "" {
+		ip := net.ParseIP(strings.TrimSpace(realIP))
+		if ip == nil {
+			return nil, fmt.Errorf("invalid %s header %q", RealIPHeader, realIP)
+		}
+		return ip, nil
+	}
+
+	return peerIP, nil
+}
@@ -0,0 +1,79 @@
+package realip
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSubnets(t *testing.T) {
+	subnets, err := ParseSubnets(" 10.0.0.0/8, fd00::/8,,")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, subnets, 2)
+
+	assert.True(t, subnets.Contains(net.ParseIP("10.1.2.3")))
+	assert.True(t, subnets.Contains(net.ParseIP("fd00::1")))
+	assert.False(t, subnets.Contains(net.ParseIP("192.168.0.1")))
+	assert.False(t, subnets.Contains(net.ParseIP("2001:db8::1")))
+
+	// Пустой список подсетей ничего не содержит
+	empty, err := ParseSubnets("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, empty.Contains(net.ParseIP("10.1.2.3")))
+
+	_, err = ParseSubnets("10.0.0.0/8,10.0.0.1")
+	assert.Error(t, err)
+}
+
+func TestResolve(t *testing.T) {
+	subnets, err := ParseSubnets("10.0.0.0/8,fd00::/8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		remoteAddr   string
+		realIP       string
+		forwardedFor string
+		trustRealIP  bool
+		want         string
+		wantErr      bool
+	}{
+		{name: "peer address", remoteAddr: "192.168.0.5:4000", want: "192.168.0.5"},
+		{name: "peer without port", remoteAddr: "192.168.0.5", want: "192.168.0.5"},
+		{name: "ipv6 peer", remoteAddr: "[2001:db8::5]:4000", want: "2001:db8::5"},
+		{name: "spoofed real ip from untrusted peer", remoteAddr: "192.168.0.5:4000", realIP: "10.0.0.7", want: "192.168.0.5"},
+		{name: "spoofed forwarded for from untrusted peer", remoteAddr: "192.168.0.5:4000", forwardedFor: "10.0.0.7", want: "192.168.0.5"},
+		{name: "real ip from trusted proxy is ignored by default", remoteAddr: "10.0.0.1:4000", realIP: "192.168.0.9", want: "10.0.0.1"},
+		{name: "real ip from trusted proxy", remoteAddr: "10.0.0.1:4000", realIP: "192.168.0.9", trustRealIP: true, want: "192.168.0.9"},
+		{name: "forwarded for wins over real ip", remoteAddr: "10.0.0.1:4000", realIP: "10.0.0.7", forwardedFor: "192.168.0.9", trustRealIP: true, want: "192.168.0.9"},
+		{name: "spoofed real ip from untrusted peer when enabled", remoteAddr: "192.168.0.5:4000", realIP: "10.0.0.7", trustRealIP: true, want: "192.168.0.5"},
+		{name: "forwarded for from trusted proxy", remoteAddr: "10.0.0.1:4000", forwardedFor: "192.168.0.9", want: "192.168.0.9"},
+		{name: "forwarded chain skips trusted hops", remoteAddr: "10.0.0.1:4000", forwardedFor: "172.16.0.1, 192.168.0.9, 10.0.0.2", want: "192.168.0.9"},
+		{name: "forwarded chain ignores client set entries", remoteAddr: "10.0.0.1:4000", forwardedFor: "10.0.0.7, 192.168.0.9", want: "192.168.0.9"},
+		{name: "forwarded chain of trusted hops", remoteAddr: "[fd00::1]:4000", forwardedFor: "10.0.0.3, 10.0.0.2", want: "10.0.0.3"},
+		{name: "trusted proxy without headers", remoteAddr: "10.0.0.1:4000", want: "10.0.0.1"},
+		{name: "invalid real ip from trusted proxy", remoteAddr: "10.0.0.1:4000", realIP: "localhost", trustRealIP: true, wantErr: true},
+		{name: "invalid forwarded for from trusted proxy", remoteAddr: "10.0.0.1:4000", forwardedFor: "192.168.0.9, proxy", wantErr: true},
+		{name: "invalid remote address", remoteAddr: "pipe", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, err := Resolve(tt.remoteAddr, tt.realIP, tt.forwardedFor, Proxies{Subnets: subnets, RealIP: tt.trustRealIP})
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Equal(t, tt.want, ip.String())
+		})
+	}
+}
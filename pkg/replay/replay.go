@@ -0,0 +1,128 @@
+// Модуль replay защищает подписанные запросы от повторной отправки:
+// подпись покрывает время отправки и одноразовый nonce, сервер отклоняет устаревшие и повторные запросы
+package replay
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// TimestampHeader - заголовок HTTP и ключ метаданных gRPC со временем отправки в секундах unix
+	TimestampHeader = "X-Timestamp"
+
+	// NonceHeader - заголовок HTTP и ключ метаданных gRPC с одноразовым значением запроса
+	NonceHeader = "X-Nonce"
+
+	// DefaultWindow - окно приема по умолчанию для серверов с ключом подписи
+	DefaultWindow = 5 * time.Minute
+
+	nonceSize = 16
+)
+
+var (
+	// ErrMissing - в запросе нет времени отправки или nonce
+	ErrMissing = errors.New("missing timestamp or nonce")
+
+	// ErrStale - время отправки вне окна приема
+	ErrStale = errors.New("stale request")
+
+	// ErrReplayed - nonce уже использован
+	ErrReplayed = errors.New("replayed request")
+)
+
+// NewNonce возвращает случайный nonce
+func NewNonce() (string, error) {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("failed generate nonce: %w", err)
+	}
+
+	return hex.EncodeToString(nonce), nil
+}
+
+// Timestamp возвращает время отправки в формате заголовка
+func Timestamp(t time.Time) string {
+	return strconv.FormatInt(t.Unix(), 10)
+}
+
+// Payload собирает подписываемые данные: время отправки, nonce и тело запроса.
+// Без времени и nonce подписывается только тело, как у агентов без защиты от повторов
+func Payload(timestamp string, nonce string, body []byte) []byte {
+	if timestamp == "" && nonce == "" {
+		return body
+	}
+
+	payload := make([]byte, 0, len(timestamp)+len(nonce)+len(body)+2)
+	payload = append(payload, timestamp...)
+	payload = append(payload, '\n')
+	payload = append(payload, nonce...)
+	payload = append(payload, '\n')
+
+	return append(payload, body...)
+}
+
+// Guard - проверка времени отправки и уникальности nonce в пределах окна
+type Guard struct {
+	window time.Duration
+	now    func() time.Time
+
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	pruned time.Time
+}
+
+// NewGuard - конструктор проверки повторов с окном приема window
+func NewGuard(window time.Duration) *Guard {
+	return &Guard{
+		window: window,
+		now:    time.Now,
+		seen:   make(map[string]time.Time),
+		pruned: time.Now(),
+	}
+}
+
+// Check проверяет время отправки и запоминает nonce.
+// Вызывается после проверки подписи, чтобы неподписанные запросы не заполняли память
+func (g *Guard) Check(timestamp string, nonce string) error {
+	if timestamp == "" || nonce == "" {
+		return ErrMissing
+	}
+
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q: %w", timestamp, err)
+	}
+
+	// Проверка окна приема в обе стороны с учетом расхождения часов
+	now := g.now()
+	sent := time.Unix(seconds, 0)
+	if sent.Before(now.Add(-g.window)) || sent.After(now.Add(g.window)) {
+		return fmt.Errorf("%w: sent at %s", ErrStale, sent.UTC().Format(time.RFC3339))
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// Очистка nonce вне окна, повтор с ними будет отклонен по времени
+	if now.Sub(g.pruned) > g.window {
+		for key, expires := range g.seen {
+			if now.After(expires) {
+				delete(g.seen, key)
+			}
+		}
+		g.pruned = now
+	}
+
+	if _, ok := g.seen[nonce]; ok {
+		return ErrReplayed
+	}
+	g.seen[nonce] = sent.Add(g.window)
+
+	return nil
+}
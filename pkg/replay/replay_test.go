@@ -0,0 +1,85 @@
+package replay
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// newTestGuard создает проверку повторов с управляемым временем
+func newTestGuard(window time.Duration, now *time.Time) *Guard {
+	guard := NewGuard(window)
+	guard.now = func() time.Time { return *now }
+	guard.pruned = *now
+
+	return guard
+}
+
+func TestGuard_Check(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name      string
+		timestamp string
+		nonce     string
+		wantErr   error
+	}{
+		{name: "fresh", timestamp: Timestamp(now), nonce: "a"},
+		{name: "within past window", timestamp: Timestamp(now.Add(-4 * time.Minute)), nonce: "b"},
+		{name: "within clock skew", timestamp: Timestamp(now.Add(4 * time.Minute)), nonce: "c"},
+		{name: "expired", timestamp: Timestamp(now.Add(-6 * time.Minute)), nonce: "d", wantErr: ErrStale},
+		{name: "future beyond skew", timestamp: Timestamp(now.Add(6 * time.Minute)), nonce: "e", wantErr: ErrStale},
+		{name: "missing nonce", timestamp: Timestamp(now), wantErr: ErrMissing},
+		{name: "missing timestamp", nonce: "f", wantErr: ErrMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			guard := newTestGuard(DefaultWindow, &now)
+
+			err := guard.Check(tt.timestamp, tt.nonce)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+
+	// Время не числом отклоняется
+	assert.Error(t, newTestGuard(DefaultWindow, &now).Check("yesterday", "g"))
+}
+
+func TestGuard_DuplicateNonce(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	guard := newTestGuard(DefaultWindow, &now)
+
+	assert.NoError(t, guard.Check(Timestamp(now), "nonce"))
+	assert.ErrorIs(t, guard.Check(Timestamp(now), "nonce"), ErrReplayed)
+
+	// Повтор с новым временем отправки тоже отклоняется, пока nonce в окне
+	now = now.Add(time.Minute)
+	assert.ErrorIs(t, guard.Check(Timestamp(now), "nonce"), ErrReplayed)
+}
+
+func TestGuard_Eviction(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	guard := newTestGuard(time.Minute, &now)
+
+	assert.NoError(t, guard.Check(Timestamp(now), "old"))
+	assert.Len(t, guard.seen, 1)
+
+	// После окна nonce удаляется при следующей проверке, а повтор отклоняется по времени
+	now = now.Add(2 * time.Minute)
+	assert.NoError(t, guard.Check(Timestamp(now), "new"))
+	assert.Len(t, guard.seen, 1)
+	assert.ErrorIs(t, guard.Check(Timestamp(now.Add(-2*time.Minute)), "old"), ErrStale)
+}
+
+func TestPayload(t *testing.T) {
+	body := []byte(`{"id":"alloc"}`)
+
+	// Без времени и nonce подписывается только тело
+	assert.Equal(t, body, Payload("", "", body))
+	assert.Equal(t, []byte("1700000000\nabc\n"+string(body)), Payload("1700000000", "abc", body))
+}
@@ -0,0 +1,135 @@
+// Модуль tlsconfig собирает конфигурацию TLS для серверов и клиентов
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// NewServer собирает конфигурацию TLS сервера.
+// При заданном clientCAFile сервер требует сертификат клиента, подписанный этим CA
+func NewServer(certFile string, keyFile string, clientCAFile string) (*tls.Config, error) {
+	// Проверка сертификатов клиентов без TLS сервера невозможна, поэтому CA без сертификата - ошибка конфигурации
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("TLS requires server certificate and key")
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed load server key pair: %w", err)
+	}
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	// Проверка сертификатов клиентов
+	if clientCAFile != "" {
+		if config.ClientCAs, err = loadPool(clientCAFile); err != nil {
+			return nil, fmt.Errorf("failed load client CA: %w", err)
+		}
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// NewClient собирает конфигурацию TLS клиента.
+// При заданных certFile и keyFile клиент предъявляет сертификат серверу
+func NewClient(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	var err error
+	config := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+
+	// Пул корневых сертификатов, по умолчанию системный
+	if caFile != "" {
+		if config.RootCAs, err = loadPool(caFile); err != nil {
+			return nil, fmt.Errorf("failed load CA: %w", err)
+		}
+	}
+
+	// Сертификат клиента перечитывается при изменении файла, чтобы продленный сертификат
+	// использовался в новых соединениях без перезапуска
+	if certFile != "" || keyFile != "" {
+		reloader := &certReloader{certFile: certFile, keyFile: keyFile}
+		if err = reloader.load(); err != nil {
+			return nil, err
+		}
+		config.GetClientCertificate = reloader.clientCertificate
+	}
+
+	return config, nil
+}
+
+// loadPool читает pem файл сертификатов в пул
+func loadPool(file string) (*x509.CertPool, error) {
+	caPEM, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed read %s: %w", file, err)
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", file)
+	}
+
+	return pool, nil
+}
+
+// certReloader - сертификат клиента, перечитываемый при изменении файла
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// clientCertificate возвращает актуальный сертификат клиента.
+// При ошибке чтения остается предыдущий сертификат
+func (c *certReloader) clientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if info, err := os.Stat(c.certFile); err == nil && !info.ModTime().Equal(c.modTime) {
+		if err = c.reload(); err != nil {
+			log.Printf("tls: keep previous client certificate: %s", err.Error())
+		}
+	}
+
+	return c.cert, nil
+}
+
+// load читает сертификат под блокировкой
+func (c *certReloader) load() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.reload()
+}
+
+// reload читает сертификат, вызывается под блокировкой
+func (c *certReloader) reload() error {
+	info, err := os.Stat(c.certFile)
+	if err != nil {
+		return fmt.Errorf("failed stat client certificate: %w", err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed load client key pair: %w", err)
+	}
+
+	c.cert = &cert
+	c.modTime = info.ModTime()
+
+	return nil
+}
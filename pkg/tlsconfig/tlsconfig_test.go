@@ -0,0 +1,142 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCert записывает самоподписанный сертификат и ключ с заданным CommonName
+// и сдвигает время изменения файла сертификата
+func writeCert(t *testing.T, certFile string, keyFile string, name string, modTime time.Time) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(certFile, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// commonName возвращает CommonName сертификата клиента
+func commonName(t *testing.T, cert *tls.Certificate) string {
+	t.Helper()
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return leaf.Subject.CommonName
+}
+
+func TestNewServer(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "server.crt")
+	keyFile := filepath.Join(dir, "server.key")
+	writeCert(t, certFile, keyFile, "server", time.Now())
+
+	config, err := NewServer(certFile, keyFile, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, tls.NoClientCert, config.ClientAuth)
+
+	// CA клиентов включает обязательную проверку сертификата клиента
+	config, err = NewServer(certFile, keyFile, certFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, tls.RequireAndVerifyClientCert, config.ClientAuth)
+
+	// CA клиентов без сертификата сервера - ошибка конфигурации
+	_, err = NewServer("", "", certFile)
+	assert.Error(t, err)
+
+	_, err = NewServer(certFile, keyFile, keyFile)
+	assert.Error(t, err)
+}
+
+func TestNewClient_ReloadsCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	modTime := time.Now().Add(-time.Hour)
+	writeCert(t, certFile, keyFile, "agent-1", modTime)
+
+	config, err := NewClient("", certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := config.GetClientCertificate(&tls.CertificateRequestInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "agent-1", commonName(t, cert))
+
+	// Продленный сертификат используется без перезапуска
+	writeCert(t, certFile, keyFile, "agent-2", modTime.Add(time.Minute))
+	cert, err = config.GetClientCertificate(&tls.CertificateRequestInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "agent-2", commonName(t, cert))
+
+	// Ошибочный файл не сбрасывает прежний сертификат
+	if err = os.WriteFile(certFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Chtimes(certFile, modTime.Add(2*time.Minute), modTime.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	cert, err = config.GetClientCertificate(&tls.CertificateRequestInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "agent-2", commonName(t, cert))
+
+	// Клиент без сертификата не предъявляет его серверу
+	config, err = NewClient("", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, config.GetClientCertificate)
+}
@@ -43,3 +43,12 @@ func (af AnyFunc) WithRetry() error {
 }
 
 type ContextKey struct{}
+
+// InstanceKey - ключ контекста с идентификатором инстанса агента
+type InstanceKey struct{}
+
+// EncryptionKey - ключ контекста с версией схемы шифрования тела запроса
+type EncryptionKey struct{}
+
+// KeyIDKey - ключ контекста с идентификатором ключа шифрования тела запроса
+type KeyIDKey struct{}